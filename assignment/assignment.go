@@ -0,0 +1,264 @@
+// Package assignment finds the routes of a demand in dynamic user
+// equilibrium. It runs the simulation repeatedly and moves a shrinking share
+// of the vehicles to the routes that were fastest in the last run, by the
+// method of successive averages, until no vehicle could save time by
+// switching.
+package assignment
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"pchpc/demand"
+	"pchpc/routing"
+	"pchpc/streets"
+	"pchpc/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Config configures an assignment
+type Config struct {
+	// Iterations is the number of runs of the simulation
+	Iterations int
+	// MaxTicks bounds every run, vehicles still driving after it are taken
+	// off the graph. 0 runs until all vehicles parked.
+	MaxTicks int
+	// GridlockTicks is the number of ticks a gridlock lasts before the first
+	// vehicles of its edges are taken off the graph, 0 for never
+	GridlockTicks int
+	// MinSpeed and MaxSpeed bound the initial speed of the vehicles
+	MinSpeed, MaxSpeed float64
+	// DT is the length of a tick in seconds
+	DT float64
+	// Model is the name of the car-following model, see streets.NewCarFollowingModel
+	Model string
+	// Signals generates traffic signals at the vertices with three or more
+	// incoming edges and no plan in the graph
+	Signals bool
+	// Seed seeds the vehicles and the choice of the vehicles switching
+	// routes. The vehicle of a trip is the same in every run.
+	Seed int64
+}
+
+// Iteration reports a run of the simulation
+type Iteration struct {
+	Iteration int `json:"iteration"`
+	// Reassigned is the number of vehicles moved to a new route before the run
+	Reassigned int `json:"reassigned"`
+	// Ticks is the number of ticks of the run
+	Ticks int `json:"ticks"`
+	// Parked is the number of vehicles which reached their destination
+	Parked int `json:"parked"`
+	// TravelTime is the total travel time in seconds of the parked vehicles
+	TravelTime float64 `json:"travel_time"`
+	// Gap is the relative gap, the share of the travel time of the routes by
+	// the experienced travel times of the edges the vehicles would save on
+	// the fastest routes, 0 in equilibrium
+	Gap float64 `json:"gap"`
+}
+
+// Result is the outcome of an assignment
+type Result struct {
+	Iterations []Iteration `json:"iterations"`
+	// Routes are the routes of the last run by the index of their trip
+	Routes map[int][]int `json:"routes"`
+}
+
+// vehicleRand returns the random stream of the vehicle of the trip at index,
+// the same stream as in a distributed run with the same seed
+func vehicleRand(seed int64, index int) utils.RNG {
+	root := utils.NewRNG(seed)
+	return root.Stream(uint64(index))
+}
+
+// choiceRand returns the random stream deciding if the vehicle of the trip at
+// index switches routes after the iteration
+func choiceRand(seed int64, index, iteration int) utils.RNG {
+	rng := vehicleRand(seed, index)
+	return rng.Stream(uint64(iteration) + 2)
+}
+
+// Run assigns the trips to routes on g. The first run follows the fastest
+// routes by free-flow travel time. After run n every vehicle switches to the
+// fastest route by the travel times experienced in it with probability
+// 1/(n+1), if that route is faster than its own. Trips without a route are
+// dropped.
+func Run(g *streets.StreetGraph, trips []demand.Trip, cfg Config) (Result, error) {
+	if cfg.Iterations < 1 {
+		return Result{}, errors.New("an assignment needs at least one iteration")
+	}
+	if cfg.DT <= 0 {
+		cfg.DT = 1
+	}
+	model, err := streets.NewCarFollowingModel(cfg.Model)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create car-following model.")
+		return Result{}, err
+	}
+	signals, err := g.SignalPlans(cfg.Signals)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get signal plans.")
+		return Result{}, err
+	}
+	freeFlow, err := routing.NewAStar(g.Graph, routing.TravelTime)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create router.")
+		return Result{}, err
+	}
+
+	routes := make(map[int][]int, len(trips))
+	planned := make([]demand.Trip, 0, len(trips))
+	for _, t := range trips {
+		path, err := freeFlow.ShortestPath(t.Origin, t.Destination)
+		if err != nil {
+			log.Warn().Msgf("No route from %d to %d, dropping trip %d.", t.Origin, t.Destination, t.Index)
+			continue
+		}
+		routes[t.Index] = path
+		planned = append(planned, t)
+	}
+
+	result := Result{Iterations: make([]Iteration, 0, cfg.Iterations)}
+	reassigned := 0
+	for n := 1; n <= cfg.Iterations; n++ {
+		engine, err := newEngine(g, model, signals, cfg.DT)
+		if err != nil {
+			return Result{}, err
+		}
+		it := simulate(engine, g, planned, routes, cfg)
+		it.Iteration, it.Reassigned = n, reassigned
+
+		times, err := experiencedTimes(g, engine.EdgeCounters(), cfg.DT)
+		if err != nil {
+			return Result{}, err
+		}
+		router := freeFlow.Reweighted(times)
+
+		// the fastest routes by the experienced times, and their savings
+		current, best := 0.0, 0.0
+		fastest := make(map[int][]int, len(planned))
+		for _, t := range planned {
+			path, err := router.ShortestPath(t.Origin, t.Destination)
+			if err != nil {
+				return Result{}, err
+			}
+			fastest[t.Index] = path
+			current += routeTime(routes[t.Index], times)
+			best += routeTime(path, times)
+		}
+		if current > 0 {
+			it.Gap = (current - best) / current
+		}
+		result.Iterations = append(result.Iterations, it)
+		log.Info().Msgf("Iteration %d: relative gap %.4f, travel time %.0fs, %d of %d vehicles parked, %d reassigned",
+			n, it.Gap, it.TravelTime, it.Parked, len(planned), it.Reassigned)
+
+		if n == cfg.Iterations {
+			break
+		}
+		reassigned = 0
+		for _, t := range planned {
+			rng := choiceRand(cfg.Seed, t.Index, n)
+			path := fastest[t.Index]
+			if rng.Float64() >= 1/float64(n+1) || routeTime(path, times) >= routeTime(routes[t.Index], times) {
+				continue
+			}
+			routes[t.Index] = path
+			reassigned++
+		}
+	}
+
+	result.Routes = routes
+	return result, nil
+}
+
+// newEngine returns an engine driving on g with the model and signals
+func newEngine(
+	g *streets.StreetGraph,
+	model streets.CarFollowingModel,
+	signals []streets.SignalPlan,
+	dt float64,
+) (*streets.Engine, error) {
+	engine := streets.NewEngine(dt)
+	engine.Model = model
+	engine.SetSignals(signals)
+	if err := engine.SetJunctions(g.Graph); err != nil {
+		log.Error().Err(err).Msg("Failed to find junctions.")
+		return nil, err
+	}
+	return engine, nil
+}
+
+// simulate drives the vehicles of the trips along their routes until all of
+// them parked or the run reaches cfg.MaxTicks, and takes the vehicles still
+// driving off the graph. Vehicles stuck in a gridlock for cfg.GridlockTicks
+// are taken off it earlier.
+func simulate(engine *streets.Engine, g *streets.StreetGraph, trips []demand.Trip, routes map[int][]int, cfg Config) Iteration {
+	vehicles := make([]streets.Vehicle, len(trips))
+	for i, t := range trips {
+		rng := vehicleRand(cfg.Seed, t.Index)
+		speed := rng.Between(cfg.MinSpeed, cfg.MaxSpeed)
+		path := append([]int(nil), routes[t.Index]...)
+		vehicles[i] = streets.NewVehicle(strconv.Itoa(t.Index), speed, path, &g.Graph)
+		vehicles[i].SetRNG(rng)
+		vehicles[i].DepartureTick = int(t.Departure / cfg.DT)
+		engine.Add(&vehicles[i])
+	}
+
+	it := Iteration{}
+	gridlocks := streets.NewGridlockTracker(cfg.GridlockTicks)
+	for engine.Len() > 0 && (cfg.MaxTicks <= 0 || engine.Tick < cfg.MaxTicks) {
+		for _, v := range engine.Step() {
+			it.Parked++
+			it.TravelTime += float64(v.ArrivalTick-v.DepartureTick) * cfg.DT
+		}
+		_, expired := gridlocks.Update(engine.BlockedEdges(), engine.Tick)
+		for _, g := range expired {
+			engine.Unblock(g)
+		}
+	}
+	for _, v := range engine.Vehicles() {
+		v.Detach()
+	}
+	it.Ticks = engine.Tick
+	return it
+}
+
+// experiencedTimes returns the travel time in seconds of every edge of g: the
+// mean time the vehicles spent on it by the counters, at least the free-flow
+// travel time, which vehicles passing an edge within a tick undercut
+func experiencedTimes(g *streets.StreetGraph, counters []streets.EdgeCounter, dt float64) (map[[2]int]float64, error) {
+	edges, err := g.Graph.Edges()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get edges.")
+		return nil, err
+	}
+
+	times := make(map[[2]int]float64, len(edges))
+	for _, e := range edges {
+		data, err := streets.GetEdgeData(e)
+		if err != nil {
+			return nil, err
+		}
+		// an empty edge is passed at the speed limit
+		times[[2]int{e.Source, e.Target}] = data.TravelTime()
+	}
+	for _, c := range counters {
+		key := [2]int{c.Source, c.Target}
+		if free, ok := times[key]; ok && c.Entered > 0 {
+			times[key] = math.Max(free, float64(c.VehicleTicks)*dt/float64(c.Entered))
+		}
+	}
+	return times, nil
+}
+
+// routeTime returns the travel time of the route by the times of its edges
+func routeTime(route []int, times map[[2]int]float64) float64 {
+	total := 0.0
+	for i := 1; i < len(route); i++ {
+		total += times[[2]int{route[i-1], route[i]}]
+	}
+	return total
+}
@@ -0,0 +1,85 @@
+package assignment
+
+import (
+	"testing"
+
+	"pchpc/demand"
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/rs/zerolog"
+)
+
+// bottleneck builds a single-lane direct street 1 -> 2 and a longer detour
+// over 3, and trips from 1 to 2 departing every second, more than the
+// direct street carries without queueing
+func bottleneck(t *testing.T) (*streets.StreetGraph, []demand.Trip) {
+	t.Helper()
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	g, err := streets.NewGraphBuilder().
+		WithVertices([]streets.JVertex{{ID: 1, X: 10, Y: 50}, {ID: 2, X: 10.004, Y: 50}, {ID: 3, X: 10.002, Y: 50.002}}).
+		WithEdges([]streets.JEdge{
+			{From: 1, To: 2, Length: 300, MaxSpeed: "50", Lanes: 1, ID: "direct"},
+			{From: 1, To: 3, Length: 200, MaxSpeed: "50", Lanes: 1, ID: "detour-1"},
+			{From: 3, To: 2, Length: 200, MaxSpeed: "50", Lanes: 1, ID: "detour-2"},
+		}).
+		WithRectangleParts(1).SetTopRightBottomLeftVertices().DivideGraphsIntoRects().
+		PickRect(0).FilterForRect().IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trips := make([]demand.Trip, 60)
+	for i := range trips {
+		trips[i] = demand.Trip{Index: i, Origin: 1, Destination: 2, Departure: float64(i)}
+	}
+	return g, trips
+}
+
+func TestRun(t *testing.T) {
+	g, trips := bottleneck(t)
+	cfg := Config{Iterations: 6, DT: 1, MinSpeed: 5.5, MaxSpeed: 8.5, Seed: 3}
+
+	result, err := Run(g, trips, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, cfg.Iterations, len(result.Iterations))
+	for i, it := range result.Iterations {
+		assert.Equal(t, i+1, it.Iteration)
+		assert.Equal(t, len(trips), it.Parked)
+	}
+
+	// everybody takes the direct street first, which jams
+	first, last := result.Iterations[0], result.Iterations[len(result.Iterations)-1]
+	assert.Equal(t, 0, first.Reassigned)
+	assert.True(t, first.Gap > 0.5)
+	assert.True(t, result.Iterations[1].Reassigned > 0)
+
+	// switching spreads the vehicles over both routes
+	assert.True(t, last.Gap < first.Gap)
+	assert.True(t, last.TravelTime < first.TravelTime)
+	detours := 0
+	for _, route := range result.Routes {
+		if len(route) == 3 {
+			detours++
+		}
+	}
+	assert.True(t, detours > 0 && detours < len(trips))
+
+	// the same seed assigns the same routes
+	again, err := Run(g, trips, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.Iterations, again.Iterations)
+	assert.Equal(t, result.Routes, again.Routes)
+}
+
+func TestRun_NoIterations(t *testing.T) {
+	g, trips := bottleneck(t)
+	_, err := Run(g, trips, Config{})
+	assert.True(t, err != nil)
+}
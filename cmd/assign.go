@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"pchpc/assignment"
+	"pchpc/sim"
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// assign runs the assign subcommand, assigning the trips of cfg, the random
+// ones or those of the OD matrix of its demand file, to routes in dynamic user
+// equilibrium and printing the relative gap of every iteration
+func assign(cfg sim.Config, args []string) error {
+	flags := flag.NewFlagSet("assign", flag.ContinueOnError)
+	iterations := flags.Int("iterations", 10, "Number of runs of the simulation")
+	maxTicks := flags.Int("max-ticks", cfg.MaxTicks, "Number of ticks a run stops after, 0 until all vehicles parked")
+	out := flags.String("out", "", "Path of the JSON file the iterations and the routes are written to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	root, _ := streets.DefaultGraph(cfg.GraphFile, 1)
+	// the same trips a simulation with the same seed drives
+	trips, err := sim.Trips(root, cfg)
+	if err != nil {
+		return err
+	}
+
+	result, err := assignment.Run(root, trips, assignment.Config{
+		Iterations:    *iterations,
+		MaxTicks:      *maxTicks,
+		GridlockTicks: cfg.GridlockTicks,
+		MinSpeed:      cfg.MinSpeed,
+		MaxSpeed:      cfg.MaxSpeed,
+		DT:            cfg.DT,
+		Model:         cfg.Model,
+		Signals:       cfg.Signals,
+		Seed:          cfg.Seed,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to assign routes.")
+		return err
+	}
+
+	fmt.Printf("%9s %10s %6s %7s %14s %8s\n", "iteration", "reassigned", "ticks", "parked", "travel time/s", "gap")
+	for _, it := range result.Iterations {
+		fmt.Printf("%9d %10d %6d %7d %14.0f %8.4f\n", it.Iteration, it.Reassigned, it.Ticks, it.Parked, it.TravelTime, it.Gap)
+	}
+
+	if *out == "" {
+		return nil
+	}
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal assignment.")
+		return err
+	}
+	if err := os.WriteFile(*out, bytes, 0o664); err != nil {
+		log.Error().Err(err).Msg("Failed to write assignment.")
+		return err
+	}
+	return nil
+}
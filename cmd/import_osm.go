@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"pchpc/osm"
+
+	"github.com/rs/zerolog/log"
+)
+
+// importOSM runs the import-osm subcommand, converting an OSM XML extract to a graph JSON file
+func importOSM(args []string) error {
+	flags := flag.NewFlagSet("import-osm", flag.ContinueOnError)
+	in := flags.String("in", "", "Path to the .osm XML extract")
+	out := flags.String("out", "assets/out.json", "Path of the graph JSON file to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		flags.Usage()
+		return errors.New("missing -in")
+	}
+
+	jGraph, err := osm.ImportFile(*in)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to import OSM file.")
+		return err
+	}
+
+	if len(jGraph.Graph.Edges) == 0 {
+		err := errors.New("no drivable ways found")
+		log.Error().Err(err).Msg("Failed to import OSM file.")
+		return err
+	}
+
+	jBytes, err := jGraph.Marshal()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal graph JSON.")
+		return err
+	}
+	if err := os.WriteFile(*out, jBytes, 0o664); err != nil {
+		log.Error().Err(err).Msg("Failed to write graph JSON file.")
+		return err
+	}
+
+	log.Info().Msgf("Imported %d edges from %s to %s", len(jGraph.Graph.Edges), *in, *out)
+	return nil
+}
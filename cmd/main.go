@@ -1,19 +1,15 @@
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/json"
 	"flag"
-	"math/rand"
 	"os"
-	"sync"
 
+	"pchpc/comm"
+	"pchpc/sim"
 	"pchpc/streets"
 	"pchpc/utils"
 
 	"github.com/dominikbraun/graph/draw"
-	mpi "github.com/sbromberger/gompi"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
 
@@ -23,65 +19,56 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// getVertices returns a list of vertices in the graph
-func getVertices(g *graph.Graph[int, streets.GVertex]) ([]int, error) {
-	edges, err := (*g).Edges()
+// runConfig configures a run in a single process. Vehicles is the number of
+// all vehicles, not of those per task.
+type runConfig struct {
+	sim.Config
+	// Parallel decides the speeds of the vehicles in goroutines
+	Parallel bool
+}
+
+// run creates vehicles and drives them together in ticks of cfg.DT seconds.
+// Every vehicle has its own random stream derived from the seed. The random
+// vehicles depart by the departure profile, with a demand file the trips of
+// its OD matrix replace them.
+func run(root *streets.StreetGraph, cfg runConfig) error {
+	model, err := streets.NewCarFollowingModel(cfg.Model)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get edges.")
-		return nil, err
+		log.Error().Err(err).Msg("Failed to create car-following model.")
+		return err
 	}
-
-	vertices := make(map[int]bool, 0)
-
-	for _, edge := range edges {
-		src := edge.Source
-		dst := edge.Target
-
-		vertices[src] = false
-		vertices[dst] = false
+	signals, err := root.SignalPlans(cfg.Signals)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get signal plans.")
+		return err
 	}
 
-	keys := make([]int, 0, len(vertices))
-	for k := range vertices {
-		keys = append(keys, k)
+	engine := streets.NewEngine(cfg.DT)
+	engine.Parallel = cfg.Parallel
+	engine.Model = model
+	if cfg.Rerouting > 0 {
+		engine.Rerouting = streets.NewRerouting(cfg.Rerouting)
+		if cfg.RerouteInterval > 0 {
+			engine.Rerouting.Interval = cfg.RerouteInterval
+		}
+	}
+	engine.SetSignals(signals)
+	if err := engine.SetJunctions(root.Graph); err != nil {
+		log.Error().Err(err).Msg("Failed to find junctions.")
+		return err
 	}
 
-	return keys, nil
-}
-
-// setVehicle creates a vehicle with a random path
-func setVehicle(g *graph.Graph[int, streets.GVertex], speed float64) (streets.Vehicle, error) {
-	vertices, err := getVertices(g)
+	// Create vehicles, the same as a distributed run with the same seed
+	vehicles, err := sim.Vehicles(root, cfg.Config)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get vertices.")
-		return *new(streets.Vehicle), err
+		return err
 	}
-	var path []int
-	for len(path) < 2 {
-		srcIdx := rand.Intn(len(vertices))
-		src := vertices[srcIdx]
-		destIdx := rand.Intn(len(vertices))
-		dest := vertices[destIdx]
-		path, _ = graph.ShortestPath(*g, src, dest)
+	for i := range vehicles {
+		engine.Add(&vehicles[i])
 	}
-	v := streets.NewVehicle(speed, path, g)
-	return v, nil
-}
-
-// run creates vehicles and drives them
-func run(
-	g *graph.Graph[int, streets.GVertex],
-	n *int, minSpeed *float64,
-	maxSpeed *float64,
-	useRoutines *bool,
-) {
-	// Create vehicles and drive
-	var wg sync.WaitGroup
-	p := mpb.New(mpb.WithWaitGroup(&wg))
-	j := 0
 
-	total := *n
-	bar := p.AddBar(int64(total),
+	p := mpb.New()
+	bar := p.AddBar(int64(len(vehicles)),
 		mpb.PrependDecorators(decor.Name("Vehicles arrived: "),
 			decor.Percentage(decor.WCSyncSpace)),
 		mpb.AppendDecorators(
@@ -93,56 +80,46 @@ func run(
 		),
 	)
 
-	for i := 0; i < total; i++ {
-		wg.Add(1)
-		j++
-
-		fn := func() {
-			defer func() {
-				j--
-				wg.Done()
-				bar.Increment()
-			}()
-			speed := utils.RandomFloat64(*minSpeed, *maxSpeed)
-
-			var v streets.Vehicle
+	// Drive
+	gridlocks := streets.NewGridlockTracker(cfg.GridlockTicks)
+	removed := 0
+	for engine.Len() > 0 && (cfg.MaxTicks <= 0 || engine.Tick < cfg.MaxTicks) {
+		parked := engine.Step()
+		for _, v := range parked {
+			log.Debug().Msgf("Vehicle Parked %s", v.ID)
+		}
+		bar.IncrBy(len(parked))
 
-			if utils.IsMPI() {
-				if mpi.WorldRank() == 0 {
-					panic("Rank 0 should not be creating vehicles")
-				}
-			} else {
-				vh, err := setVehicle(g, speed)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to set vehicle.")
-					return
-				}
-				v = vh
-			}
-			pushVehicle(v)
+		formed, expired := gridlocks.Update(engine.BlockedEdges(), engine.Tick)
+		for _, gl := range formed {
+			log.Warn().Msgf("Gridlock at tick %d on vertices %v", gl.Tick, gl.Vertices)
 		}
-		if *useRoutines {
-			go fn()
-		} else {
-			fn()
+		for _, gl := range expired {
+			stuck := engine.Unblock(gl)
+			removed += len(stuck)
+			bar.IncrBy(len(stuck))
+			log.Warn().Msgf("Removed %d vehicles stuck in the gridlock on vertices %v", len(stuck), gl.Vertices)
 		}
 	}
+	if engine.Len() > 0 {
+		log.Warn().Msgf("Stopped after %d ticks with %d vehicles driving", engine.Tick, engine.Len())
+		bar.Abort(false)
+	} else {
+		log.Debug().Msgf("All vehicles parked after %d ticks", engine.Tick)
+	}
+	if removed > 0 {
+		log.Info().Msgf("Vehicles removed from gridlocks: %d", removed)
+	}
+	if engine.Rerouting != nil {
+		log.Info().Msgf("Vehicles rerouted: %d", engine.Reroutes)
+	}
 
 	p.Wait()
-}
-
-func pushVehicle(v streets.Vehicle) {
-	log.Debug().Msgf("Vehicle: %s", v.String())
-	for !v.IsParked {
-		v.Step()
-		log.Debug().Msgf("Vehicle: %s", v.String())
-		v.PrintInfo()
-	}
-	log.Debug().Msgf("Vehicle Parked %s", v.ID)
+	return nil
 }
 
 // saveGraph saves the graph to a file in the current working directory
-func saveGraph(g *graph.Graph[int, streets.GVertex]) error {
+func saveGraph(g *graph.Graph[int, streets.JVertex]) error {
 	file, err := os.Create("graph.gv")
 	if err != nil {
 		return err
@@ -158,9 +135,29 @@ func main() {
 	minSpeed := flag.Float64("min-speed", 5.5, "Minimum speed")
 	maxSpeed := flag.Float64("max-speed", 8.5, "Maximum speed")
 	dbPath := flag.String("dbFile", "assets/db.sqlite", "Path to the database")
+	graphFile := flag.String("graph", "assets/out.json", "Path to the graph JSON or SQLite file")
 	exportGraph := flag.Bool("export", false, "Export graph to graph.gv (current working directory)")
 	debug := flag.Bool("debug", false, "Enable debug mode")
 	useMPI := flag.Bool("mpi", false, "Use MPI")
+	dt := flag.Float64("dt", 1.0, "Length of a simulation tick in seconds")
+	maxTicks := flag.Int("max-ticks", 0, "Number of ticks a run stops after, 0 until all vehicles parked")
+	gridlockTicks := flag.Int("gridlock-ticks", 300, "Number of ticks a gridlock lasts before its first vehicles are removed, 0 for never")
+	modelName := flag.String("model", "idm", "Car-following model: idm or krauss")
+	output := flag.String("out", "", "Path of the JSON file the merged results of an MPI run are written to")
+	halo := flag.Int("halo", 1, "Depth of the halo of ghost edges around the graph of every rank")
+	partitionerName := flag.String("partition", "strips", "Graph partitioner: strips, rcb, length or multilevel")
+	checkpointDir := flag.String("checkpoint", "", "Directory every rank of an MPI run writes checkpoints to")
+	checkpointEvery := flag.Int("checkpoint-every", 100, "Number of ticks between two checkpoints")
+	restore := flag.String("restore", "", "Directory of the checkpoints an MPI run resumes from")
+	signals := flag.Bool("signals", false, "Generate traffic signals at intersections of three or more streets")
+	seed := flag.Int64("seed", 1, "Seed of the random numbers, the same seed and number of ranks reproduce a run")
+	demandFile := flag.String("demand", "", "Path of an OD matrix JSON file replacing the random vehicles of -n")
+	departures := flag.String("departures", "none", "Departure profile of the random vehicles: none, uniform, poisson or peak")
+	routerName := flag.String("router", "fastest", "Router of the vehicles: fastest by free-flow travel time or shortest by distance")
+	hierarchy := flag.String("hierarchy", "", "Path of a Contraction Hierarchy to route with instead of A*, built and saved there if missing")
+	departureWindow := flag.Float64("departure-window", 3600, "Duration in seconds the random vehicles depart in")
+	reroute := flag.Float64("reroute", 0, "Share of the vehicles rerouting around congestion, from 0 to 1")
+	rerouteInterval := flag.Float64("reroute-interval", 60, "Time in seconds between two reroutes of a vehicle")
 
 	flag.Parse()
 
@@ -182,179 +179,67 @@ func main() {
 	multi := zerolog.MultiLevelWriter(os.Stdout, runLogFile)
 	log.Logger = zerolog.New(multi).With().Timestamp().Logger()
 
+	cfg := sim.Config{
+		GraphFile:       *graphFile,
+		Vehicles:        *n,
+		Demand:          *demandFile,
+		Departures:      *departures,
+		DepartureWindow: *departureWindow,
+		Router:          *routerName,
+		Hierarchy:       *hierarchy,
+		Rerouting:       *reroute,
+		RerouteInterval: *rerouteInterval,
+		MinSpeed:        *minSpeed,
+		MaxSpeed:        *maxSpeed,
+		DT:              *dt,
+		MaxTicks:        *maxTicks,
+		GridlockTicks:   *gridlockTicks,
+		Model:           *modelName,
+		Partitioner:     *partitionerName,
+		Halo:            *halo,
+		Signals:         *signals,
+		Seed:            *seed,
+		Output:          *output,
+		Checkpoint:      *checkpointDir,
+		CheckpointEvery: *checkpointEvery,
+		Restore:         *restore,
+	}
+
+	switch flag.Arg(0) {
+	case "import-osm":
+		if err := importOSM(flag.Args()[1:]); err != nil {
+			os.Exit(1)
+		}
+		return
+	case "partition":
+		if err := reportPartitions(*graphFile, *partitionerName, flag.Args()[1:]); err != nil {
+			os.Exit(1)
+		}
+		return
+	case "assign":
+		if err := assign(cfg, flag.Args()[1:]); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *useMPI {
-		mpi.Start(true)
-		defer mpi.Stop()
-		if !mpi.IsOn() {
-			log.Error().Msg("MPI is not on.")
+		c, err := comm.StartMPI()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start MPI.")
 			return
 		}
+		defer comm.StopMPI()
 
-		comm := mpi.NewCommunicator(nil)
-
-		numTasks := comm.Size()
-		taskID := comm.Rank()
-		rectanglesTag := 1
-		edgesTag := 2
-		vehiclesTag := 3
-
-		if taskID == 0 {
-			// "chunkify"
-
-			g := streets.NewGraph(nil, nil)
-
-			rects, err := streets.DivideGraphsIntoRects(numTasks, &g)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to divide graph.")
-				return
-			}
-			log.Debug().Msgf("MPI: Number of tasks: %d My rank: %d", numTasks, taskID)
-			log.Debug().Msgf("MPI: Number of rects: %d", len(rects))
-
-			// parse edges
-			edges, err := g.Edges()
-			rawEdges := make([]streets.RawEdge[int], len(edges))
-
-			for _, e := range edges {
-				rawEdges = append(rawEdges, streets.RawEdge[int]{
-					Source: e.Source,
-					Target: e.Target,
-				})
-			}
-
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get edges.")
-				return
-			}
-
-			// send rects to other tasks
-			for i := 1; i < numTasks; i++ {
-				var buf bytes.Buffer
-				enc := gob.NewEncoder(&buf)
-				err := enc.Encode(rects)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to encode rects.")
-					return
-				}
-				comm.SendBytes(buf.Bytes(), i, rectanglesTag)
-
-				mpi.
-					buf.Reset()
-				err = enc.Encode(rawEdges)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to encode edges.")
-					return
-				}
-				comm.SendBytes(buf.Bytes(), i, edgesTag)
-			}
-
-			// create vehicle routes
-
-			// total vehicles = n * numTasks
-			// get random nodes
-			verts, err := streets.GetVertices(&g)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get vertices.")
-				return
-			}
-
-			paths := make([][]int, 0)
-			for i := 0; i < numTasks*(*n); i++ {
-				var path []int
-				for len(path) < 2 {
-					rand.Shuffle(len(verts), func(i, j int) {
-						verts[i], verts[j] = verts[j], verts[i]
-					})
-					start := verts[0]
-					end := verts[1]
-					p, err := graph.ShortestPath(g, start.ID, end.ID)
-					if err != nil {
-						log.Debug().Err(err).Msg("Failed to get shortest path.")
-						continue
-					}
-					path = p
-				}
-				if len(path) < 2 {
-					panic("Path is too short")
-				}
-				paths = append(paths, path)
-			}
-
-			// create Vehicle objects
-			vehicles := make([]streets.Vehicle, 0)
-
-			for _, path := range paths {
-				speed := utils.RandomFloat64(*minSpeed, *maxSpeed)
-				vehicles = append(vehicles, streets.NewVehicle(speed, path, &g))
-			}
-
-			// send vehicles to other tasks
-			for i := 1; i < numTasks; i++ {
-				marshal, err := json.Marshal(vehicles)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to marshal vehicles.")
-					return
-				}
-				comm.SendBytes(marshal, i, vehiclesTag)
-				log.Debug().Msgf("MPI: Sent %d vehicles to task %d", len(vehicles), i)
-				// log.Debug().Msgf("MPI: Sent %s", string(marshal))
-			}
-
-		} else {
-			myId := comm.Rank()
-			// receive rects from task 0
-			var buf bytes.Buffer
-			dec := gob.NewDecoder(&buf)
-
-			bbs, _ := comm.RecvBytes(0, rectanglesTag)
-			buf.Write(bbs)
-
-			var rects []streets.rect
-			err := dec.Decode(&rects)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to decode rects.")
-				return
-			}
-
-			log.Debug().Msgf("MPI: Number of tasks: %d My rank: %d", numTasks, taskID)
-			log.Debug().Msgf("MPI: Number of rects: %d", len(rects))
-
-			buf.Reset()
-			bbs, _ = comm.RecvBytes(0, edgesTag)
-			buf.Write(bbs)
-
-			var rawEdges []streets.RawEdge[int]
-			err = dec.Decode(&rawEdges)
-
-			myRect := rects[myId]
-
-			// init subgraph
-			g := streets.GraphFromRect(rawEdges, myRect)
-			size, err := g.Size()
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get graph size.")
-				return
-			}
-			log.Info().Msgf("Process %d: Graph size: %d", myId, size)
-
-			// receive paths from task 0
-			buf.Reset()
-
-			bbs, _ = comm.RecvBytes(0, vehiclesTag)
-
-			var vehicles []streets.Vehicle
-			// var vehiclesLst []streets.Vehicle
-			err = json.Unmarshal(bbs, &vehicles)
-
-			vehicles = vehicles[((*n)/numTasks-1)*(myId-1) : int(((*n)/numTasks-1)*myId)]
-
-			log.Info().Msgf("Process %d: Number of vehicles: %d", myId, len(vehicles))
-			pushVehicle(vehicles[0])
+		result, err := sim.Run(c, cfg)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to run simulation.")
+			return
 		}
-
+		log.Info().Msgf("Process %d: Vehicles parked: %d", c.Rank(), result.Parked)
 	} else {
-		g := streets.NewGraph(nil, nil)
-		ed, err := g.Edges()
+		root, _ := streets.DefaultGraph(*graphFile, 1)
+		ed, err := root.Graph.Edges()
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to get edges.")
 			return
@@ -364,12 +249,14 @@ func main() {
 
 		// save graph async
 		if *exportGraph {
-			err := saveGraph(&g)
+			err := saveGraph(&root.Graph)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to save graph.")
 			}
 		}
 
-		run(&g, n, minSpeed, maxSpeed, useRoutines)
+		if err := run(root, runConfig{Config: cfg, Parallel: *useRoutines}); err != nil {
+			os.Exit(1)
+		}
 	}
 }
@@ -35,13 +35,13 @@ func TestFileGraphSetup(t *testing.T) {
 	setupLogger(t)
 	path := "../assets/out.json"
 
-	g := *streets.NewGraphBuilder().FromJsonFile(path).Build()
+	g, _ := streets.DefaultGraph(path, 1)
 
 	if g == nil {
 		t.Errorf("Graph is nil")
 	}
 
-	size, err := g.Size()
+	size, err := g.Graph.Size()
 	if err != nil {
 		t.Errorf("Error getting graph size: %s", err)
 	}
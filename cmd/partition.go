@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reportPartitions runs the partition subcommand, printing the sizes of the
+// rects the graph is divided into by the partitioner
+func reportPartitions(graphFile, partitionerName string, args []string) error {
+	flags := flag.NewFlagSet("partition", flag.ContinueOnError)
+	parts := flags.Int("parts", 4, "Number of partitions, i.e. MPI ranks without rank 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	partitioner, err := streets.NewPartitioner(partitionerName)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create partitioner.")
+		return err
+	}
+
+	g, _ := streets.DefaultGraph(graphFile, 1)
+	rects, err := g.Partition(partitioner, *parts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to divide graph.")
+		return err
+	}
+
+	report, err := g.PartitionReport(rects)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to measure partitions.")
+		return err
+	}
+
+	fmt.Printf("%s partitions of %s:\n%s\n", partitionerName, graphFile, report)
+	return nil
+}
@@ -0,0 +1,55 @@
+// Package comm abstracts the message passing between the ranks of a simulation,
+// so the distributed code path runs with MPI as well as in a single process.
+package comm
+
+import "fmt"
+
+// Op is a reduction operation
+type Op uint8
+
+const (
+	OpSum Op = iota
+	OpMin
+	OpMax
+)
+
+// Communicator exchanges messages between the ranks of a simulation
+type Communicator interface {
+	// Rank returns the rank of the calling process
+	Rank() int
+	// Size returns the number of ranks
+	Size() int
+	// SendBytes sends a non-empty message to the given rank with the given tag
+	SendBytes(b []byte, to, tag int)
+	// RecvBytes blocks until a message with the given tag from the given rank arrives
+	RecvBytes(from, tag int) []byte
+	// Barrier blocks until all ranks have called it
+	Barrier()
+	// AllreduceFloat64s reduces orig element-wise over all ranks into dest
+	AllreduceFloat64s(dest, orig []float64, op Op) error
+	// AllreduceInt64s reduces orig element-wise over all ranks into dest
+	AllreduceInt64s(dest, orig []int64, op Op) error
+}
+
+// reduce applies op element-wise on the contributions of all ranks
+func reduce[T int64 | float64](dest []T, contribs [][]T, op Op) error {
+	for i := range dest {
+		for r, c := range contribs {
+			if len(c) != len(dest) {
+				return fmt.Errorf("rank %d contributed %d values, expected %d", r, len(c), len(dest))
+			}
+
+			switch {
+			case r == 0:
+				dest[i] = c[i]
+			case op == OpSum:
+				dest[i] += c[i]
+			case op == OpMin && c[i] < dest[i]:
+				dest[i] = c[i]
+			case op == OpMax && c[i] > dest[i]:
+				dest[i] = c[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package comm
+
+import (
+	"sync"
+)
+
+// mailKey identifies the messages sent from a rank with a tag
+type mailKey struct {
+	from, tag int
+}
+
+// world is the shared state of all virtual ranks of a process
+type world struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	size      int
+	mailboxes []map[mailKey][][]byte
+
+	// collective operations
+	generation int
+	arrived    int
+	contribs   []any
+	results    []any
+}
+
+// Local is a Communicator for a virtual rank, backed by goroutines and shared
+// memory instead of MPI
+type Local struct {
+	rank  int
+	world *world
+}
+
+// NewLocal creates size virtual ranks sharing one process
+func NewLocal(size int) []Communicator {
+	w := &world{
+		size:      size,
+		mailboxes: make([]map[mailKey][][]byte, size),
+		contribs:  make([]any, size),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	comms := make([]Communicator, size)
+	for i := range comms {
+		w.mailboxes[i] = make(map[mailKey][][]byte)
+		comms[i] = &Local{rank: i, world: w}
+	}
+	return comms
+}
+
+// RunLocal runs fn on size virtual ranks, each in its own goroutine, and
+// returns the first error any rank returned
+func RunLocal(size int, fn func(c Communicator) error) error {
+	comms := NewLocal(size)
+	errs := make([]error, size)
+
+	var wg sync.WaitGroup
+	for i, c := range comms {
+		wg.Add(1)
+		go func(i int, c Communicator) {
+			defer wg.Done()
+			errs[i] = fn(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rank returns the rank of the virtual process
+func (l *Local) Rank() int {
+	return l.rank
+}
+
+// Size returns the number of virtual ranks
+func (l *Local) Size() int {
+	return l.world.size
+}
+
+// SendBytes queues a copy of b in the mailbox of rank to. It never blocks,
+// like a buffered MPI send.
+func (l *Local) SendBytes(b []byte, to, tag int) {
+	msg := make([]byte, len(b))
+	copy(msg, b)
+
+	w := l.world
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := mailKey{from: l.rank, tag: tag}
+	w.mailboxes[to][key] = append(w.mailboxes[to][key], msg)
+	w.cond.Broadcast()
+}
+
+// RecvBytes takes the oldest message with the given tag from rank from,
+// waiting for it if necessary
+func (l *Local) RecvBytes(from, tag int) []byte {
+	w := l.world
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := mailKey{from: from, tag: tag}
+	mailbox := w.mailboxes[l.rank]
+	for len(mailbox[key]) == 0 {
+		w.cond.Wait()
+	}
+
+	msg := mailbox[key][0]
+	mailbox[key] = mailbox[key][1:]
+	return msg
+}
+
+// collect blocks until every rank has contributed x and returns the
+// contributions of all ranks, ordered by rank
+func (l *Local) collect(x any) []any {
+	w := l.world
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	generation := w.generation
+	w.contribs[l.rank] = x
+	w.arrived++
+
+	if w.arrived == w.size {
+		w.results = w.contribs
+		w.contribs = make([]any, w.size)
+		w.arrived = 0
+		w.generation++
+		w.cond.Broadcast()
+	}
+	for generation == w.generation {
+		w.cond.Wait()
+	}
+
+	return w.results
+}
+
+// Barrier blocks until all virtual ranks have called it
+func (l *Local) Barrier() {
+	l.collect(nil)
+}
+
+// AllreduceFloat64s reduces orig element-wise over all virtual ranks into dest
+func (l *Local) AllreduceFloat64s(dest, orig []float64, op Op) error {
+	return allreduce(l, dest, orig, op)
+}
+
+// AllreduceInt64s reduces orig element-wise over all virtual ranks into dest
+func (l *Local) AllreduceInt64s(dest, orig []int64, op Op) error {
+	return allreduce(l, dest, orig, op)
+}
+
+// allreduce collects a copy of orig from every rank and reduces it into dest
+func allreduce[T int64 | float64](l *Local, dest, orig []T, op Op) error {
+	own := make([]T, len(orig))
+	copy(own, orig)
+
+	results := l.collect(own)
+	contribs := make([][]T, len(results))
+	for i, r := range results {
+		contribs[i] = r.([]T)
+	}
+
+	return reduce(dest, contribs, op)
+}
@@ -0,0 +1,60 @@
+package comm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+func TestLocal_SendRecv(t *testing.T) {
+	err := RunLocal(3, func(c Communicator) error {
+		// every rank sends two ordered messages to its right neighbour
+		to := (c.Rank() + 1) % c.Size()
+		from := (c.Rank() + c.Size() - 1) % c.Size()
+
+		c.SendBytes([]byte(fmt.Sprintf("first %d", c.Rank())), to, 1)
+		c.SendBytes([]byte(fmt.Sprintf("second %d", c.Rank())), to, 1)
+		c.SendBytes([]byte("other tag"), to, 2)
+
+		assert.Equal(t, "other tag", string(c.RecvBytes(from, 2)))
+		assert.Equal(t, fmt.Sprintf("first %d", from), string(c.RecvBytes(from, 1)))
+		assert.Equal(t, fmt.Sprintf("second %d", from), string(c.RecvBytes(from, 1)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocal_Allreduce(t *testing.T) {
+	err := RunLocal(4, func(c Communicator) error {
+		for round := 0; round < 3; round++ {
+			r := int64(c.Rank() + round)
+
+			sum := make([]int64, 2)
+			if err := c.AllreduceInt64s(sum, []int64{r, 1}, OpSum); err != nil {
+				return err
+			}
+			assert.Equal(t, int64(6+4*round), sum[0])
+			assert.Equal(t, int64(4), sum[1])
+
+			minMax := make([]float64, 1)
+			if err := c.AllreduceFloat64s(minMax, []float64{float64(r)}, OpMax); err != nil {
+				return err
+			}
+			assert.Equal(t, float64(3+round), minMax[0])
+
+			if err := c.AllreduceFloat64s(minMax, []float64{float64(r)}, OpMin); err != nil {
+				return err
+			}
+			assert.Equal(t, float64(round), minMax[0])
+
+			c.Barrier()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
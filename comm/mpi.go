@@ -0,0 +1,71 @@
+//go:build mpi
+
+package comm
+
+import (
+	"errors"
+
+	mpi "github.com/sbromberger/gompi"
+)
+
+// MPI is a Communicator backed by the MPI world communicator
+type MPI struct {
+	comm *mpi.Communicator
+}
+
+// ops maps the reduction operations to their MPI counterparts
+var ops = map[Op]mpi.Op{
+	OpSum: mpi.OpSum,
+	OpMin: mpi.OpMin,
+	OpMax: mpi.OpMax,
+}
+
+// StartMPI initialises MPI and returns the world communicator
+func StartMPI() (Communicator, error) {
+	mpi.Start(true)
+	if !mpi.IsOn() {
+		return nil, errors.New("MPI is not on")
+	}
+	return &MPI{comm: mpi.NewCommunicator(nil)}, nil
+}
+
+// StopMPI finalises MPI
+func StopMPI() {
+	mpi.Stop()
+}
+
+// Rank returns the rank of the process in the world communicator
+func (m *MPI) Rank() int {
+	return m.comm.Rank()
+}
+
+// Size returns the number of processes in the world communicator
+func (m *MPI) Size() int {
+	return m.comm.Size()
+}
+
+// SendBytes sends b to rank to with the given tag
+func (m *MPI) SendBytes(b []byte, to, tag int) {
+	m.comm.SendBytes(b, to, tag)
+}
+
+// RecvBytes receives a message with the given tag from rank from
+func (m *MPI) RecvBytes(from, tag int) []byte {
+	b, _ := m.comm.RecvBytes(from, tag)
+	return b
+}
+
+// Barrier blocks until all processes have called it
+func (m *MPI) Barrier() {
+	m.comm.Barrier()
+}
+
+// AllreduceFloat64s reduces orig element-wise over all processes into dest
+func (m *MPI) AllreduceFloat64s(dest, orig []float64, op Op) error {
+	return m.comm.AllreduceFloat64s(dest, orig, ops[op], 0)
+}
+
+// AllreduceInt64s reduces orig element-wise over all processes into dest
+func (m *MPI) AllreduceInt64s(dest, orig []int64, op Op) error {
+	return m.comm.AllreduceInt64s(dest, orig, ops[op], 0)
+}
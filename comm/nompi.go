@@ -0,0 +1,13 @@
+//go:build !mpi
+
+package comm
+
+import "errors"
+
+// StartMPI fails, the binary has been built without MPI support
+func StartMPI() (Communicator, error) {
+	return nil, errors.New("built without MPI support, rebuild with -tags mpi")
+}
+
+// StopMPI does nothing without MPI support
+func StopMPI() {}
@@ -0,0 +1,57 @@
+// Package demand draws the trips vehicles drive, either between random
+// vertices or from a time-dependent origin-destination matrix.
+package demand
+
+import (
+	"pchpc/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxDestinationTries bounds the destinations drawn for a trip before it is dropped
+const maxDestinationTries = 100
+
+// Trip is a vehicle of the demand before its route is planned
+type Trip struct {
+	// Index numbers the trips of a demand, the vehicle of a trip is named by it
+	Index       int
+	Origin      int
+	Destination int
+	// Departure is the time in seconds into the simulation the trip departs at
+	Departure float64
+}
+
+// Stream returns the random stream the trip at index is drawn from
+type Stream func(index int) utils.RNG
+
+// Random draws n trips between random vertices, which have to reach each
+// other, departing by the profile
+func Random(n int, vertices []int, profile Profile, stream Stream) []Trip {
+	trips := make([]Trip, 0, n)
+	if len(vertices) < 2 {
+		return trips
+	}
+	previous := 0.0
+	for i := 0; i < n; i++ {
+		rng := stream(i)
+		origin := vertices[rng.Intn(len(vertices))]
+		destination, ok := drawDestination(origin, vertices, &rng)
+		if !ok {
+			log.Warn().Msgf("No destination for trip %d from %d, dropping it.", i, origin)
+			continue
+		}
+		previous = profile.Departure(previous, &rng)
+		trips = append(trips, Trip{Index: i, Origin: origin, Destination: destination, Departure: previous})
+	}
+	return trips
+}
+
+// drawDestination draws a destination other than the origin
+func drawDestination(origin int, vertices []int, rng *utils.RNG) (int, bool) {
+	for try := 0; try < maxDestinationTries; try++ {
+		if destination := vertices[rng.Intn(len(vertices))]; destination != origin {
+			return destination, true
+		}
+	}
+	return 0, false
+}
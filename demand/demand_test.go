@@ -0,0 +1,20 @@
+package demand
+
+import (
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+func TestRandom(t *testing.T) {
+	vertices := []int{1, 2, 3, 4}
+	trips := Random(20, vertices, AtOnce{}, stream)
+	assert.Equal(t, 20, len(trips))
+	for _, trip := range trips {
+		assert.True(t, trip.Origin != trip.Destination)
+		assert.Equal(t, 0.0, trip.Departure)
+	}
+
+	// a single vertex has no destination
+	assert.Equal(t, 0, len(Random(5, []int{1}, AtOnce{}, stream)))
+}
@@ -0,0 +1,197 @@
+package demand
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Zone is an area trips start or end in, given by the IDs of its vertices or
+// by a polygon around them
+type Zone struct {
+	ID       string `json:"id"`
+	Vertices []int  `json:"vertices,omitempty"`
+	// Polygon are the corners of the zone in the coordinates of the graph
+	Polygon [][2]float64 `json:"polygon,omitempty"`
+}
+
+// Flow is the number of trips from one zone to another
+type Flow struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Trips int    `json:"trips"`
+}
+
+// Slice is a time slice of a matrix. Its trips depart uniformly distributed
+// between its start and end.
+type Slice struct {
+	// Start is the time in seconds into the simulation the slice starts at
+	Start float64 `json:"start"`
+	// End is the time in seconds into the simulation the slice ends at
+	End   float64 `json:"end"`
+	Flows []Flow  `json:"flows"`
+}
+
+// Matrix is a time-dependent origin-destination matrix, the number of trips
+// between zones per time slice
+type Matrix struct {
+	Zones  []Zone  `json:"zones"`
+	Slices []Slice `json:"slices"`
+}
+
+// Read reads a matrix in JSON and validates it
+func Read(r io.Reader) (*Matrix, error) {
+	var m Matrix
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		log.Error().Err(err).Msg("Failed to decode OD matrix.")
+		return nil, err
+	}
+	if err := m.validate(); err != nil {
+		log.Error().Err(err).Msg("Invalid OD matrix.")
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ReadFile reads a matrix from a JSON file
+func ReadFile(path string) (*Matrix, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open OD matrix.")
+		return nil, err
+	}
+	defer file.Close()
+
+	return Read(file)
+}
+
+// validate checks that every zone is defined once and every flow runs
+// between known zones in a slice not ending before it starts
+func (m *Matrix) validate() error {
+	zones := make(map[string]bool, len(m.Zones))
+	for _, z := range m.Zones {
+		if zones[z.ID] {
+			return fmt.Errorf("zone %q is defined twice", z.ID)
+		}
+		if len(z.Vertices) == 0 && len(z.Polygon) < 3 {
+			return fmt.Errorf("zone %q has neither vertices nor a polygon", z.ID)
+		}
+		zones[z.ID] = true
+	}
+
+	for i, s := range m.Slices {
+		if s.Start < 0 || s.End < s.Start {
+			return fmt.Errorf("slice %d from %g to %g is invalid", i, s.Start, s.End)
+		}
+		for _, f := range s.Flows {
+			if !zones[f.From] || !zones[f.To] {
+				return fmt.Errorf("flow of slice %d from %q to %q has an unknown zone", i, f.From, f.To)
+			}
+			if f.Trips < 0 {
+				return fmt.Errorf("flow of slice %d from %q to %q has %d trips", i, f.From, f.To, f.Trips)
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of trips of the matrix
+func (m *Matrix) Len() int {
+	n := 0
+	for _, s := range m.Slices {
+		for _, f := range s.Flows {
+			n += f.Trips
+		}
+	}
+	return n
+}
+
+// Trips draws the trips of the matrix between the vertices, which have to
+// reach each other, slice by slice and flow by flow. A trip starts and ends
+// at random vertices of its zones and departs at a random time of its slice.
+// Trips without a destination other than their origin are dropped.
+func (m *Matrix) Trips(vertices []streets.JVertex, stream Stream) ([]Trip, error) {
+	members := make(map[string][]int, len(m.Zones))
+	for _, z := range m.Zones {
+		ids := z.members(vertices)
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("zone %q holds no vertex of the graph", z.ID)
+		}
+		members[z.ID] = ids
+	}
+
+	trips := make([]Trip, 0, m.Len())
+	index := 0
+	for _, s := range m.Slices {
+		for _, f := range s.Flows {
+			for k := 0; k < f.Trips; k, index = k+1, index+1 {
+				rng := stream(index)
+				departure := rng.Between(s.Start, s.End)
+				from := members[f.From]
+				origin := from[rng.Intn(len(from))]
+				destination, ok := drawDestination(origin, members[f.To], &rng)
+				if !ok {
+					log.Warn().Msgf("No destination in zone %q for trip %d from %d, dropping it.", f.To, index, origin)
+					continue
+				}
+				trips = append(trips, Trip{Index: index, Origin: origin, Destination: destination, Departure: departure})
+			}
+		}
+	}
+	return trips, nil
+}
+
+// members returns the IDs of the vertices in the zone, sorted
+func (z *Zone) members(vertices []streets.JVertex) []int {
+	ids := make([]int, 0)
+	if len(z.Vertices) > 0 {
+		listed := make(map[int]bool, len(z.Vertices))
+		for _, id := range z.Vertices {
+			listed[id] = true
+		}
+		for _, v := range vertices {
+			if listed[v.ID] {
+				ids = append(ids, v.ID)
+			}
+		}
+	} else {
+		for _, v := range vertices {
+			if contains(z.Polygon, v.X, v.Y) {
+				ids = append(ids, v.ID)
+			}
+		}
+	}
+	return uniqueSorted(ids)
+}
+
+// contains checks if the point lies inside the polygon, by the even-odd rule
+func contains(polygon [][2]float64, x, y float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// uniqueSorted returns the IDs sorted without duplicates
+func uniqueSorted(ids []int) []int {
+	sorted := make([]int, len(ids))
+	copy(sorted, ids)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,101 @@
+package demand
+
+import (
+	"strings"
+	"testing"
+
+	"pchpc/streets"
+	"pchpc/utils"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// grid are the vertices of a 3x3 grid with unit spacing, numbered row by row
+func grid() []streets.JVertex {
+	vertices := make([]streets.JVertex, 0, 9)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			vertices = append(vertices, streets.JVertex{ID: 3*y + x + 1, X: float64(x), Y: float64(y)})
+		}
+	}
+	return vertices
+}
+
+func stream(index int) utils.RNG {
+	root := utils.NewRNG(1)
+	return root.Stream(uint64(index))
+}
+
+const matrix = `{
+  "zones": [
+    {"id": "west", "vertices": [1, 4, 7, 99]},
+    {"id": "east", "polygon": [[1.5, -0.5], [2.5, -0.5], [2.5, 2.5], [1.5, 2.5]]}
+  ],
+  "slices": [
+    {"start": 0, "end": 600, "flows": [{"from": "west", "to": "east", "trips": 5}]},
+    {"start": 600, "end": 900, "flows": [
+      {"from": "east", "to": "west", "trips": 3},
+      {"from": "west", "to": "west", "trips": 2}
+    ]}
+  ]
+}`
+
+func TestMatrix_Trips(t *testing.T) {
+	m, err := Read(strings.NewReader(matrix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, m.Len())
+
+	trips, err := m.Trips(grid(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 10, len(trips))
+
+	west := map[int]bool{1: true, 4: true, 7: true}
+	east := map[int]bool{3: true, 6: true, 9: true}
+	for i, trip := range trips {
+		assert.Equal(t, i, trip.Index)
+		assert.True(t, trip.Origin != trip.Destination)
+		switch {
+		case i < 5:
+			assert.True(t, west[trip.Origin] && east[trip.Destination])
+			assert.True(t, trip.Departure >= 0 && trip.Departure < 600)
+		case i < 8:
+			assert.True(t, east[trip.Origin] && west[trip.Destination])
+			assert.True(t, trip.Departure >= 600 && trip.Departure < 900)
+		default:
+			assert.True(t, west[trip.Origin] && west[trip.Destination])
+		}
+	}
+
+	// the same streams draw the same trips
+	again, err := m.Trips(grid(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, trips, again)
+}
+
+func TestMatrix_Invalid(t *testing.T) {
+	for _, input := range []string{
+		`{"zones": [{"id": "a", "vertices": [1]}, {"id": "a", "vertices": [2]}]}`,
+		`{"zones": [{"id": "a"}]}`,
+		`{"zones": [{"id": "a", "vertices": [1]}], "slices": [{"start": 10, "end": 5}]}`,
+		`{"zones": [{"id": "a", "vertices": [1]}], "slices": [{"start": 0, "end": 5, "flows": [{"from": "a", "to": "b", "trips": 1}]}]}`,
+		`{"zones": [{"id": "a", "vertices": [1]}], "slices": [{"start": 0, "end": 5, "flows": [{"from": "a", "to": "a", "trips": -1}]}]}`,
+		`{"zones": `,
+	} {
+		_, err := Read(strings.NewReader(input))
+		assert.True(t, err != nil)
+	}
+
+	// a zone outside the graph
+	m, err := Read(strings.NewReader(`{"zones": [{"id": "a", "vertices": [42]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Trips(grid(), stream)
+	assert.True(t, err != nil)
+}
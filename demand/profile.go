@@ -0,0 +1,87 @@
+package demand
+
+import (
+	"fmt"
+	"math"
+
+	"pchpc/utils"
+)
+
+// Profile spreads the departures of the trips of a demand over time
+type Profile interface {
+	// Departure returns the departure time in seconds of the next trip,
+	// drawn from its stream, after the previous trip departed at previous
+	Departure(previous float64, rng *utils.RNG) float64
+}
+
+// NewProfile returns the departure profile with the given name for n trips
+// departing within window seconds: "" or "none" for all at once, "uniform",
+// "poisson" or "peak"
+func NewProfile(name string, n int, window float64) (Profile, error) {
+	if name != "" && name != "none" && window <= 0 {
+		return nil, fmt.Errorf("invalid departure window %g", window)
+	}
+	switch name {
+	case "", "none":
+		return AtOnce{}, nil
+	case "uniform":
+		return Uniform{Window: window}, nil
+	case "poisson":
+		if n < 1 {
+			return nil, fmt.Errorf("invalid number of trips %d", n)
+		}
+		return Poisson{Rate: float64(n) / window}, nil
+	case "peak":
+		return Peak{Window: window}, nil
+	default:
+		return nil, fmt.Errorf("unknown departure profile %q", name)
+	}
+}
+
+// AtOnce lets every trip depart at the start of the simulation
+type AtOnce struct{}
+
+// Departure returns 0
+func (AtOnce) Departure(float64, *utils.RNG) float64 {
+	return 0
+}
+
+// Uniform lets the trips depart uniformly distributed within a window
+type Uniform struct {
+	// Window is the duration in seconds trips depart in
+	Window float64
+}
+
+// Departure returns a random time of the window
+func (p Uniform) Departure(_ float64, rng *utils.RNG) float64 {
+	return rng.Between(0, p.Window)
+}
+
+// Poisson lets the trips depart as a Poisson process, the time between two
+// departures is exponentially distributed
+type Poisson struct {
+	// Rate is the mean number of departures per second
+	Rate float64
+}
+
+// Departure returns the previous departure plus an exponential gap
+func (p Poisson) Departure(previous float64, rng *utils.RNG) float64 {
+	return previous - math.Log(1-rng.Float64())/p.Rate
+}
+
+// Peak lets the trips depart in a morning peak: few at the start and the end
+// of the window, most in its middle, following a raised cosine
+type Peak struct {
+	// Window is the duration in seconds trips depart in
+	Window float64
+}
+
+// Departure draws a time of the window by rejection sampling
+func (p Peak) Departure(_ float64, rng *utils.RNG) float64 {
+	for {
+		t := rng.Float64()
+		if rng.Float64() < (1-math.Cos(2*math.Pi*t))/2 {
+			return t * p.Window
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package demand
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// departures draws the departures of n trips by the profile
+func departures(t *testing.T, name string, n int, window float64) []float64 {
+	t.Helper()
+
+	profile, err := NewProfile(name, n, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := make([]float64, n)
+	previous := 0.0
+	for i := range times {
+		rng := stream(i)
+		previous = profile.Departure(previous, &rng)
+		times[i] = previous
+	}
+	return times
+}
+
+func TestNewProfile(t *testing.T) {
+	for _, name := range []string{"", "none", "uniform", "poisson", "peak"} {
+		_, err := NewProfile(name, 10, 60)
+		assert.True(t, err == nil)
+	}
+	for _, c := range []struct {
+		name   string
+		n      int
+		window float64
+	}{
+		{"uniform", 10, 0},
+		{"poisson", 0, 60},
+		{"evening", 10, 60},
+	} {
+		_, err := NewProfile(c.name, c.n, c.window)
+		assert.True(t, err != nil)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	const n, window = 2000, 1000.0
+
+	for _, d := range departures(t, "none", n, window) {
+		assert.Equal(t, 0.0, d)
+	}
+
+	for _, d := range departures(t, "uniform", n, window) {
+		assert.True(t, d >= 0 && d < window)
+	}
+
+	// the departures of a Poisson process are ordered, on average n of them in the window
+	poisson := departures(t, "poisson", n, window)
+	for i := 1; i < n; i++ {
+		assert.True(t, poisson[i] >= poisson[i-1])
+	}
+	assert.True(t, math.Abs(poisson[n-1]-window) < 0.1*window)
+
+	// most trips of the peak depart in the middle half of the window
+	middle := 0
+	for _, d := range departures(t, "peak", n, window) {
+		assert.True(t, d >= 0 && d < window)
+		if d >= window/4 && d < 3*window/4 {
+			middle++
+		}
+	}
+	assert.True(t, float64(middle) > 0.75*n)
+}
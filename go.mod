@@ -3,9 +3,9 @@ module pchpc
 go 1.19
 
 require (
-	github.com/aidarkhanov/nanoid v1.0.8
 	github.com/cornelk/hashmap v1.0.8
 	github.com/dominikbraun/graph v0.22.3
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/rs/zerolog v1.29.1
 	github.com/sbromberger/gompi v0.2.0
 	github.com/vbauerster/mpb/v8 v8.4.0
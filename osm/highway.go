@@ -0,0 +1,170 @@
+package osm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mphToKmh converts miles per hour to kilometres per hour
+const mphToKmh = 1.609344
+
+// drivable are the highway classes open to cars
+var drivable = map[string]bool{
+	"motorway":       true,
+	"motorway_link":  true,
+	"trunk":          true,
+	"trunk_link":     true,
+	"primary":        true,
+	"primary_link":   true,
+	"secondary":      true,
+	"secondary_link": true,
+	"tertiary":       true,
+	"tertiary_link":  true,
+	"unclassified":   true,
+	"residential":    true,
+	"living_street":  true,
+	"service":        true,
+	"road":           true,
+}
+
+// implicitSpeeds are the speeds in km/h of the implicit maxspeed values,
+// e.g. DE:urban
+var implicitSpeeds = map[string]float64{
+	"urban":         50,
+	"rural":         100,
+	"motorway":      130,
+	"trunk":         100,
+	"living_street": 7,
+	"walk":          7,
+	"none":          130,
+}
+
+// isDrivable checks if cars may drive on the way
+func isDrivable(w *way) bool {
+	if !drivable[w.tag("highway")] || len(w.Nodes) < 2 {
+		return false
+	}
+	if w.tag("area") == "yes" {
+		return false
+	}
+	switch w.tag("access") {
+	case "no", "private":
+		return w.tag("motor_vehicle") == "yes" || w.tag("motorcar") == "yes"
+	}
+	return true
+}
+
+// direction returns whether the way may be driven in the direction of its
+// nodes and against it
+func direction(w *way) (forward, backward bool) {
+	switch w.tag("oneway") {
+	case "yes", "true", "1":
+		return true, false
+	case "-1", "reverse":
+		return false, true
+	case "no", "false", "0":
+		return true, true
+	}
+
+	if w.tag("junction") == "roundabout" || w.tag("highway") == "motorway" {
+		return true, false
+	}
+	return true, true
+}
+
+// parseMaxSpeed converts a maxspeed tag to km/h, e.g. "50", "30 mph" or
+// "DE:zone30". Unknown values return "", leaving the default to the GraphBuilder.
+func parseMaxSpeed(value string) string {
+	// of several values like "30;50" the first one applies
+	value, _, _ = strings.Cut(value, ";")
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+
+	if speed, ok := implicitSpeed(value); ok {
+		return strconv.FormatFloat(speed, 'f', -1, 64)
+	}
+
+	factor := 1.0
+	switch {
+	case strings.HasSuffix(value, "mph"):
+		factor = mphToKmh
+		value = strings.TrimSuffix(value, "mph")
+	case strings.HasSuffix(value, "km/h"):
+		value = strings.TrimSuffix(value, "km/h")
+	case strings.HasSuffix(value, "kmh"):
+		value = strings.TrimSuffix(value, "kmh")
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || speed <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(int(speed*factor+0.5)), 'f', -1, 64)
+}
+
+// implicitSpeed returns the speed of implicit values like "DE:urban",
+// "DE:zone30", "DE:zone:30" or "none"
+func implicitSpeed(value string) (float64, bool) {
+	if _, class, found := strings.Cut(value, ":"); found {
+		value = class
+	}
+	if speed, ok := implicitSpeeds[value]; ok {
+		return speed, true
+	}
+
+	zone := strings.TrimPrefix(strings.TrimPrefix(value, "zone"), ":")
+	if zone == value {
+		return 0, false
+	}
+	speed, err := strconv.ParseFloat(zone, 64)
+	return speed, err == nil
+}
+
+// multiLane are the highway classes with two lanes per direction if the way
+// does not tag its lanes
+var multiLane = map[string]bool{
+	"motorway": true,
+	"trunk":    true,
+}
+
+// lanes returns the number of lanes of the way in the direction of its nodes
+// and against it, from the lanes, lanes:forward and lanes:backward tags. The
+// lanes of a two-way street are split evenly. Without tags it returns 0,
+// leaving the default to the GraphBuilder, except for multi-lane classes.
+func lanes(w *way) (forward, backward int) {
+	fwd, bwd := direction(w)
+
+	total := parseLanes(w.tag("lanes"))
+	switch {
+	case total > 1 && fwd && bwd:
+		forward, backward = total/2, total/2
+	case total > 0 && fwd && bwd:
+		forward, backward = 1, 1
+	case total > 0 && fwd:
+		forward = total
+	case total > 0:
+		backward = total
+	case multiLane[w.tag("highway")]:
+		forward, backward = 2, 2
+	}
+
+	if n := parseLanes(w.tag("lanes:forward")); n > 0 {
+		forward = n
+	}
+	if n := parseLanes(w.tag("lanes:backward")); n > 0 {
+		backward = n
+	}
+	return forward, backward
+}
+
+// parseLanes converts a lanes tag to a number, 0 for invalid values
+func parseLanes(value string) int {
+	value, _, _ = strings.Cut(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
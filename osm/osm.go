@@ -0,0 +1,219 @@
+// Package osm imports OpenStreetMap XML extracts into the GraphJSON format.
+// Drivable highway ways are split at intersections into directed edges,
+// honouring oneway streets, their maxspeed and their lanes.
+package osm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// earthRadius is the mean earth radius in metres
+const earthRadius = 6371008.8
+
+type tag struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type node struct {
+	ID  int     `xml:"id,attr"`
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type nodeRef struct {
+	Ref int `xml:"ref,attr"`
+}
+
+type way struct {
+	ID    int       `xml:"id,attr"`
+	Nodes []nodeRef `xml:"nd"`
+	Tags  []tag     `xml:"tag"`
+
+	tags map[string]string
+}
+
+// tag returns the value of the tag with the given key
+func (w *way) tag(key string) string {
+	if w.tags == nil {
+		w.tags = make(map[string]string, len(w.Tags))
+		for _, t := range w.Tags {
+			w.tags[t.Key] = t.Value
+		}
+	}
+	return w.tags[key]
+}
+
+// edgeKey identifies a directed edge
+type edgeKey struct {
+	from, to int
+}
+
+// read decodes the nodes and the drivable ways of an OSM XML document
+func read(r io.Reader) (map[int]node, []*way, error) {
+	nodes := make(map[int]node)
+	ways := make([]*way, 0)
+
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "node":
+			var n node
+			if err := dec.DecodeElement(&n, &start); err != nil {
+				return nil, nil, err
+			}
+			nodes[n.ID] = n
+		case "way":
+			var w way
+			if err := dec.DecodeElement(&w, &start); err != nil {
+				return nil, nil, err
+			}
+			if isDrivable(&w) {
+				ways = append(ways, &w)
+			}
+		}
+	}
+
+	return nodes, ways, nil
+}
+
+// distance returns the great-circle distance between two nodes in metres
+func distance(a, b node) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Import reads an OSM XML document and returns its drivable street graph
+func Import(r io.Reader, filename string) (*streets.GraphJSON, error) {
+	nodes, ways, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// a node splits a way if it is shared with another way, visited twice or an end of a way
+	uses := make(map[int]int)
+	for _, w := range ways {
+		for i, ref := range w.Nodes {
+			uses[ref.Ref]++
+			if i == 0 || i == len(w.Nodes)-1 {
+				uses[ref.Ref]++
+			}
+		}
+	}
+
+	vertices := make(map[int]streets.JVertex)
+	edges := make(map[edgeKey]streets.JEdge)
+	order := make([]edgeKey, 0)
+
+	addEdge := func(e streets.JEdge) {
+		key := edgeKey{from: e.From, to: e.To}
+		existing, ok := edges[key]
+		if !ok {
+			order = append(order, key)
+		}
+		// parallel ways between the same intersections, keep the shorter one
+		if !ok || e.Length < existing.Length {
+			edges[key] = e
+		}
+	}
+
+	for _, w := range ways {
+		forward, backward := direction(w)
+		maxSpeed := parseMaxSpeed(w.tag("maxspeed"))
+		forwardLanes, backwardLanes := lanes(w)
+
+		start := -1
+		length := 0.0
+		for i, ref := range w.Nodes {
+			n, ok := nodes[ref.Ref]
+			if !ok {
+				return nil, fmt.Errorf("way %d references missing node %d", w.ID, ref.Ref)
+			}
+			if i > 0 {
+				length += distance(nodes[w.Nodes[i-1].Ref], n)
+			}
+			if i > 0 && uses[ref.Ref] < 2 {
+				continue
+			}
+
+			if start >= 0 && start != ref.Ref {
+				vertices[start] = streets.JVertex{X: nodes[start].Lon, Y: nodes[start].Lat, ID: start}
+				vertices[ref.Ref] = streets.JVertex{X: n.Lon, Y: n.Lat, ID: ref.Ref}
+
+				edge := streets.JEdge{
+					Length:   math.Round(length*1000) / 1000,
+					MaxSpeed: maxSpeed,
+					Name:     w.tag("name"),
+					ID:       fmt.Sprint(w.ID),
+				}
+				if forward {
+					edge.From, edge.To, edge.Lanes = start, ref.Ref, forwardLanes
+					addEdge(edge)
+				}
+				if backward {
+					edge.From, edge.To, edge.Lanes = ref.Ref, start, backwardLanes
+					addEdge(edge)
+				}
+			}
+
+			start = ref.Ref
+			length = 0
+		}
+	}
+
+	g := &streets.GraphJSON{
+		Filename: filename,
+		Graph: streets.JGraph{
+			Vertices: make([]streets.JVertex, 0, len(vertices)),
+			Edges:    make([]streets.JEdge, 0, len(order)),
+		},
+	}
+	for _, key := range order {
+		e := edges[key]
+		g.Graph.Edges = append(g.Graph.Edges, e)
+		g.Graph.Vertices = append(g.Graph.Vertices, vertices[e.From], vertices[e.To])
+	}
+	g.Size = int64(len(g.Graph.Vertices))
+
+	log.Debug().Msgf("OSM: %d drivable ways, %d vertices, %d edges", len(ways), len(vertices), len(order))
+
+	return g, nil
+}
+
+// ImportFile reads an OSM XML extract and returns its drivable street graph
+func ImportFile(path string) (*streets.GraphJSON, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Import(file, filepath.Base(path))
+}
@@ -0,0 +1,153 @@
+package osm
+
+import (
+	"strings"
+	"testing"
+
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// crossing is a main street 1-2-3 crossed at 2 by a oneway 4-2-5, a
+// footway 3-6 and a dead end 3-7 in mph
+const crossing = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="1" lat="51.5000" lon="9.9000"/>
+  <node id="2" lat="51.5000" lon="9.9010"/>
+  <node id="3" lat="51.5000" lon="9.9020"/>
+  <node id="4" lat="51.5010" lon="9.9010"/>
+  <node id="5" lat="51.4990" lon="9.9010"/>
+  <node id="6" lat="51.4990" lon="9.9020"/>
+  <node id="7" lat="51.5010" lon="9.9020"/>
+  <node id="8" lat="51.5005" lon="9.9020"/>
+  <way id="100">
+    <nd ref="1"/><nd ref="2"/><nd ref="3"/>
+    <tag k="highway" v="primary"/>
+    <tag k="name" v="Hauptstraße"/>
+    <tag k="maxspeed" v="50"/>
+  </way>
+  <way id="200">
+    <nd ref="4"/><nd ref="2"/><nd ref="5"/>
+    <tag k="highway" v="residential"/>
+    <tag k="oneway" v="yes"/>
+    <tag k="maxspeed" v="DE:zone30"/>
+  </way>
+  <way id="300">
+    <nd ref="3"/><nd ref="6"/>
+    <tag k="highway" v="footway"/>
+  </way>
+  <way id="400">
+    <nd ref="3"/><nd ref="8"/><nd ref="7"/>
+    <tag k="highway" v="service"/>
+    <tag k="maxspeed" v="10 mph"/>
+  </way>
+</osm>`
+
+func edgeMap(g *streets.GraphJSON) map[[2]int]streets.JEdge {
+	edges := make(map[[2]int]streets.JEdge)
+	for _, e := range g.Graph.Edges {
+		edges[[2]int{e.From, e.To}] = e
+	}
+	return edges
+}
+
+func TestImport(t *testing.T) {
+	g, err := Import(strings.NewReader(crossing), "crossing.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges := edgeMap(g)
+
+	// 1-2, 2-3 and 3-7 in both directions, 4-2 and 2-5 only one way
+	assert.Equal(t, 8, len(edges))
+
+	for _, pair := range [][2]int{{1, 2}, {2, 1}, {2, 3}, {3, 2}, {4, 2}, {2, 5}, {3, 7}, {7, 3}} {
+		_, ok := edges[pair]
+		assert.True(t, ok)
+	}
+	for _, pair := range [][2]int{{2, 4}, {5, 2}, {3, 6}, {6, 3}, {3, 8}} {
+		_, ok := edges[pair]
+		assert.False(t, ok)
+	}
+
+	street := edges[[2]int{1, 2}]
+	assert.Equal(t, "Hauptstraße", street.Name)
+	assert.Equal(t, "100", street.ID)
+	assert.Equal(t, "50", street.MaxSpeed)
+	// 0.001° of longitude at 51.5° north are about 69 m
+	assert.True(t, street.Length > 68 && street.Length < 70)
+
+	assert.Equal(t, "30", edges[[2]int{4, 2}].MaxSpeed)
+	assert.Equal(t, "16", edges[[2]int{3, 7}].MaxSpeed)
+
+	// the dead end keeps the length over its intermediate node
+	deadEnd := edges[[2]int{3, 7}]
+	assert.True(t, deadEnd.Length > 110 && deadEnd.Length < 112)
+}
+
+func TestImport_GraphBuilder(t *testing.T) {
+	g, err := Import(strings.NewReader(crossing), "crossing.osm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sg, err := streets.NewGraphBuilder().
+		FromGraphJSON(g).
+		WithRectangleParts(1).
+		SetTopRightBottomLeftVertices().
+		DivideGraphsIntoRects().
+		PickRect(0).
+		FilterForRect().
+		IsRoot().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := sg.Graph.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 8, size)
+}
+
+func TestParseMaxSpeed(t *testing.T) {
+	for value, expected := range map[string]string{
+		"50":         "50",
+		"30 mph":     "48",
+		"70 km/h":    "70",
+		"30;50":      "30",
+		"DE:urban":   "50",
+		"DE:zone:30": "30",
+		"DE:zone20":  "20",
+		"none":       "130",
+		"walk":       "7",
+		"signals":    "",
+		"":           "",
+	} {
+		assert.Equal(t, expected, parseMaxSpeed(value))
+	}
+}
+
+func TestLanes(t *testing.T) {
+	for _, c := range []struct {
+		tags              map[string]string
+		forward, backward int
+	}{
+		{map[string]string{"highway": "residential"}, 0, 0},
+		{map[string]string{"highway": "trunk"}, 2, 2},
+		{map[string]string{"highway": "motorway"}, 2, 2},
+		{map[string]string{"highway": "primary", "lanes": "4"}, 2, 2},
+		{map[string]string{"highway": "primary", "lanes": "1"}, 1, 1},
+		{map[string]string{"highway": "primary", "lanes": "3", "oneway": "yes"}, 3, 0},
+		{map[string]string{"highway": "primary", "lanes": "3", "oneway": "-1"}, 0, 3},
+		{map[string]string{"highway": "primary", "lanes": "3", "lanes:forward": "2", "lanes:backward": "1"}, 2, 1},
+		{map[string]string{"highway": "primary", "lanes": "many"}, 0, 0},
+	} {
+		w := &way{tags: c.tags}
+		forward, backward := lanes(w)
+		assert.Equal(t, c.forward, forward)
+		assert.Equal(t, c.backward, backward)
+	}
+}
@@ -0,0 +1,180 @@
+package routing
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+
+	"pchpc/streets"
+
+	"github.com/dominikbraun/graph"
+)
+
+// earthRadius is the mean earth radius in metres
+const earthRadius = 6371008.8
+
+// AStar finds the cheapest paths by a metric with the A* search. Its
+// heuristic is the great-circle distance between two vertices, at the top
+// speed of the graph for the travel time, so it never exceeds the cost of a
+// path between them. Ties are broken by vertex ID, so the same query returns
+// the same path on every rank.
+type AStar struct {
+	adjacency map[int][]arc
+	coords    map[int]streets.JVertex
+	// bound is the cost of a metre at the least, 1 for the distance and the
+	// inverse of the top speed for the travel time
+	bound float64
+	// scale is the cost per metre of great-circle distance of the heuristic,
+	// bound or less if an edge is cheaper
+	scale float64
+}
+
+// NewAStar reads the adjacency and coordinates of the graph once for all queries
+func NewAStar(g graph.Graph[int, streets.JVertex], metric Metric) (*AStar, error) {
+	adjacency, err := readArcs(g, metric)
+	if err != nil {
+		return nil, err
+	}
+
+	vertices, err := g.AdjacencyMap()
+	if err != nil {
+		return nil, err
+	}
+	coords := make(map[int]streets.JVertex, len(vertices))
+	for id := range vertices {
+		v, err := g.Vertex(id)
+		if err != nil {
+			return nil, err
+		}
+		coords[id] = v
+	}
+
+	bound := 1.0
+	if metric == TravelTime {
+		top, err := topSpeed(g)
+		if err != nil {
+			return nil, err
+		}
+		bound = 1 / top
+	}
+
+	s := &AStar{adjacency: adjacency, coords: coords, bound: bound}
+	s.scaleHeuristic()
+	return s, nil
+}
+
+// topSpeed returns the highest speed limit of the edges of g in m/s
+func topSpeed(g graph.Graph[int, streets.JVertex]) (float64, error) {
+	edges, err := g.Edges()
+	if err != nil {
+		return 0, err
+	}
+	top := 0.0
+	for _, e := range edges {
+		data, err := streets.GetEdgeData(e)
+		if err != nil {
+			return 0, err
+		}
+		top = math.Max(top, data.MaxSpeed/3.6)
+	}
+	if top <= 0 {
+		return 0, errors.New("no edge has a speed limit")
+	}
+	return top, nil
+}
+
+// scaleHeuristic sets the scale of the heuristic to the bound, lowered to the
+// cost per metre of any edge shorter than the great-circle distance between
+// its vertices or reweighted below its free-flow cost. It is consistent as no
+// edge is cheaper than its scaled great-circle distance.
+func (s *AStar) scaleHeuristic() {
+	s.scale = s.bound
+	for source, arcs := range s.adjacency {
+		for _, a := range arcs {
+			if d := haversine(s.coords[source], s.coords[a.target]); d > 0 {
+				s.scale = math.Min(s.scale, a.length/d)
+			}
+		}
+	}
+}
+
+// Reweighted returns a copy of the router costing the edges in weights, by
+// their source and target, with their weight instead of by the metric
+func (s *AStar) Reweighted(weights map[[2]int]float64) *AStar {
+	adjacency := make(map[int][]arc, len(s.adjacency))
+	for source, arcs := range s.adjacency {
+		reweighted := make([]arc, len(arcs))
+		for i, a := range arcs {
+			if w, ok := weights[[2]int{source, a.target}]; ok {
+				a.length = w
+			}
+			reweighted[i] = a
+		}
+		adjacency[source] = reweighted
+	}
+
+	r := &AStar{adjacency: adjacency, coords: s.coords, bound: s.bound}
+	r.scaleHeuristic()
+	return r
+}
+
+// haversine returns the great-circle distance between the vertices in metres,
+// X is their longitude and Y their latitude
+func haversine(a, b streets.JVertex) float64 {
+	lat1 := a.Y * math.Pi / 180
+	lat2 := b.Y * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.X - a.X) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// ShortestPath returns the vertices of the cheapest path from source to target
+func (s *AStar) ShortestPath(source, target int) ([]int, error) {
+	path, _, err := s.search(source, target)
+	return path, err
+}
+
+// search returns the vertices of the cheapest path from source to target and
+// the number of vertices settled finding it
+func (s *AStar) search(source, target int) ([]int, int, error) {
+	goal, ok := s.coords[target]
+	if !ok {
+		return nil, 0, ErrNoPath
+	}
+	estimate := func(v int) float64 {
+		return s.scale * haversine(s.coords[v], goal)
+	}
+
+	costs := map[int]float64{source: 0}
+	previous := make(map[int]int)
+	done := make(map[int]bool)
+	queue := &vertexQueue{{vertex: source, distance: estimate(source)}}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(queued)
+		if done[current.vertex] {
+			continue
+		}
+		done[current.vertex] = true
+		if current.vertex == target {
+			break
+		}
+
+		for _, a := range s.adjacency[current.vertex] {
+			cost := costs[current.vertex] + a.length
+			if known, ok := costs[a.target]; ok && known <= cost {
+				continue
+			}
+			costs[a.target] = cost
+			previous[a.target] = current.vertex
+			heap.Push(queue, queued{vertex: a.target, distance: cost + estimate(a.target)})
+		}
+	}
+
+	if !done[target] {
+		return nil, len(done), ErrNoPath
+	}
+	return reversePath(previous, source, target), len(done), nil
+}
@@ -0,0 +1,162 @@
+package routing
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog"
+)
+
+// pathCost returns the cost of the path by the metric
+func pathCost(t *testing.T, g graph.Graph[int, streets.JVertex], path []int, metric Metric) float64 {
+	t.Helper()
+
+	total := 0.0
+	for i := 1; i < len(path); i++ {
+		edge, err := g.Edge(path[i-1], path[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		cost, err := metric.cost(edge.Properties.Data.(streets.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += cost
+	}
+	return total
+}
+
+func TestAStar_MatchesDijkstra(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	root, _ := streets.DefaultGraph("../assets/out.json", 1)
+	d, err := newDijkstra(root.Graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewAStar(root.Graph, Distance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vertices, err := root.GetVertices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 40; i++ {
+		source := vertices[(i*7)%len(vertices)].ID
+		target := vertices[(i*13+5)%len(vertices)].ID
+
+		expected, errD := d.ShortestPath(source, target)
+		path, errS := s.ShortestPath(source, target)
+		assert.Equal(t, errD == nil, errS == nil)
+		if errD != nil {
+			assert.True(t, errors.Is(errS, ErrNoPath))
+			continue
+		}
+		assert.Equal(t, source, path[0])
+		assert.Equal(t, target, path[len(path)-1])
+		assert.True(t, math.Abs(pathCost(t, root.Graph, expected, Distance)-pathCost(t, root.Graph, path, Distance)) < 1e-6)
+	}
+}
+
+// detourGraph builds a slow direct street 1 -> 2 and a fast detour over 3
+func detourGraph(t *testing.T) *streets.StreetGraph {
+	t.Helper()
+	g, err := streets.NewGraphBuilder().
+		WithVertices([]streets.JVertex{{ID: 1, X: 10, Y: 50}, {ID: 2, X: 10.01, Y: 50}, {ID: 3, X: 10.005, Y: 50.003}}).
+		WithEdges([]streets.JEdge{
+			{From: 1, To: 2, Length: 1000, MaxSpeed: "30", ID: "direct"},
+			{From: 1, To: 3, Length: 600, MaxSpeed: "100", ID: "detour-1"},
+			{From: 3, To: 2, Length: 600, MaxSpeed: "100", ID: "detour-2"},
+		}).
+		WithRectangleParts(1).SetTopRightBottomLeftVertices().DivideGraphsIntoRects().
+		PickRect(0).FilterForRect().IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestRouter_Metrics(t *testing.T) {
+	g := detourGraph(t)
+
+	for name, expected := range map[string][]int{
+		"fastest":  {1, 3, 2},
+		"shortest": {1, 2},
+	} {
+		router, err := NewRouter(name, "", g.Graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := router.ShortestPath(1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expected, path)
+	}
+
+	_, err := NewRouter("scenic", "", g.Graph)
+	assert.True(t, err != nil)
+}
+
+func TestAStar_Reweighted(t *testing.T) {
+	g := detourGraph(t)
+	s, err := NewAStar(g.Graph, Distance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a jam on the direct street makes the detour the cheapest
+	path, err := s.Reweighted(map[[2]int]float64{{1, 2}: 5000}).ShortestPath(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{1, 3, 2}, path)
+
+	// the router itself keeps its costs
+	path, err = s.ShortestPath(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{1, 2}, path)
+}
+
+func TestAStar_SettlesFewerThanDijkstra(t *testing.T) {
+	root, pairs := queries(t, 100)
+	d, err := newDijkstra(root.Graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewAStar(root.Graph, Distance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the great-circle distance bounds the length of every edge, up to the
+	// rounding of the lengths
+	assert.True(t, s.scale > 0.99 && s.scale <= 1)
+	fastest, err := NewAStar(root.Graph, TravelTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := topSpeed(root.Graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, fastest.scale > 0.99/top && fastest.scale <= 1/top)
+
+	settledD, settledS := 0, 0
+	for _, p := range pairs {
+		_, n, _ := d.search(p[0], p[1])
+		settledD += n
+		_, n, _ = s.search(p[0], p[1])
+		settledS += n
+	}
+	t.Logf("settled by Dijkstra: %d, by A*: %d", settledD, settledS)
+	assert.True(t, settledS < settledD)
+}
@@ -0,0 +1,104 @@
+package routing
+
+import (
+	"container/heap"
+	"errors"
+	"testing"
+
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog"
+)
+
+// dijkstra finds shortest paths by length, the reference the routers are
+// tested against. Ties are broken by vertex ID, so the same query returns the
+// same path on every rank.
+type dijkstra struct {
+	adjacency map[int][]arc
+}
+
+// newDijkstra reads the adjacency of the graph once for all queries
+func newDijkstra(g graph.Graph[int, streets.JVertex]) (*dijkstra, error) {
+	adjacency, err := readArcs(g, Distance)
+	if err != nil {
+		return nil, err
+	}
+	return &dijkstra{adjacency: adjacency}, nil
+}
+
+// ShortestPath returns the vertices of the shortest path from source to target
+func (d *dijkstra) ShortestPath(source, target int) ([]int, error) {
+	path, _, err := d.search(source, target)
+	return path, err
+}
+
+// search returns the vertices of the shortest path from source to target and
+// the number of vertices settled finding it
+func (d *dijkstra) search(source, target int) ([]int, int, error) {
+	distances := map[int]float64{source: 0}
+	previous := make(map[int]int)
+	done := make(map[int]bool)
+	queue := &vertexQueue{{vertex: source}}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(queued)
+		if done[current.vertex] {
+			continue
+		}
+		done[current.vertex] = true
+		if current.vertex == target {
+			break
+		}
+
+		for _, a := range d.adjacency[current.vertex] {
+			distance := current.distance + a.length
+			if known, ok := distances[a.target]; ok && known <= distance {
+				continue
+			}
+			distances[a.target] = distance
+			previous[a.target] = current.vertex
+			heap.Push(queue, queued{vertex: a.target, distance: distance})
+		}
+	}
+
+	if !done[target] {
+		return nil, len(done), ErrNoPath
+	}
+
+	return reversePath(previous, source, target), len(done), nil
+}
+
+func TestDijkstra_ShortestPath(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	root, _ := streets.DefaultGraph("../assets/out.json", 1)
+	d, err := newDijkstra(root.Graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := d.ShortestPath(269910246, 213322463)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 269910246, path[0])
+	assert.Equal(t, 213322463, path[len(path)-1])
+
+	// every step of the path is an edge of the graph
+	for i := 1; i < len(path); i++ {
+		_, err := root.Graph.Edge(path[i-1], path[i])
+		assert.True(t, err == nil)
+	}
+
+	// the same query returns the same path
+	again, err := d.ShortestPath(269910246, 213322463)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, path, again)
+
+	_, err = d.ShortestPath(269910246, -1)
+	assert.True(t, errors.Is(err, ErrNoPath))
+}
@@ -0,0 +1,534 @@
+package routing
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"pchpc/streets"
+
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// hierarchyKind is the kind of a saved hierarchy in the binary wire format
+const hierarchyKind byte = 0x20
+
+// witnessSettled bounds the vertices a witness search settles. A search
+// giving up early adds a shortcut that might not be needed, which costs
+// space but not correctness.
+const witnessSettled = 200
+
+// Hierarchy finds the cheapest paths by a metric with Contraction
+// Hierarchies. Preprocessing contracts the vertices one by one, adding a
+// shortcut between the neighbours of a vertex wherever the vertex lies on
+// their only cheapest path. A query then searches upwards from both ends,
+// which settles few vertices, and unpacks the shortcuts of the path it met
+// on. Ties are broken by vertex ID, so the same query returns the same path
+// on every rank.
+type Hierarchy struct {
+	metric Metric
+	// fingerprint identifies the edges and metric the hierarchy was built on
+	fingerprint uint64
+	// ids are the vertex IDs in ascending order, the hierarchy numbers the
+	// vertices by their index in it
+	ids   []int
+	index map[int]int
+	// rank is the position of every vertex in the contraction order
+	rank []int
+	// up are the arcs from every vertex to vertices of a higher rank
+	up [][]arc
+	// down are the arcs into every vertex from vertices of a higher rank,
+	// their target is the source of the arc
+	down [][]arc
+	// middles are the vertices shortcuts skip, by their source and target
+	middles map[[2]int]int
+}
+
+// NewHierarchy contracts the graph by the metric
+func NewHierarchy(g graph.Graph[int, streets.JVertex], metric Metric) (*Hierarchy, error) {
+	adjacency, err := readArcs(g, metric)
+	if err != nil {
+		return nil, err
+	}
+	vertices, err := g.AdjacencyMap()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Hierarchy{
+		metric:      metric,
+		fingerprint: fingerprint(adjacency, metric),
+		ids:         make([]int, 0, len(vertices)),
+		middles:     make(map[[2]int]int),
+	}
+	for id := range vertices {
+		h.ids = append(h.ids, id)
+	}
+	sort.Ints(h.ids)
+	h.indexIDs()
+
+	c := newContraction(len(h.ids))
+	for source, arcs := range adjacency {
+		for _, a := range arcs {
+			c.addEdge(h.index[source], h.index[a.target], a.length)
+		}
+	}
+	c.run(h)
+
+	log.Debug().Msgf("Contracted %d vertices with %d shortcuts.", len(h.ids), len(h.middles))
+	return h, nil
+}
+
+// indexIDs numbers the vertices by their index in ids
+func (h *Hierarchy) indexIDs() {
+	h.index = make(map[int]int, len(h.ids))
+	for i, id := range h.ids {
+		h.index[id] = i
+	}
+}
+
+// contraction is the remaining graph while a hierarchy is built
+type contraction struct {
+	out, in []map[int]float64
+	// contracted counts the contracted neighbours of every vertex, which
+	// spreads the contraction evenly over the graph
+	contracted []int
+}
+
+// newContraction returns the contraction of n vertices without edges
+func newContraction(n int) *contraction {
+	c := &contraction{
+		out:        make([]map[int]float64, n),
+		in:         make([]map[int]float64, n),
+		contracted: make([]int, n),
+	}
+	for v := 0; v < n; v++ {
+		c.out[v] = make(map[int]float64)
+		c.in[v] = make(map[int]float64)
+	}
+	return c
+}
+
+// addEdge adds the edge from u to w unless an edge as cheap exists. It
+// reports if the edge was added.
+func (c *contraction) addEdge(u, w int, cost float64) bool {
+	if u == w {
+		return false
+	}
+	if known, ok := c.out[u][w]; ok && known <= cost {
+		return false
+	}
+	c.out[u][w] = cost
+	c.in[w][u] = cost
+	return true
+}
+
+// shortcut is an edge skipping a contracted vertex
+type shortcut struct {
+	from, to int
+	cost     float64
+}
+
+// shortcuts returns the shortcuts contracting v needs, the paths through v
+// without a witness, a path as cheap avoiding v
+func (c *contraction) shortcuts(v int) []shortcut {
+	shortcuts := make([]shortcut, 0)
+	targets := sortedKeys(c.out[v])
+	for _, u := range sortedKeys(c.in[v]) {
+		bound := 0.0
+		for _, w := range targets {
+			bound = math.Max(bound, c.in[v][u]+c.out[v][w])
+		}
+		witnesses := c.witnesses(u, v, bound)
+		for _, w := range targets {
+			if w == u {
+				continue
+			}
+			via := c.in[v][u] + c.out[v][w]
+			if cost, ok := witnesses[w]; ok && cost <= via {
+				continue
+			}
+			shortcuts = append(shortcuts, shortcut{from: u, to: w, cost: via})
+		}
+	}
+	return shortcuts
+}
+
+// witnesses returns the costs of the paths from source avoiding v up to
+// bound, searching a limited number of vertices
+func (c *contraction) witnesses(source, v int, bound float64) map[int]float64 {
+	costs := map[int]float64{source: 0}
+	done := make(map[int]bool)
+	queue := &vertexQueue{{vertex: source}}
+
+	for queue.Len() > 0 && len(done) < witnessSettled {
+		current := heap.Pop(queue).(queued)
+		if done[current.vertex] {
+			continue
+		}
+		if current.distance > bound {
+			break
+		}
+		done[current.vertex] = true
+
+		for _, w := range sortedKeys(c.out[current.vertex]) {
+			if w == v {
+				continue
+			}
+			cost := current.distance + c.out[current.vertex][w]
+			if known, ok := costs[w]; ok && known <= cost {
+				continue
+			}
+			costs[w] = cost
+			heap.Push(queue, queued{vertex: w, distance: cost})
+		}
+	}
+	return costs
+}
+
+// priority returns how attractive contracting v is, lower first: the edges
+// it adds less the edges it removes plus its contracted neighbours
+func (c *contraction) priority(v int) float64 {
+	return float64(len(c.shortcuts(v)) - len(c.in[v]) - len(c.out[v]) + c.contracted[v])
+}
+
+// run contracts the vertices by their priority, which is updated lazily when
+// a vertex is taken from the queue, and stores the arcs and shortcuts in h
+func (c *contraction) run(h *Hierarchy) {
+	n := len(c.out)
+	h.rank = make([]int, n)
+	h.up = make([][]arc, n)
+	h.down = make([][]arc, n)
+
+	queue := make(vertexQueue, 0, n)
+	for v := 0; v < n; v++ {
+		queue = append(queue, queued{vertex: v, distance: c.priority(v)})
+	}
+	heap.Init(&queue)
+
+	for rank := 0; queue.Len() > 0; {
+		current := heap.Pop(&queue).(queued)
+		if p := c.priority(current.vertex); queue.Len() > 0 && p > queue[0].distance {
+			heap.Push(&queue, queued{vertex: current.vertex, distance: p})
+			continue
+		}
+
+		v := current.vertex
+		h.rank[v] = rank
+		rank++
+		for _, s := range c.shortcuts(v) {
+			if c.addEdge(s.from, s.to, s.cost) {
+				h.middles[[2]int{s.from, s.to}] = v
+			}
+		}
+
+		// every neighbour left is contracted later, so has a higher rank
+		for _, w := range sortedKeys(c.out[v]) {
+			h.up[v] = append(h.up[v], arc{target: w, length: c.out[v][w]})
+			delete(c.in[w], v)
+			c.contracted[w]++
+		}
+		for _, u := range sortedKeys(c.in[v]) {
+			h.down[v] = append(h.down[v], arc{target: u, length: c.in[v][u]})
+			delete(c.out[u], v)
+			c.contracted[u]++
+		}
+		c.out[v], c.in[v] = nil, nil
+	}
+}
+
+// sortedKeys returns the keys of the map in ascending order
+func sortedKeys(m map[int]float64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// ShortestPath returns the vertices of the cheapest path from source to target
+func (h *Hierarchy) ShortestPath(source, target int) ([]int, error) {
+	s, ok := h.index[source]
+	if !ok {
+		return nil, ErrNoPath
+	}
+	t, ok := h.index[target]
+	if !ok {
+		return nil, ErrNoPath
+	}
+
+	forward := newUpwardSearch(s, h.up)
+	backward := newUpwardSearch(t, h.down)
+	best, meet := math.Inf(1), -1
+	for {
+		// a search is done once it can not find a cheaper meeting point
+		next := forward
+		if !forward.open(best) || (backward.open(best) && backward.top() < forward.top()) {
+			next = backward
+		}
+		if !next.open(best) {
+			break
+		}
+
+		v, cost := next.settle()
+		other := backward
+		if next == backward {
+			other = forward
+		}
+		if known, ok := other.costs[v]; ok {
+			if total := cost + known; total < best || (total == best && v < meet) {
+				best, meet = total, v
+			}
+		}
+	}
+	if meet < 0 {
+		return nil, ErrNoPath
+	}
+
+	// the packed path runs up from the source to meet and down to the target
+	packed := reversePath(forward.previous, s, meet)
+	for v := meet; v != t; {
+		v = backward.previous[v]
+		packed = append(packed, v)
+	}
+
+	path := []int{source}
+	for i := 1; i < len(packed); i++ {
+		path = h.unpack(packed[i-1], packed[i], path)
+	}
+	return path, nil
+}
+
+// unpack appends the vertex IDs of the arc from u to w after u to path,
+// replacing shortcuts by the arcs they skip
+func (h *Hierarchy) unpack(u, w int, path []int) []int {
+	if middle, ok := h.middles[[2]int{u, w}]; ok {
+		path = h.unpack(u, middle, path)
+		return h.unpack(middle, w, path)
+	}
+	return append(path, h.ids[w])
+}
+
+// upwardSearch is one direction of a hierarchy query, a Dijkstra search
+// along the arcs to vertices of a higher rank
+type upwardSearch struct {
+	arcs     [][]arc
+	costs    map[int]float64
+	previous map[int]int
+	done     map[int]bool
+	queue    *vertexQueue
+}
+
+// newUpwardSearch starts a search from the vertex along the arcs
+func newUpwardSearch(from int, arcs [][]arc) *upwardSearch {
+	return &upwardSearch{
+		arcs:     arcs,
+		costs:    map[int]float64{from: 0},
+		previous: make(map[int]int),
+		done:     make(map[int]bool),
+		queue:    &vertexQueue{{vertex: from}},
+	}
+}
+
+// open checks if the search may still find a path cheaper than best
+func (s *upwardSearch) open(best float64) bool {
+	for s.queue.Len() > 0 && s.done[(*s.queue)[0].vertex] {
+		heap.Pop(s.queue)
+	}
+	return s.queue.Len() > 0 && s.top() < best
+}
+
+// top returns the cost of the next vertex the search settles
+func (s *upwardSearch) top() float64 {
+	return (*s.queue)[0].distance
+}
+
+// settle settles the next vertex of the queue and relaxes its arcs
+func (s *upwardSearch) settle() (int, float64) {
+	current := heap.Pop(s.queue).(queued)
+	s.done[current.vertex] = true
+	for _, a := range s.arcs[current.vertex] {
+		cost := current.distance + a.length
+		if known, ok := s.costs[a.target]; ok && known <= cost {
+			continue
+		}
+		s.costs[a.target] = cost
+		s.previous[a.target] = current.vertex
+		heap.Push(s.queue, queued{vertex: a.target, distance: cost})
+	}
+	return current.vertex, current.distance
+}
+
+// fingerprint hashes the metric and the arcs of a graph, so a saved
+// hierarchy can be matched to the graph it was built on
+func fingerprint(adjacency map[int][]arc, metric Metric) uint64 {
+	sources := make([]int, 0, len(adjacency))
+	for source := range adjacency {
+		sources = append(sources, source)
+	}
+	sort.Ints(sources)
+
+	w := streets.NewWriter(hierarchyKind)
+	w.Int(int(metric))
+	for _, source := range sources {
+		for _, a := range adjacency[source] {
+			w.Int(source)
+			w.Int(a.target)
+			w.Float64(a.length)
+		}
+	}
+	hash := fnv.New64a()
+	hash.Write(w.Bytes())
+	return hash.Sum64()
+}
+
+// Marshal writes the hierarchy in the binary wire format
+func (h *Hierarchy) Marshal() []byte {
+	w := streets.NewWriter(hierarchyKind)
+	w.Int(int(h.metric))
+	w.Uvarint(h.fingerprint)
+	w.IDs(h.ids)
+	for v := range h.ids {
+		w.Int(h.rank[v])
+		for _, arcs := range [][]arc{h.up[v], h.down[v]} {
+			w.Uvarint(uint64(len(arcs)))
+			for _, a := range arcs {
+				w.Int(a.target)
+				w.Float64(a.length)
+			}
+		}
+	}
+
+	keys := make([][2]int, 0, len(h.middles))
+	for key := range h.middles {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	w.Uvarint(uint64(len(keys)))
+	for _, key := range keys {
+		w.Int(key[0])
+		w.Int(key[1])
+		w.Int(h.middles[key])
+	}
+	return w.Bytes()
+}
+
+// UnmarshalHierarchy reads a hierarchy written by Marshal
+func UnmarshalHierarchy(b []byte) (*Hierarchy, error) {
+	r, err := streets.NewReader(b, hierarchyKind)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Hierarchy{metric: Metric(r.Int()), fingerprint: r.Uvarint(), ids: r.IDs()}
+	n := len(h.ids)
+	valid := func(v int) int {
+		if v < 0 || v >= n {
+			err = fmt.Errorf("%w: vertex %d of %d", streets.ErrCodec, v, n)
+			return 0
+		}
+		return v
+	}
+	h.rank = make([]int, n)
+	h.up = make([][]arc, n)
+	h.down = make([][]arc, n)
+	for v := 0; v < n; v++ {
+		h.rank[v] = r.Int()
+		for _, arcs := range []*[]arc{&h.up[v], &h.down[v]} {
+			*arcs = make([]arc, r.Len())
+			for i := range *arcs {
+				(*arcs)[i] = arc{target: valid(r.Int()), length: r.Float64()}
+			}
+		}
+	}
+	h.middles = make(map[[2]int]int)
+	for i := r.Len(); i > 0; i-- {
+		key := [2]int{valid(r.Int()), valid(r.Int())}
+		h.middles[key] = valid(r.Int())
+	}
+
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.indexIDs()
+	return h, nil
+}
+
+// Save writes the hierarchy to a file, through a temporary file, so the file
+// is either complete or missing even if several ranks save it at once
+func (h *Hierarchy) Save(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save hierarchy.")
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(h.Marshal()); err != nil {
+		file.Close()
+		log.Error().Err(err).Msg("Failed to save hierarchy.")
+		return err
+	}
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		log.Error().Err(err).Msg("Failed to save hierarchy.")
+		return err
+	}
+	if err := file.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to save hierarchy.")
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		log.Error().Err(err).Msg("Failed to save hierarchy.")
+		return err
+	}
+	return nil
+}
+
+// LoadHierarchy reads the hierarchy of the graph by the metric from a file.
+// If the file is missing or was built on another graph or metric, the
+// hierarchy is built and saved to the file.
+func LoadHierarchy(path string, g graph.Graph[int, streets.JVertex], metric Metric) (*Hierarchy, error) {
+	adjacency, err := readArcs(g, metric)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		h, err := UnmarshalHierarchy(b)
+		if err == nil && h.metric == metric && h.fingerprint == fingerprint(adjacency, metric) {
+			log.Debug().Msgf("Loaded hierarchy %s.", path)
+			return h, nil
+		}
+		log.Warn().Err(err).Msgf("Hierarchy %s does not match the graph, rebuilding it.", path)
+	case !errors.Is(err, os.ErrNotExist):
+		log.Error().Err(err).Msg("Failed to read hierarchy.")
+		return nil, err
+	}
+
+	h, err := NewHierarchy(g, metric)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.Save(path); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
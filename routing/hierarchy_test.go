@@ -0,0 +1,174 @@
+package routing
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog"
+)
+
+// queries returns n pairs of vertices of the bundled graph, which is returned too
+func queries(tb testing.TB, n int) (*streets.StreetGraph, [][2]int) {
+	tb.Helper()
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	root, _ := streets.DefaultGraph("../assets/out.json", 1)
+	vertices, err := root.GetVertices()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pairs := make([][2]int, n)
+	for i := range pairs {
+		pairs[i] = [2]int{vertices[(i*7)%len(vertices)].ID, vertices[(i*13+5)%len(vertices)].ID}
+	}
+	return root, pairs
+}
+
+func TestHierarchy_MatchesAStar(t *testing.T) {
+	root, pairs := queries(t, 60)
+
+	for _, metric := range []Metric{TravelTime, Distance} {
+		s, err := NewAStar(root.Graph, metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := NewHierarchy(root.Graph, metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range pairs {
+			expected, errS := s.ShortestPath(p[0], p[1])
+			path, errH := h.ShortestPath(p[0], p[1])
+			assert.Equal(t, errS == nil, errH == nil)
+			if errS != nil {
+				assert.True(t, errors.Is(errH, ErrNoPath))
+				continue
+			}
+			// pathCost fails unless every step of the unpacked path is an edge
+			assert.Equal(t, p[0], path[0])
+			assert.Equal(t, p[1], path[len(path)-1])
+			assert.True(t, math.Abs(pathCost(t, root.Graph, expected, metric)-pathCost(t, root.Graph, path, metric)) < 1e-6)
+		}
+	}
+}
+
+func TestHierarchy_SaveLoad(t *testing.T) {
+	root, pairs := queries(t, 20)
+	path := filepath.Join(t.TempDir(), "out.ch")
+
+	built, err := LoadHierarchy(path, root.Graph, TravelTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadHierarchy(path, root.Graph, TravelTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pairs {
+		expected, errB := built.ShortestPath(p[0], p[1])
+		got, errL := loaded.ShortestPath(p[0], p[1])
+		assert.Equal(t, errB, errL)
+		assert.Equal(t, expected, got)
+	}
+
+	// a hierarchy of another metric is rebuilt
+	rebuilt, err := LoadHierarchy(path, root.Graph, Distance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Distance, rebuilt.metric)
+	again, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, string(saved) == string(again))
+
+	_, err = UnmarshalHierarchy(saved[:len(saved)/2])
+	assert.True(t, errors.Is(err, streets.ErrCodec))
+}
+
+// searcher is a router counting the vertices it settles
+type searcher interface {
+	search(source, target int) ([]int, int, error)
+}
+
+// benchmarkRouter routes between the pairs of the bundled graph with the
+// router, reporting the vertices settled per query if it counts them
+func benchmarkRouter(b *testing.B, newRouter func(g graph.Graph[int, streets.JVertex]) (Router, error)) {
+	root, pairs := queries(b, 100)
+	router, err := newRouter(root.Graph)
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, counts := router.(searcher)
+
+	settled := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := pairs[i%len(pairs)]
+		if counts {
+			_, n, _ := s.search(p[0], p[1])
+			settled += n
+		} else {
+			_, _ = router.ShortestPath(p[0], p[1])
+		}
+	}
+	if counts {
+		b.ReportMetric(float64(settled)/float64(b.N), "settled/op")
+	}
+}
+
+// graphRouter routes with graph.ShortestPath
+type graphRouter struct {
+	g graph.Graph[int, streets.JVertex]
+}
+
+func (r graphRouter) ShortestPath(source, target int) ([]int, error) {
+	return graph.ShortestPath(r.g, source, target)
+}
+
+func BenchmarkShortestPath_Graph(b *testing.B) {
+	benchmarkRouter(b, func(g graph.Graph[int, streets.JVertex]) (Router, error) {
+		return graphRouter{g: g}, nil
+	})
+}
+
+func BenchmarkShortestPath_Dijkstra(b *testing.B) {
+	benchmarkRouter(b, func(g graph.Graph[int, streets.JVertex]) (Router, error) {
+		return newDijkstra(g)
+	})
+}
+
+func BenchmarkShortestPath_AStar(b *testing.B) {
+	benchmarkRouter(b, func(g graph.Graph[int, streets.JVertex]) (Router, error) {
+		return NewAStar(g, TravelTime)
+	})
+}
+
+func BenchmarkShortestPath_Hierarchy(b *testing.B) {
+	benchmarkRouter(b, func(g graph.Graph[int, streets.JVertex]) (Router, error) {
+		return NewHierarchy(g, TravelTime)
+	})
+}
+
+func BenchmarkNewHierarchy(b *testing.B) {
+	root, _ := queries(b, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewHierarchy(root.Graph, TravelTime); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
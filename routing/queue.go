@@ -0,0 +1,31 @@
+package routing
+
+// queued is a vertex in the queue with its tentative distance, for A* plus
+// the estimate of the remaining one
+type queued struct {
+	vertex   int
+	distance float64
+}
+
+// vertexQueue is a min-heap of vertices by distance, then ID
+type vertexQueue []queued
+
+func (q vertexQueue) Len() int { return len(q) }
+
+func (q vertexQueue) Less(i, j int) bool {
+	if q[i].distance != q[j].distance {
+		return q[i].distance < q[j].distance
+	}
+	return q[i].vertex < q[j].vertex
+}
+
+func (q vertexQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *vertexQueue) Push(x any) { *q = append(*q, x.(queued)) }
+
+func (q *vertexQueue) Pop() any {
+	old := *q
+	v := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return v
+}
@@ -0,0 +1,112 @@
+// Package routing finds routes for vehicles on a street graph.
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"pchpc/streets"
+
+	"github.com/dominikbraun/graph"
+)
+
+// ErrNoPath is returned if the target can not be reached from the source
+var ErrNoPath = errors.New("target not reachable")
+
+// arc is an outgoing edge in the adjacency list
+type arc struct {
+	target int
+	length float64
+}
+
+// Router finds routes between vertices of a street graph
+type Router interface {
+	// ShortestPath returns the vertices of the cheapest path from source to
+	// target by the metric of the router, or ErrNoPath
+	ShortestPath(source, target int) ([]int, error)
+}
+
+// Metric is the cost of driving along an edge routes minimise
+type Metric int
+
+const (
+	// TravelTime is the free-flow travel time in seconds, the length divided
+	// by the speed limit
+	TravelTime Metric = iota
+	// Distance is the length in metres
+	Distance
+)
+
+// cost returns the cost of the edge by the metric
+func (m Metric) cost(data streets.Data) (float64, error) {
+	if m == Distance {
+		return data.Length, nil
+	}
+	if data.MaxSpeed <= 0 {
+		return 0, fmt.Errorf("edge %s has no speed limit", data.ID)
+	}
+	return data.Length / (data.MaxSpeed / 3.6), nil
+}
+
+// NewRouter returns the router with the given name, "fastest" routes by
+// free-flow travel time and "shortest" by distance. Without a hierarchy path
+// it searches with A*, with one by the Contraction Hierarchy saved there,
+// which is built and saved first if missing.
+func NewRouter(name, hierarchy string, g graph.Graph[int, streets.JVertex]) (Router, error) {
+	var metric Metric
+	switch name {
+	case "", "fastest":
+		metric = TravelTime
+	case "shortest":
+		metric = Distance
+	default:
+		return nil, fmt.Errorf("unknown router %q", name)
+	}
+
+	if hierarchy != "" {
+		return LoadHierarchy(hierarchy, g, metric)
+	}
+	return NewAStar(g, metric)
+}
+
+// readArcs reads the outgoing edges of every vertex with their cost by the
+// metric, sorted by target
+func readArcs(g graph.Graph[int, streets.JVertex], metric Metric) (map[int][]arc, error) {
+	edges, err := g.Edges()
+	if err != nil {
+		return nil, err
+	}
+
+	adjacency := make(map[int][]arc)
+	for _, e := range edges {
+		data, err := streets.GetEdgeData(e)
+		if err != nil {
+			return nil, err
+		}
+		cost, err := metric.cost(data)
+		if err != nil {
+			return nil, err
+		}
+		adjacency[e.Source] = append(adjacency[e.Source], arc{target: e.Target, length: cost})
+	}
+	for _, arcs := range adjacency {
+		sort.Slice(arcs, func(i, j int) bool { return arcs[i].target < arcs[j].target })
+	}
+
+	return adjacency, nil
+}
+
+// reversePath returns the path from source to target following previous
+// back from target
+func reversePath(previous map[int]int, source, target int) []int {
+	path := []int{target}
+	for v := target; v != source; {
+		v = previous[v]
+		path = append(path, v)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
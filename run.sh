@@ -1,3 +1,3 @@
 #!/bin/sh
 
-mpirun -np 4 go run cmd/main.go -mpi --redisURL="redis://pchpc-redis-1:6379" -debug $1
\ No newline at end of file
+mpirun -np 4 go run -tags mpi cmd/main.go -mpi -debug $1
\ No newline at end of file
@@ -0,0 +1,228 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointsKept is the number of checkpoints every rank keeps, so a complete
+// set survives a rank dying while the others write the next one
+const checkpointsKept = 2
+
+// checkpoint is the state of a rank after a step. The state of the engine of
+// a worker is written after it.
+type checkpoint struct {
+	// Size is the number of ranks of the run
+	Size int
+	// Partition is the fingerprint of the rects of the run
+	Partition uint64
+	// Steps is the number of steps simulated
+	Steps int
+	// Parked is the number of vehicles parked so far
+	Parked int
+	// Removed is the number of vehicles taken off the graph from gridlocks,
+	// all so far on rank 0, those not reported yet on a worker
+	Removed int
+	// Summaries are the trips of the vehicles parked on a worker so far
+	Summaries []streets.VehicleSummary
+	// Ghosts are the ghost copies of the vehicles in the halo of a worker
+	Ghosts []streets.Vehicle
+	// Gridlocks are the gridlocks reported so far on rank 0
+	Gridlocks []streets.Gridlock
+	// Blocking are the gridlocks not resolved yet on rank 0, see
+	// streets.GridlockTracker.Current
+	Blocking []streets.Gridlock
+}
+
+// partitionFingerprint hashes the vertices of every rect, so a checkpoint is
+// only restored on the partition it was written on. The order of the vertices
+// inside a rect does not matter.
+func partitionFingerprint(rects []streets.Rect) uint64 {
+	w := streets.NewWriter(checkpointKind)
+	for _, r := range rects {
+		ids := make([]int, 0, len(r.Vertices))
+		for _, v := range r.Vertices {
+			ids = append(ids, v.ID)
+		}
+		sort.Ints(ids)
+		w.IDs(ids)
+	}
+	h := fnv.New64a()
+	h.Write(w.Bytes())
+	return h.Sum64()
+}
+
+// checkpointPath returns the path of the checkpoint of the rank after step
+func checkpointPath(dir string, step, rank int) string {
+	return filepath.Join(dir, fmt.Sprintf("step-%d.rank-%d.ckpt", step, rank))
+}
+
+// writeCheckpoint writes the checkpoint of the rank and the state of its
+// engine on g, if any, and removes the rank's checkpoints older than the last
+// checkpointsKept
+func writeCheckpoint(cfg Config, rank int, ckpt checkpoint, engine *streets.Engine, g *streets.StreetGraph) error {
+	w := streets.NewWriter(checkpointKind)
+	w.Int(ckpt.Size)
+	w.Uvarint(ckpt.Partition)
+	w.Int(ckpt.Steps)
+	w.Int(ckpt.Parked)
+	w.Int(ckpt.Removed)
+	w.Uvarint(uint64(len(ckpt.Summaries)))
+	for _, s := range ckpt.Summaries {
+		w.Record(func(w *streets.Writer) {
+			w.String(s.ID)
+			w.Int(s.Origin)
+			w.Int(s.Destination)
+			w.Int(s.DepartureTick)
+			w.Int(s.ArrivalTick)
+			w.Float64(s.Distance)
+			w.Float64(s.AverageSpeed)
+		})
+	}
+	w.Uvarint(uint64(len(ckpt.Ghosts)))
+	for i := range ckpt.Ghosts {
+		w.Vehicle(&ckpt.Ghosts[i])
+	}
+	for _, gridlocks := range [][]streets.Gridlock{ckpt.Gridlocks, ckpt.Blocking} {
+		w.Uvarint(uint64(len(gridlocks)))
+		for i := range gridlocks {
+			w.Gridlock(&gridlocks[i])
+		}
+	}
+	w.Bool(engine != nil)
+	if engine != nil {
+		if err := w.Engine(engine, g.Graph); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(cfg.Checkpoint, 0o755); err != nil {
+		log.Error().Err(err).Msg("Failed to create checkpoint directory.")
+		return err
+	}
+	// write to a temporary file first, so a checkpoint is either complete or missing
+	path := checkpointPath(cfg.Checkpoint, ckpt.Steps, rank)
+	if err := os.WriteFile(path+".tmp", w.Bytes(), 0o644); err != nil {
+		log.Error().Err(err).Msg("Failed to write checkpoint.")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Error().Err(err).Msg("Failed to write checkpoint.")
+		return err
+	}
+	log.Debug().Msgf("Process %d: Wrote checkpoint %s", rank, path)
+
+	old := checkpointPath(cfg.Checkpoint, ckpt.Steps-checkpointsKept*cfg.CheckpointEvery, rank)
+	if err := os.Remove(old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Msgf("Failed to remove checkpoint %s.", old)
+	}
+	return nil
+}
+
+// latestCheckpoint returns the last step with a checkpoint of every one of size ranks in dir
+func latestCheckpoint(dir string, size int) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "step-*.rank-*.ckpt"))
+	if err != nil {
+		return 0, err
+	}
+
+	ranks := make(map[int]int)
+	for _, path := range paths {
+		var step, rank int
+		if _, err := fmt.Sscanf(filepath.Base(path), "step-%d.rank-%d.ckpt", &step, &rank); err != nil {
+			continue
+		}
+		if rank < size {
+			ranks[step]++
+		}
+	}
+
+	latest := -1
+	for step, n := range ranks {
+		if n == size && step > latest {
+			latest = step
+		}
+	}
+	if latest < 0 {
+		return 0, fmt.Errorf("no complete checkpoint of %d ranks in %s", size, dir)
+	}
+	return latest, nil
+}
+
+// readCheckpoint reads the latest checkpoint of the rank in the restore
+// directory of the config and restores the state of the engine on g, if any.
+// It fails if the checkpoint was written on another partition.
+func readCheckpoint(
+	cfg Config,
+	rank, size int,
+	partition uint64,
+	engine *streets.Engine,
+	g *streets.StreetGraph,
+) (checkpoint, error) {
+	var ckpt checkpoint
+
+	step, err := latestCheckpoint(cfg.Restore, size)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find checkpoint.")
+		return ckpt, err
+	}
+	b, err := os.ReadFile(checkpointPath(cfg.Restore, step, rank))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read checkpoint.")
+		return ckpt, err
+	}
+
+	r, err := streets.NewReader(b, checkpointKind)
+	if err != nil {
+		return ckpt, err
+	}
+	ckpt.Size = r.Int()
+	ckpt.Partition = r.Uvarint()
+	if r.Err() == nil && (ckpt.Size != size || ckpt.Partition != partition) {
+		return ckpt, fmt.Errorf("checkpoint of step %d was written on another partition", step)
+	}
+
+	ckpt.Steps = r.Int()
+	ckpt.Parked = r.Int()
+	ckpt.Removed = r.Int()
+	ckpt.Summaries = make([]streets.VehicleSummary, r.Len())
+	for i := range ckpt.Summaries {
+		s := &ckpt.Summaries[i]
+		r.Record(func(r *streets.Reader) {
+			s.ID = r.String()
+			s.Origin = r.Int()
+			s.Destination = r.Int()
+			s.DepartureTick = r.Int()
+			s.ArrivalTick = r.Int()
+			s.Distance = r.Float64()
+			s.AverageSpeed = r.Float64()
+		})
+	}
+	ckpt.Ghosts = make([]streets.Vehicle, r.Len())
+	for i := range ckpt.Ghosts {
+		ckpt.Ghosts[i] = r.Vehicle()
+	}
+	for _, gridlocks := range []*[]streets.Gridlock{&ckpt.Gridlocks, &ckpt.Blocking} {
+		*gridlocks = make([]streets.Gridlock, r.Len())
+		for i := range *gridlocks {
+			(*gridlocks)[i] = r.Gridlock()
+		}
+	}
+	if r.Bool() {
+		if engine == nil {
+			return ckpt, fmt.Errorf("checkpoint of rank %d holds an engine", rank)
+		}
+		r.Engine(engine, &g.Graph)
+	}
+
+	log.Info().Msgf("Process %d: Restored checkpoint of step %d", rank, ckpt.Steps)
+	return ckpt, r.Err()
+}
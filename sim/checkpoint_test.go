@@ -0,0 +1,142 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pchpc/comm"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/rs/zerolog"
+)
+
+// runResults runs the config on 4 ranks and returns the steps and the output file
+func runResults(t *testing.T, cfg Config) (int, string, error) {
+	t.Helper()
+	cfg.Output = filepath.Join(t.TempDir(), "results.json")
+
+	steps := 0
+	err := comm.RunLocal(4, func(c comm.Communicator) error {
+		result, err := Run(c, cfg)
+		if c.Rank() == 0 {
+			steps = result.Steps
+		}
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	bytes, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return steps, string(bytes), nil
+}
+
+func TestRun_Restore(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	cfg := Config{
+		GraphFile: "../assets/out.json",
+		Vehicles:  10,
+		DT:        1,
+		MinSpeed:  5.5,
+		MaxSpeed:  8.5,
+		Model:     "krauss",
+		Halo:      1,
+		Seed:      3,
+	}
+	steps, uninterrupted, err := runResults(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpointed := cfg
+	checkpointed.Checkpoint = t.TempDir()
+	checkpointed.CheckpointEvery = steps / 4
+	_, output, err := runResults(t, checkpointed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writing checkpoints does not change the run
+	assert.Equal(t, uninterrupted, output)
+
+	// only the last two checkpoints of every rank are kept
+	paths, err := filepath.Glob(filepath.Join(checkpointed.Checkpoint, "*.ckpt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2*4, len(paths))
+
+	// resume from the older one, as if a rank died writing the latest
+	latest, err := latestCheckpoint(checkpointed.Checkpoint, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, latest < steps)
+	os.Remove(checkpointPath(checkpointed.Checkpoint, latest, 2))
+
+	restored := cfg
+	restored.Restore = checkpointed.Checkpoint
+	restoredSteps, output, err := runResults(t, restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, steps, restoredSteps)
+	assert.Equal(t, uninterrupted, output)
+
+	// a checkpoint is only restored on the partition it was written on
+	restored.Partitioner = "multilevel"
+	_, _, err = runResults(t, restored)
+	assert.True(t, err != nil)
+}
+
+func TestRun_RestoreGridlock(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	cfg := Config{
+		GraphFile:     ringGraph(t),
+		Vehicles:      10,
+		DT:            1,
+		MinSpeed:      5.5,
+		MaxSpeed:      8.5,
+		GridlockTicks: 20,
+		Seed:          3,
+	}
+	// a single worker, so the capacity of every edge holds
+	run := func(cfg Config) Result {
+		var result Result
+		err := comm.RunLocal(2, func(c comm.Communicator) error {
+			r, err := Run(c, cfg)
+			if c.Rank() == 0 {
+				result = r
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	uninterrupted := run(cfg)
+	assert.True(t, len(uninterrupted.Gridlocks) > 0)
+	assert.True(t, uninterrupted.Removed > 0)
+
+	// checkpoint while the first gridlock blocks, and stop before it is unblocked
+	checkpointed := cfg
+	checkpointed.Checkpoint = t.TempDir()
+	checkpointed.CheckpointEvery = uninterrupted.Gridlocks[0].Tick + cfg.GridlockTicks/2
+	checkpointed.MaxTicks = checkpointed.CheckpointEvery + 1
+	run(checkpointed)
+
+	// the resumed run reports the gridlocks once and counts every vehicle
+	// removed from them
+	restored := cfg
+	restored.Restore = checkpointed.Checkpoint
+	result := run(restored)
+	assert.Equal(t, uninterrupted.Steps, result.Steps)
+	assert.Equal(t, uninterrupted.Parked, result.Parked)
+	assert.Equal(t, uninterrupted.Removed, result.Removed)
+	assert.Equal(t, uninterrupted.Gridlocks, result.Gridlocks)
+}
@@ -0,0 +1,184 @@
+package sim
+
+import (
+	"pchpc/comm"
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runCoordinator partitions the graph, sends it to the workers together with
+// the trips starting in their rect and coordinates the steps until every
+// vehicle has parked
+func runCoordinator(c comm.Communicator, cfg Config) (Result, error) {
+	numTasks := c.Size()
+
+	// "chunkify", every worker owns one rect
+	g, _ := streets.DefaultGraph(cfg.GraphFile, 1)
+
+	partitioner, err := streets.NewPartitioner(cfg.Partitioner)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create partitioner.")
+		return Result{}, err
+	}
+	rects, err := g.Partition(partitioner, numTasks-1)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to divide graph.")
+		return Result{}, err
+	}
+	log.Debug().Msgf("MPI: Number of tasks: %d My rank: %d", numTasks, c.Rank())
+	log.Debug().Msgf("MPI: Number of rects: %d", len(rects))
+
+	report, err := g.PartitionReport(rects)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to measure partitions.")
+		return Result{}, err
+	}
+	log.Info().Msgf("MPI: Partitions:\n%s", report)
+
+	// parse edges
+	rawEdges, err := g.RawEdges()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get edges.")
+		return Result{}, err
+	}
+
+	// every worker needs the signals at the ends of its edges, which may be
+	// owned by other rects, so all of them are sent
+	signals, err := g.SignalPlans(cfg.Signals)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get signal plans.")
+		return Result{}, err
+	}
+	log.Info().Msgf("MPI: Signalized vertices: %d", len(signals))
+
+	// send rects, edges and signals to other tasks, encoded once for all of them
+	rectBytes := streets.MarshalRects(rects)
+	edgeBytes := streets.MarshalRawEdges(rawEdges)
+	signalBytes := streets.MarshalSignals(signals)
+	for i := 1; i < numTasks; i++ {
+		c.SendBytes(rectBytes, i, rectanglesTag)
+		c.SendBytes(edgeBytes, i, edgesTag)
+		c.SendBytes(signalBytes, i, signalsTag)
+	}
+
+	partition := partitionFingerprint(rects)
+	result := Result{}
+	gridlocks := streets.NewGridlockTracker(cfg.GridlockTicks)
+	if cfg.Restore != "" {
+		// the workers restore their vehicles from their own checkpoints
+		ckpt, err := readCheckpoint(cfg, 0, numTasks, partition, nil, nil)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Steps, result.Parked, result.Removed = ckpt.Steps, ckpt.Parked, ckpt.Removed
+		result.Gridlocks = ckpt.Gridlocks
+		gridlocks.Restore(ckpt.Blocking)
+	} else {
+		// draw the trips, the workers plan the routes of the trips starting
+		// in their rect
+		trips, err := drawTrips(g, rawEdges, numTasks, cfg)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to draw trips.")
+			return Result{}, err
+		}
+		shares, err := assignTrips(trips, streets.VertexOwners(rects), numTasks)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to assign trips.")
+			return Result{}, err
+		}
+		for i := 1; i < numTasks; i++ {
+			c.SendBytes(marshalTrips(shares[i]), i, tripsTag)
+			log.Debug().Msgf("MPI: Sent %d trips to task %d", len(shares[i]), i)
+		}
+	}
+
+	result, err = coordinate(c, cfg, partition, gridlocks, result)
+	if err != nil {
+		return result, err
+	}
+	return gather(c, cfg, result)
+}
+
+// coordinate routes handed off vehicles between the workers until every
+// vehicle has parked or the run reaches cfg.MaxTicks, continuing the steps of
+// result. Gridlocks spanning the edges of any workers are followed by the
+// tracker, reported once when they form, and unblocked by the workers after
+// cfg.GridlockTicks.
+func coordinate(
+	c comm.Communicator,
+	cfg Config,
+	partition uint64,
+	gridlocks *streets.GridlockTracker,
+	result Result,
+) (Result, error) {
+	numTasks := c.Size()
+
+	for {
+		result.Steps++
+		inboxes := make([][]streets.Vehicle, numTasks)
+		ghosts := make([][]streets.Vehicle, numTasks)
+		blocked := make([]streets.BlockedEdge, 0)
+		active := 0
+
+		for i := 1; i < numTasks; i++ {
+			report, err := unmarshalStepReport(c.RecvBytes(i, reportTag))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to unmarshal step report.")
+				return result, err
+			}
+
+			result.Parked += report.Parked
+			result.Removed += report.Removed
+			active += report.Active + len(report.Handoffs)
+			for _, h := range report.Handoffs {
+				inboxes[h.Rank] = append(inboxes[h.Rank], h.Vehicle)
+			}
+			for _, g := range report.Ghosts {
+				ghosts[g.Rank] = append(ghosts[g.Rank], g.Vehicle)
+			}
+			blocked = append(blocked, report.Blocked...)
+		}
+
+		formed, expired := gridlocks.Update(blocked, result.Steps)
+		for _, g := range formed {
+			log.Warn().Msgf("MPI: Gridlock at step %d on vertices %v", g.Tick, g.Vertices)
+			result.Gridlocks = append(result.Gridlocks, g)
+		}
+		for _, g := range expired {
+			log.Warn().Msgf("MPI: Unblocking gridlock on vertices %v after %d steps", g.Vertices, result.Steps-g.Tick)
+		}
+
+		done := active == 0
+		if !done && cfg.MaxTicks > 0 && result.Steps >= cfg.MaxTicks {
+			log.Warn().Msgf("MPI: Stopped after %d steps with %d vehicles driving", result.Steps, active)
+			done = true
+		}
+		for i := 1; i < numTasks; i++ {
+			order := stepOrder{Done: done, Vehicles: inboxes[i], Ghosts: ghosts[i], Unblock: expired}
+			c.SendBytes(order.marshal(), i, orderTag)
+		}
+
+		if done {
+			if active == 0 {
+				log.Info().Msgf("MPI: All vehicles parked after %d steps", result.Steps)
+			}
+			return result, nil
+		}
+
+		if cfg.checkpointDue(result.Steps) {
+			ckpt := checkpoint{
+				Size:      numTasks,
+				Partition: partition,
+				Steps:     result.Steps,
+				Parked:    result.Parked,
+				Removed:   result.Removed,
+				Gridlocks: result.Gridlocks,
+				Blocking:  gridlocks.Current(),
+			}
+			if err := writeCheckpoint(cfg, 0, ckpt, nil, nil); err != nil {
+				return result, err
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package sim
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"pchpc/demand"
+	"pchpc/routing"
+	"pchpc/streets"
+	"pchpc/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tripRand returns the random stream of the vehicle of the trip at index. It
+// only depends on the seed and the index, so a trip is planned the same on
+// any rank.
+func tripRand(seed int64, index int) utils.RNG {
+	root := utils.NewRNG(seed)
+	return root.Stream(uint64(index))
+}
+
+// tripDraws returns the streams rank 0 draws the trips from, independent of
+// the streams of their vehicles
+func tripDraws(seed int64) demand.Stream {
+	return func(index int) utils.RNG {
+		rng := tripRand(seed, index)
+		return rng.Stream(0)
+	}
+}
+
+// drawTrips draws the trips of the run. With a demand file they follow its
+// OD matrix, otherwise cfg.Vehicles trips per task between random vertices
+// depart by the departure profile.
+func drawTrips(g *streets.StreetGraph, rawEdges []streets.RawEdge, numTasks int, cfg Config) ([]demand.Trip, error) {
+	vertices := demandVertices(rawEdges)
+	if len(vertices) < 2 {
+		return nil, errors.New("no two vertices of the graph reach each other")
+	}
+	if cfg.Demand == "" {
+		n := numTasks * cfg.Vehicles
+		profile, err := demand.NewProfile(cfg.Departures, n, cfg.DepartureWindow)
+		if err != nil {
+			return nil, err
+		}
+		return demand.Random(n, vertices, profile, tripDraws(cfg.Seed)), nil
+	}
+
+	matrix, err := demand.ReadFile(cfg.Demand)
+	if err != nil {
+		return nil, err
+	}
+	all, err := g.GetVertices()
+	if err != nil {
+		return nil, err
+	}
+	inDemand := make(map[int]bool, len(vertices))
+	for _, v := range vertices {
+		inDemand[v] = true
+	}
+	coords := make([]streets.JVertex, 0, len(vertices))
+	for _, v := range all {
+		if inDemand[v.ID] {
+			coords = append(coords, v)
+		}
+	}
+	return matrix.Trips(coords, tripDraws(cfg.Seed))
+}
+
+// demandVertices returns the vertices trips start and end at sorted by ID,
+// the largest strongly connected component of the graph. Every vertex of it
+// can reach every other, so no trip starts in a dead end.
+func demandVertices(rawEdges []streets.RawEdge) []int {
+	adjacent := make(map[int][]int)
+	for _, e := range rawEdges {
+		adjacent[e.Source] = append(adjacent[e.Source], e.Target)
+		if _, ok := adjacent[e.Target]; !ok {
+			adjacent[e.Target] = nil
+		}
+	}
+	vertices := make([]int, 0, len(adjacent))
+	for v := range adjacent {
+		vertices = append(vertices, v)
+	}
+	sort.Ints(vertices)
+
+	largest := make([]int, 0)
+	for _, component := range components(vertices, adjacent) {
+		if len(component) > len(largest) {
+			largest = component
+		}
+	}
+	sort.Ints(largest)
+	return largest
+}
+
+// components returns the strongly connected components with Tarjan's
+// algorithm, iteratively so long roads do not exhaust the stack
+func components(vertices []int, adjacent map[int][]int) [][]int {
+	type frame struct {
+		vertex, next int
+	}
+
+	index := make(map[int]int, len(vertices))
+	low := make(map[int]int, len(vertices))
+	onStack := make(map[int]bool, len(vertices))
+	stack := make([]int, 0)
+	result := make([][]int, 0)
+
+	for _, root := range vertices {
+		if _, ok := index[root]; ok {
+			continue
+		}
+		frames := []frame{{vertex: root}}
+		index[root], low[root] = len(index), len(index)
+		stack = append(stack, root)
+		onStack[root] = true
+
+		for len(frames) > 0 {
+			f := &frames[len(frames)-1]
+			if f.next < len(adjacent[f.vertex]) {
+				w := adjacent[f.vertex][f.next]
+				f.next++
+				if _, ok := index[w]; !ok {
+					index[w], low[w] = len(index), len(index)
+					stack = append(stack, w)
+					onStack[w] = true
+					frames = append(frames, frame{vertex: w})
+				} else if onStack[w] && index[w] < low[f.vertex] {
+					low[f.vertex] = index[w]
+				}
+				continue
+			}
+
+			v := f.vertex
+			frames = frames[:len(frames)-1]
+			if len(frames) > 0 {
+				parent := frames[len(frames)-1].vertex
+				if low[v] < low[parent] {
+					low[parent] = low[v]
+				}
+			}
+			if low[v] != index[v] {
+				continue
+			}
+			component := make([]int, 0)
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			result = append(result, component)
+		}
+	}
+
+	return result
+}
+
+// planTrips plans the route of every trip on g and draws the speed of its
+// vehicle from the vehicle's stream. Trips without a route are dropped.
+func planTrips(trips []demand.Trip, g *streets.StreetGraph, router routing.Router, cfg Config) []streets.Vehicle {
+	vehicles := make([]streets.Vehicle, 0, len(trips))
+
+	dt := cfg.DT
+	if dt <= 0 {
+		dt = 1
+	}
+
+	for _, t := range trips {
+		path, err := router.ShortestPath(t.Origin, t.Destination)
+		if err != nil {
+			log.Warn().Msgf("No route from %d to %d, dropping trip %d.", t.Origin, t.Destination, t.Index)
+			continue
+		}
+
+		rng := tripRand(cfg.Seed, t.Index)
+		speed := rng.Between(cfg.MinSpeed, cfg.MaxSpeed)
+		v := streets.NewVehicle(strconv.Itoa(t.Index), speed, path, &g.Graph)
+		v.SetRNG(rng)
+		v.DepartureTick = int(t.Departure / dt)
+		vehicles = append(vehicles, v)
+	}
+
+	return vehicles
+}
+
+// planRoutes plans the routes of the trips on the complete graph built from the raw edges
+func planRoutes(trips []demand.Trip, rawEdges []streets.RawEdge, rects []streets.Rect, cfg Config) ([]streets.Vehicle, error) {
+	full, err := streets.GraphFromRects(rawEdges, rects)
+	if err != nil {
+		return nil, err
+	}
+	router, err := routing.NewRouter(cfg.Router, cfg.Hierarchy, full.Graph)
+	if err != nil {
+		return nil, err
+	}
+	return planTrips(trips, full, router, cfg), nil
+}
+
+// Trips draws the trips of a run in a single process on g, the trips of the
+// demand file or cfg.Vehicles random trips in total
+func Trips(g *streets.StreetGraph, cfg Config) ([]demand.Trip, error) {
+	rawEdges, err := g.RawEdges()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get edges.")
+		return nil, err
+	}
+	trips, err := drawTrips(g, rawEdges, 1, cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to draw trips.")
+		return nil, err
+	}
+	return trips, nil
+}
+
+// Vehicles creates the vehicles of a run in a single process on g, for the
+// trips of the demand file or cfg.Vehicles random trips in total. They are the
+// vehicles a distributed run with the same seed and as many trips drives.
+func Vehicles(g *streets.StreetGraph, cfg Config) ([]streets.Vehicle, error) {
+	trips, err := Trips(g, cfg)
+	if err != nil {
+		return nil, err
+	}
+	router, err := routing.NewRouter(cfg.Router, cfg.Hierarchy, g.Graph)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create router.")
+		return nil, err
+	}
+	return planTrips(trips, g, router, cfg), nil
+}
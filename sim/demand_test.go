@@ -0,0 +1,151 @@
+package sim
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"testing"
+
+	"pchpc/comm"
+	"pchpc/demand"
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/rs/zerolog"
+)
+
+func TestPlanRoutes_IndependentOfRanks(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	root, _ := streets.DefaultGraph("../assets/out.json", 1)
+	rawEdges, err := root.RawEdges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{MinSpeed: 5.5, MaxSpeed: 8.5, Seed: 42}
+	trips := demand.Random(60, demandVertices(rawEdges), demand.AtOnce{}, tripDraws(cfg.Seed))
+
+	// a single worker plans all routes
+	single, err := root.DivideIntoRects(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := planRoutes(trips, rawEdges, single, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(trips), len(expected))
+
+	// four workers plan the routes starting in their rects
+	rects, err := root.Partition(streets.NewMultilevelPartitioner(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := assignTrips(trips, streets.VertexOwners(rects), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned := make([]streets.Vehicle, 0)
+	for _, share := range shares {
+		vehicles, err := planRoutes(share, rawEdges, rects, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		planned = append(planned, vehicles...)
+	}
+
+	index := func(v streets.Vehicle) int {
+		i, _ := strconv.Atoi(v.ID)
+		return i
+	}
+	sort.Slice(planned, func(i, j int) bool { return index(planned[i]) < index(planned[j]) })
+
+	assert.Equal(t, len(expected), len(planned))
+	for i := range expected {
+		assert.Equal(t, expected[i].ID, planned[i].ID)
+		assert.Equal(t, expected[i].Path, planned[i].Path)
+		assert.Equal(t, expected[i].Speed, planned[i].Speed)
+		assert.Equal(t, expected[i].PathLimit, planned[i].PathLimit)
+	}
+
+	// another seed plans other routes
+	cfg.Seed = 43
+	other, err := planRoutes(demand.Random(60, demandVertices(rawEdges), demand.AtOnce{}, tripDraws(cfg.Seed)), rawEdges, single, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	differs := false
+	for i := range other {
+		differs = differs || other[i].Speed != expected[i].Speed
+	}
+	assert.True(t, differs)
+}
+
+func TestVehicles_MatchesDistributedRun(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	cfg := Config{
+		GraphFile:       "../assets/out.json",
+		Vehicles:        6,
+		Departures:      "uniform",
+		DepartureWindow: 60,
+		DT:              1,
+		MinSpeed:        5.5,
+		MaxSpeed:        8.5,
+		Seed:            5,
+	}
+
+	// three ranks draw 3*6 trips
+	var output *Output
+	err := comm.RunLocal(3, func(c comm.Communicator) error {
+		result, err := Run(c, cfg)
+		if c.Rank() == 0 {
+			output = result.Output
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single process with as many trips creates the same vehicles
+	root, _ := streets.DefaultGraph(cfg.GraphFile, 1)
+	single := cfg
+	single.Vehicles = 3 * cfg.Vehicles
+	vehicles, err := Vehicles(root, single)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, single.Vehicles, len(vehicles))
+	assert.Equal(t, len(vehicles), len(output.Vehicles))
+	byID := make(map[string]streets.Vehicle, len(vehicles))
+	for _, v := range vehicles {
+		byID[v.ID] = v
+	}
+	for _, s := range output.Vehicles {
+		v, ok := byID[s.ID]
+		assert.True(t, ok)
+		assert.Equal(t, v.Path[0], s.Origin)
+		assert.Equal(t, v.Path[len(v.Path)-1], s.Destination)
+		assert.Equal(t, v.DepartureTick, s.DepartureTick)
+		assert.True(t, math.Abs(v.PathLimit-s.Distance) < 1e-6)
+	}
+}
+
+func TestVehicles_NoReachablePair(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	// a one-way street reaches no vertex from its end
+	g, err := streets.NewGraphBuilder().
+		WithVertices([]streets.JVertex{{ID: 1, X: 10, Y: 50}, {ID: 2, X: 10.001, Y: 50}}).
+		WithEdges([]streets.JEdge{{From: 1, To: 2, Length: 100, MaxSpeed: "50", ID: "1"}}).
+		WithRectangleParts(1).SetTopRightBottomLeftVertices().DivideGraphsIntoRects().
+		PickRect(0).FilterForRect().IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Vehicles(g, Config{Vehicles: 5, DT: 1, MinSpeed: 5.5, MaxSpeed: 8.5})
+	assert.True(t, err != nil)
+}
@@ -0,0 +1,177 @@
+package sim
+
+import (
+	"pchpc/demand"
+	"pchpc/streets"
+)
+
+const (
+	rectanglesTag = iota + 1
+	edgesTag
+	tripsTag
+	reportTag
+	orderTag
+	resultsTag
+	signalsTag
+)
+
+// stepReport is sent by every worker to rank 0 after each step
+type stepReport struct {
+	Active int `json:"active"`
+	Parked int `json:"parked"`
+	// Removed is the number of vehicles stuck in gridlocks the worker took
+	// off its graph by the last order
+	Removed  int               `json:"removed"`
+	Handoffs []streets.Handoff `json:"handoffs"`
+	Ghosts   []streets.Ghost   `json:"ghosts"`
+	// Blocked are the edges of the worker waiting for a full next edge
+	Blocked []streets.BlockedEdge `json:"blocked"`
+}
+
+// stepOrder is the answer of rank 0 to the step reports, carrying the vehicles
+// handed off to the worker, the ghost copies of the vehicles in its halo, the
+// gridlocks which lasted too long and whether the run is done
+type stepOrder struct {
+	Done     bool              `json:"done"`
+	Vehicles []streets.Vehicle `json:"vehicles"`
+	Ghosts   []streets.Vehicle `json:"ghosts"`
+	// Unblock are the gridlocks whose first vehicles the workers take off
+	// their graph
+	Unblock []streets.Gridlock `json:"unblock"`
+}
+
+// workerResults is sent by every worker to rank 0 once all vehicles have parked
+type workerResults struct {
+	Vehicles []streets.VehicleSummary `json:"vehicles"`
+	Edges    []streets.EdgeCounter    `json:"edges"`
+}
+
+// kinds of the step messages in the binary wire format, after those of package streets
+const (
+	reportKind byte = 0x10 + iota
+	orderKind
+	tripsKind
+	checkpointKind
+)
+
+// marshalTrips encodes trips in the binary wire format
+func marshalTrips(trips []demand.Trip) []byte {
+	w := streets.NewWriter(tripsKind)
+	w.Uvarint(uint64(len(trips)))
+	for _, t := range trips {
+		w.Int(t.Index)
+		w.Int(t.Origin)
+		w.Int(t.Destination)
+		w.Float64(t.Departure)
+	}
+	return w.Bytes()
+}
+
+// unmarshalTrips decodes trips encoded by marshalTrips
+func unmarshalTrips(b []byte) ([]demand.Trip, error) {
+	r, err := streets.NewReader(b, tripsKind)
+	if err != nil {
+		return nil, err
+	}
+	trips := make([]demand.Trip, r.Len())
+	for i := range trips {
+		trips[i] = demand.Trip{Index: r.Int(), Origin: r.Int(), Destination: r.Int(), Departure: r.Float64()}
+	}
+	return trips, r.Err()
+}
+
+// marshal encodes the report in the binary wire format
+func (r *stepReport) marshal() []byte {
+	w := streets.NewWriter(reportKind)
+	w.Int(r.Active)
+	w.Int(r.Parked)
+	w.Int(r.Removed)
+	w.Uvarint(uint64(len(r.Handoffs)))
+	for i := range r.Handoffs {
+		w.Int(r.Handoffs[i].Rank)
+		w.Vehicle(&r.Handoffs[i].Vehicle)
+	}
+	w.Uvarint(uint64(len(r.Ghosts)))
+	for i := range r.Ghosts {
+		w.Int(r.Ghosts[i].Rank)
+		w.Vehicle(&r.Ghosts[i].Vehicle)
+	}
+	w.Uvarint(uint64(len(r.Blocked)))
+	for _, b := range r.Blocked {
+		w.Int(b.Source)
+		w.Int(b.Target)
+		w.Int(b.Next)
+	}
+	return w.Bytes()
+}
+
+// unmarshalStepReport decodes a report encoded by stepReport.marshal
+func unmarshalStepReport(b []byte) (stepReport, error) {
+	var report stepReport
+	r, err := streets.NewReader(b, reportKind)
+	if err != nil {
+		return report, err
+	}
+
+	report.Active = r.Int()
+	report.Parked = r.Int()
+	report.Removed = r.Int()
+	report.Handoffs = make([]streets.Handoff, r.Len())
+	for i := range report.Handoffs {
+		report.Handoffs[i].Rank = r.Int()
+		report.Handoffs[i].Vehicle = r.Vehicle()
+	}
+	report.Ghosts = make([]streets.Ghost, r.Len())
+	for i := range report.Ghosts {
+		report.Ghosts[i].Rank = r.Int()
+		report.Ghosts[i].Vehicle = r.Vehicle()
+	}
+	report.Blocked = make([]streets.BlockedEdge, r.Len())
+	for i := range report.Blocked {
+		report.Blocked[i] = streets.BlockedEdge{Source: r.Int(), Target: r.Int(), Next: r.Int()}
+	}
+	return report, r.Err()
+}
+
+// marshal encodes the order in the binary wire format
+func (o *stepOrder) marshal() []byte {
+	w := streets.NewWriter(orderKind)
+	w.Bool(o.Done)
+	w.Uvarint(uint64(len(o.Vehicles)))
+	for i := range o.Vehicles {
+		w.Vehicle(&o.Vehicles[i])
+	}
+	w.Uvarint(uint64(len(o.Ghosts)))
+	for i := range o.Ghosts {
+		w.Vehicle(&o.Ghosts[i])
+	}
+	w.Uvarint(uint64(len(o.Unblock)))
+	for i := range o.Unblock {
+		w.Gridlock(&o.Unblock[i])
+	}
+	return w.Bytes()
+}
+
+// unmarshalStepOrder decodes an order encoded by stepOrder.marshal
+func unmarshalStepOrder(b []byte) (stepOrder, error) {
+	var order stepOrder
+	r, err := streets.NewReader(b, orderKind)
+	if err != nil {
+		return order, err
+	}
+
+	order.Done = r.Bool()
+	order.Vehicles = make([]streets.Vehicle, r.Len())
+	for i := range order.Vehicles {
+		order.Vehicles[i] = r.Vehicle()
+	}
+	order.Ghosts = make([]streets.Vehicle, r.Len())
+	for i := range order.Ghosts {
+		order.Ghosts[i] = r.Vehicle()
+	}
+	order.Unblock = make([]streets.Gridlock, r.Len())
+	for i := range order.Unblock {
+		order.Unblock[i] = r.Gridlock()
+	}
+	return order, r.Err()
+}
@@ -0,0 +1,75 @@
+package sim
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"pchpc/comm"
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sendResults sends the trips of the vehicles parked on the worker and its edge counters to rank 0
+func sendResults(c comm.Communicator, vehicles []streets.VehicleSummary, edges []streets.EdgeCounter) error {
+	marshal, err := json.Marshal(workerResults{Vehicles: vehicles, Edges: edges})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal results.")
+		return err
+	}
+	c.SendBytes(marshal, 0, resultsTag)
+	return nil
+}
+
+// gather receives the results of every worker, merges them into the output of
+// the run and writes it to the output file of the config
+func gather(c comm.Communicator, cfg Config, result Result) (Result, error) {
+	dt := cfg.DT
+	if dt <= 0 {
+		dt = 1
+	}
+	output := &Output{
+		Ticks:     result.Steps,
+		DT:        dt,
+		Vehicles:  make([]streets.VehicleSummary, 0),
+		Gridlocks: result.Gridlocks,
+		Removed:   result.Removed,
+	}
+
+	edges := make([][]streets.EdgeCounter, 0, c.Size()-1)
+	for i := 1; i < c.Size(); i++ {
+		var results workerResults
+		err := json.Unmarshal(c.RecvBytes(i, resultsTag), &results)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal results.")
+			return result, err
+		}
+
+		output.Vehicles = append(output.Vehicles, results.Vehicles...)
+		edges = append(edges, results.Edges)
+	}
+
+	sort.Slice(output.Vehicles, func(i, j int) bool {
+		return output.Vehicles[i].ID < output.Vehicles[j].ID
+	})
+	output.Edges = streets.MergeEdgeCounters(edges...)
+	result.Output = output
+
+	if cfg.Output == "" {
+		return result, nil
+	}
+
+	marshal, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal output.")
+		return result, err
+	}
+	if err := os.WriteFile(cfg.Output, marshal, 0o664); err != nil {
+		log.Error().Err(err).Msg("Failed to write output file.")
+		return result, err
+	}
+	log.Info().Msgf("MPI: Results of %d vehicles written to %s", len(output.Vehicles), cfg.Output)
+
+	return result, nil
+}
@@ -0,0 +1,26 @@
+package sim
+
+import (
+	"fmt"
+
+	"pchpc/demand"
+)
+
+// assignTrips splits the trips between the ranks owning their origin vertex,
+// worker i owning rect i-1. The share of rank 0 stays empty.
+func assignTrips(trips []demand.Trip, owners map[int]int, numTasks int) ([][]demand.Trip, error) {
+	shares := make([][]demand.Trip, numTasks)
+	for i := range shares {
+		shares[i] = make([]demand.Trip, 0)
+	}
+
+	for _, t := range trips {
+		rect, ok := owners[t.Origin]
+		if !ok || rect+1 >= numTasks {
+			return nil, fmt.Errorf("no rank owns the origin %d of trip %d", t.Origin, t.Index)
+		}
+		shares[rect+1] = append(shares[rect+1], t)
+	}
+
+	return shares, nil
+}
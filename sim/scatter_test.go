@@ -0,0 +1,61 @@
+package sim
+
+import (
+	"testing"
+
+	"pchpc/demand"
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/rs/zerolog"
+)
+
+func TestAssignTrips(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	root, _ := streets.DefaultGraph("../assets/out.json", 1)
+	rects, err := root.DivideIntoRects(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := streets.VertexOwners(rects)
+
+	vertices, err := root.GetVertices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	trips := make([]demand.Trip, 0)
+	for i, v := range vertices {
+		trips = append(trips, demand.Trip{Index: i, Origin: v.ID})
+	}
+
+	shares, err := assignTrips(trips, owners, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 4, len(shares))
+	assert.Equal(t, 0, len(shares[0]))
+
+	total := 0
+	for rank := 1; rank < 4; rank++ {
+		// every worker gets the trips starting in its rect
+		assert.Equal(t, len(rects[rank-1].Vertices), len(shares[rank]))
+		for _, tr := range shares[rank] {
+			assert.Equal(t, rank-1, owners[tr.Origin])
+		}
+		total += len(shares[rank])
+	}
+	assert.Equal(t, len(trips), total)
+}
+
+func TestAssignTrips_UnknownOrigin(t *testing.T) {
+	trips := []demand.Trip{{Index: 0, Origin: 1}}
+
+	_, err := assignTrips(trips, map[int]int{2: 0}, 2)
+	assert.True(t, err != nil)
+
+	// the origin belongs to a rect without a worker
+	_, err = assignTrips(trips, map[int]int{1: 1}, 2)
+	assert.True(t, err != nil)
+}
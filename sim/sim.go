@@ -0,0 +1,130 @@
+// Package sim runs the distributed simulation on top of a comm.Communicator.
+// Rank 0 partitions the graph, creates the vehicles and coordinates, every
+// other rank drives the vehicles on the graph of its rect.
+package sim
+
+import (
+	"errors"
+
+	"pchpc/comm"
+	"pchpc/streets"
+)
+
+// Config configures a distributed simulation run
+type Config struct {
+	// GraphFile is the path to the graph JSON or SQLite file, read by rank 0
+	GraphFile string
+	// Vehicles is the number of vehicles per task. Rank 0 draws the origins
+	// and destinations of all of them, the worker owning an origin plans the
+	// route.
+	Vehicles int
+	// Demand is the path of an OD matrix in JSON, see demand.Matrix. If set,
+	// it replaces the random trips of Vehicles.
+	Demand string
+	// Departures is the name of the profile the random trips depart by, see
+	// demand.NewProfile
+	Departures string
+	// DepartureWindow is the duration in seconds the random trips depart in
+	DepartureWindow float64
+	// Router is the name of the router planning the routes of the trips, see
+	// routing.NewRouter
+	Router string
+	// Hierarchy is the path of the Contraction Hierarchy the router uses
+	// instead of A*, built and saved there if missing
+	Hierarchy string
+	// MinSpeed and MaxSpeed bound the initial speed of the vehicles
+	MinSpeed, MaxSpeed float64
+	// DT is the length of a tick in seconds
+	DT float64
+	// MaxTicks is the number of ticks the run stops after, vehicles still
+	// driving are left out of the results. 0 runs until all vehicles parked.
+	MaxTicks int
+	// GridlockTicks is the number of ticks a gridlock lasts before the first
+	// vehicles of its edges are taken off the graph, 0 for never
+	GridlockTicks int
+	// Model is the name of the car-following model, see streets.NewCarFollowingModel
+	Model string
+	// Partitioner is the name of the partitioner dividing the graph between
+	// the workers, see streets.NewPartitioner
+	Partitioner string
+	// Halo is the depth of the halo of ghost edges around the graph of every
+	// worker, 0 for none
+	Halo int
+	// Rerouting is the share of the vehicles rerouting around congestion,
+	// from 0 for none to 1 for all
+	Rerouting float64
+	// RerouteInterval is the time in seconds between two reroutes of a
+	// vehicle, the default of streets.NewRerouting if 0
+	RerouteInterval float64
+	// Signals generates traffic signals at the vertices with three or more
+	// incoming edges and no plan in the graph file
+	Signals bool
+	// Seed seeds the demand. The same seed plans the same routes on any
+	// number of ranks.
+	Seed int64
+	// Output is the path of the JSON file rank 0 writes the merged results to,
+	// nothing is written if empty
+	Output string
+	// Checkpoint is the directory every rank writes its state to every
+	// CheckpointEvery steps, nothing is written if empty
+	Checkpoint string
+	// CheckpointEvery is the number of steps between two checkpoints
+	CheckpointEvery int
+	// Restore is the directory of the checkpoints the run resumes from, the
+	// latest step with a checkpoint of every rank is restored. The run needs
+	// the same number of ranks and the same partition as the one written it.
+	Restore string
+}
+
+// checkpointDue checks if the ranks write a checkpoint after step
+func (cfg Config) checkpointDue(step int) bool {
+	return cfg.Checkpoint != "" && cfg.CheckpointEvery > 0 && step%cfg.CheckpointEvery == 0
+}
+
+// Result summarises a run from the perspective of a single rank
+type Result struct {
+	// Parked is the number of vehicles parked on a worker, or on all workers for rank 0
+	Parked int
+	// Steps is the number of ticks until all vehicles parked or the run
+	// stopped after MaxTicks
+	Steps int
+	// Removed is the number of vehicles taken off the graph from gridlocks,
+	// only on rank 0
+	Removed int
+	// Gridlocks are the gridlocks detected during the run, each when it
+	// formed, only on rank 0
+	Gridlocks []streets.Gridlock
+	// Output holds the results of all workers, only on rank 0
+	Output *Output
+}
+
+// Output are the merged results of all workers
+type Output struct {
+	// Ticks is the number of ticks until all vehicles parked or the run
+	// stopped after MaxTicks
+	Ticks int `json:"ticks"`
+	// DT is the length of a tick in seconds
+	DT float64 `json:"dt"`
+	// Vehicles are the trips of all vehicles, sorted by ID
+	Vehicles []streets.VehicleSummary `json:"vehicles"`
+	// Edges are the traffic counters of all edges vehicles drove on
+	Edges []streets.EdgeCounter `json:"edges"`
+	// Gridlocks are the cycles of full edges blocking each other, each
+	// reported in the tick it formed
+	Gridlocks []streets.Gridlock `json:"gridlocks,omitempty"`
+	// Removed is the number of vehicles taken off the graph from gridlocks,
+	// which are missing from Vehicles
+	Removed int `json:"removed,omitempty"`
+}
+
+// Run runs the simulation on the rank of the communicator
+func Run(c comm.Communicator, cfg Config) (Result, error) {
+	if c.Size() < 2 {
+		return Result{}, errors.New("at least two ranks are needed, rank 0 only coordinates")
+	}
+
+	if c.Rank() == 0 {
+		return runCoordinator(c, cfg)
+	}
+	return runWorker(c, cfg)
+}
@@ -0,0 +1,373 @@
+package sim
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"pchpc/comm"
+	"pchpc/demand"
+	"pchpc/streets"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/rs/zerolog"
+)
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		partitioner string
+		halo        int
+		signals     bool
+	}{
+		{name: "strips", partitioner: "strips"},
+		{name: "multilevel", partitioner: "multilevel"},
+		{name: "halo", partitioner: "strips", halo: 2},
+		{name: "signals", partitioner: "strips", halo: 1, signals: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testRun(t, tc.partitioner, tc.halo, tc.signals)
+		})
+	}
+}
+
+func testRun(t *testing.T, partitioner string, halo int, signals bool) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	cfg := Config{
+		GraphFile:   "../assets/out.json",
+		Vehicles:    12,
+		DT:          1,
+		MinSpeed:    5.5,
+		MaxSpeed:    8.5,
+		Partitioner: partitioner,
+		Halo:        halo,
+		Signals:     signals,
+		Output:      filepath.Join(t.TempDir(), "results.json"),
+	}
+
+	var mu sync.Mutex
+	results := make(map[int]Result)
+
+	err := comm.RunLocal(4, func(c comm.Communicator) error {
+		result, err := Run(c, cfg)
+		mu.Lock()
+		defer mu.Unlock()
+		results[c.Rank()] = result
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parked := 0
+	for rank := 1; rank < 4; rank++ {
+		parked += results[rank].Parked
+		// the workers stop in the same step as the coordinator
+		assert.Equal(t, results[0].Steps, results[rank].Steps)
+	}
+
+	// every vehicle created by rank 0 parked on some worker
+	assert.Equal(t, 4*cfg.Vehicles, parked)
+	assert.Equal(t, parked, results[0].Parked)
+
+	// rank 0 gathered the trip of every vehicle
+	bytes, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output Output
+	if err := json.Unmarshal(bytes, &output); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, parked, len(output.Vehicles))
+	assert.Equal(t, results[0].Steps, output.Ticks)
+	for _, v := range output.Vehicles {
+		assert.True(t, v.ArrivalTick > v.DepartureTick && v.ArrivalTick <= output.Ticks)
+		assert.True(t, v.Distance > 0)
+	}
+
+	entered := 0
+	for _, e := range output.Edges {
+		entered += e.Entered
+	}
+	assert.True(t, entered >= len(output.Vehicles))
+}
+
+func TestRun_SingleRank(t *testing.T) {
+	err := comm.RunLocal(1, func(c comm.Communicator) error {
+		_, err := Run(c, Config{GraphFile: "../assets/out.json"})
+		return err
+	})
+	if err == nil {
+		t.Error("Expected an error for a single rank")
+	}
+}
+
+func TestRun_Reproducible(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	run := func(seed int64) []byte {
+		cfg := Config{
+			GraphFile: "../assets/out.json",
+			Vehicles:  8,
+			DT:        0.5,
+			MinSpeed:  5.5,
+			MaxSpeed:  8.5,
+			Model:     "krauss",
+			Seed:      seed,
+			Output:    filepath.Join(t.TempDir(), "results.json"),
+		}
+		err := comm.RunLocal(3, func(c comm.Communicator) error {
+			_, err := Run(c, cfg)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := os.ReadFile(cfg.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes
+	}
+
+	// the same seed reproduces the results of the stochastic model
+	first := run(7)
+	assert.Equal(t, string(first), string(run(7)))
+	assert.True(t, string(first) != string(run(8)))
+}
+
+func TestRun_Demand(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	// two zones, the western and the eastern half of the graph
+	root, _ := streets.DefaultGraph("../assets/out.json", 1)
+	vertices, err := root.GetVertices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	minX, maxX := vertices[0].X, vertices[0].X
+	minY, maxY := vertices[0].Y, vertices[0].Y
+	for _, v := range vertices {
+		minX, maxX = math.Min(minX, v.X), math.Max(maxX, v.X)
+		minY, maxY = math.Min(minY, v.Y), math.Max(maxY, v.Y)
+	}
+	midX := (minX + maxX) / 2
+	box := func(left, right float64) [][2]float64 {
+		return [][2]float64{{left, minY - 1}, {right, minY - 1}, {right, maxY + 1}, {left, maxY + 1}}
+	}
+	matrix := demand.Matrix{
+		Zones: []demand.Zone{
+			{ID: "west", Polygon: box(minX-1, midX)},
+			{ID: "east", Polygon: box(midX, maxX+1)},
+		},
+		Slices: []demand.Slice{
+			{Start: 0, End: 60, Flows: []demand.Flow{{From: "west", To: "east", Trips: 10}}},
+			{Start: 60, End: 120, Flows: []demand.Flow{{From: "east", To: "west", Trips: 6}}},
+		},
+	}
+	bytes, err := json.Marshal(matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		GraphFile: "../assets/out.json",
+		Demand:    filepath.Join(t.TempDir(), "demand.json"),
+		DT:        1,
+		MinSpeed:  5.5,
+		MaxSpeed:  8.5,
+		Output:    filepath.Join(t.TempDir(), "results.json"),
+	}
+	if err := os.WriteFile(cfg.Demand, bytes, 0o664); err != nil {
+		t.Fatal(err)
+	}
+
+	err = comm.RunLocal(3, func(c comm.Communicator) error {
+		_, err := Run(c, cfg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, err = os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output Output
+	if err := json.Unmarshal(bytes, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	// every trip of the matrix arrived, departing in its slice
+	assert.Equal(t, 16, len(output.Vehicles))
+	for _, v := range output.Vehicles {
+		index, _ := strconv.Atoi(v.ID)
+		if index < 10 {
+			assert.True(t, v.DepartureTick < 60)
+		} else {
+			assert.True(t, v.DepartureTick >= 60 && v.DepartureTick < 120)
+		}
+		assert.True(t, v.ArrivalTick > v.DepartureTick)
+	}
+}
+
+func TestRun_Departures(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	cfg := Config{
+		GraphFile:       "../assets/out.json",
+		Vehicles:        8,
+		DT:              1,
+		MinSpeed:        5.5,
+		MaxSpeed:        8.5,
+		Departures:      "uniform",
+		DepartureWindow: 100,
+		Output:          filepath.Join(t.TempDir(), "results.json"),
+	}
+	err := comm.RunLocal(3, func(c comm.Communicator) error {
+		_, err := Run(c, cfg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output Output
+	if err := json.Unmarshal(bytes, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	// the vehicles depart spread over the window
+	assert.Equal(t, 3*cfg.Vehicles, len(output.Vehicles))
+	later := 0
+	for _, v := range output.Vehicles {
+		assert.True(t, v.DepartureTick >= 0 && v.DepartureTick < 100)
+		assert.True(t, v.ArrivalTick > v.DepartureTick)
+		if v.DepartureTick >= 50 {
+			later++
+		}
+	}
+	assert.True(t, later > 0)
+}
+
+func TestRun_Rerouting(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	cfg := Config{
+		GraphFile:       "../assets/out.json",
+		Vehicles:        20,
+		DT:              1,
+		MinSpeed:        5.5,
+		MaxSpeed:        8.5,
+		Rerouting:       1,
+		RerouteInterval: 5,
+		Output:          filepath.Join(t.TempDir(), "results.json"),
+	}
+	err := comm.RunLocal(3, func(c comm.Communicator) error {
+		_, err := Run(c, cfg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output Output
+	if err := json.Unmarshal(bytes, &output); err != nil {
+		t.Fatal(err)
+	}
+
+	// rerouted vehicles still reach their destination
+	assert.Equal(t, 3*cfg.Vehicles, len(output.Vehicles))
+	for _, v := range output.Vehicles {
+		assert.True(t, v.ArrivalTick > v.DepartureTick)
+		assert.True(t, v.Distance > 0)
+	}
+}
+
+// ringGraph writes a graph file of a one-way ring 1 -> 2 -> 3 -> 4 -> 1 of
+// edges storing a single vehicle each, which gridlocks as soon as every edge
+// is full of vehicles driving on
+func ringGraph(t *testing.T) string {
+	t.Helper()
+	ring := streets.GraphJSON{Graph: streets.JGraph{
+		Vertices: []streets.JVertex{{ID: 1, X: 10, Y: 50}, {ID: 2, X: 10.001, Y: 50}, {ID: 3, X: 10.001, Y: 50.001}, {ID: 4, X: 10, Y: 50.001}},
+		Edges: []streets.JEdge{
+			{From: 1, To: 2, Length: 7.5, MaxSpeed: "50", Lanes: 1, ID: "1"},
+			{From: 2, To: 3, Length: 7.5, MaxSpeed: "50", Lanes: 1, ID: "2"},
+			{From: 3, To: 4, Length: 7.5, MaxSpeed: "50", Lanes: 1, ID: "3"},
+			{From: 4, To: 1, Length: 7.5, MaxSpeed: "50", Lanes: 1, ID: "4"},
+		},
+	}}
+	bytes, err := ring.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ring.json")
+	if err := os.WriteFile(path, bytes, 0o664); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun_Gridlock(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+
+	for _, tc := range []struct {
+		name          string
+		maxTicks      int
+		gridlockTicks int
+	}{
+		{name: "unblocked", gridlockTicks: 20},
+		{name: "stopped", maxTicks: 200},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{
+				GraphFile:     ringGraph(t),
+				Vehicles:      10,
+				DT:            1,
+				MinSpeed:      5.5,
+				MaxSpeed:      8.5,
+				MaxTicks:      tc.maxTicks,
+				GridlockTicks: tc.gridlockTicks,
+				Seed:          3,
+			}
+			// a single worker, so the capacity of every edge holds
+			var result Result
+			err := comm.RunLocal(2, func(c comm.Communicator) error {
+				r, err := Run(c, cfg)
+				if c.Rank() == 0 {
+					result = r
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the run ends and reports the gridlock
+			assert.True(t, len(result.Gridlocks) > 0)
+			assert.Equal(t, []int{1, 2, 3, 4}, result.Gridlocks[0].Vertices)
+			assert.Equal(t, len(result.Output.Vehicles), result.Parked)
+			assert.Equal(t, result.Removed, result.Output.Removed)
+			if tc.maxTicks > 0 {
+				assert.Equal(t, tc.maxTicks, result.Steps)
+				assert.Equal(t, 0, result.Removed)
+				assert.True(t, result.Parked < 2*cfg.Vehicles)
+			} else {
+				assert.True(t, result.Removed > 0)
+				assert.Equal(t, 2*cfg.Vehicles, result.Parked+result.Removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package sim
+
+import (
+	"pchpc/comm"
+	"pchpc/streets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runWorker receives the graph of the worker's rect and the trips starting in
+// it from rank 0, plans their routes and drives them. A restored worker
+// continues with the vehicles of its checkpoint instead.
+func runWorker(c comm.Communicator, cfg Config) (Result, error) {
+	numTasks := c.Size()
+	myId := c.Rank()
+
+	// receive rects from task 0
+	rects, err := streets.UnmarshalRects(c.RecvBytes(0, rectanglesTag))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode rects.")
+		return Result{}, err
+	}
+
+	log.Debug().Msgf("MPI: Number of tasks: %d My rank: %d", numTasks, myId)
+	log.Debug().Msgf("MPI: Number of rects: %d", len(rects))
+
+	rawEdges, err := streets.UnmarshalRawEdges(c.RecvBytes(0, edgesTag))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode edges.")
+		return Result{}, err
+	}
+
+	signals, err := streets.UnmarshalSignals(c.RecvBytes(0, signalsTag))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decode signals.")
+		return Result{}, err
+	}
+
+	// init subgraph, worker i owns rect i-1
+	g, err := streets.GraphFromRect(rawEdges, rects, myId-1, cfg.Halo)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to build graph.")
+		return Result{}, err
+	}
+	size, err := g.Graph.Size()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get graph size.")
+		return Result{}, err
+	}
+	log.Info().Msgf("Process %d: Graph size: %d", myId, size)
+
+	model, err := streets.NewCarFollowingModel(cfg.Model)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create car-following model.")
+		return Result{}, err
+	}
+	engine := streets.NewEngine(cfg.DT)
+	engine.Model = model
+	if cfg.Rerouting > 0 {
+		engine.Rerouting = streets.NewRerouting(cfg.Rerouting)
+		if cfg.RerouteInterval > 0 {
+			engine.Rerouting.Interval = cfg.RerouteInterval
+		}
+	}
+	engine.SetSignals(signals)
+	if err := engine.SetJunctions(g.Graph); err != nil {
+		log.Error().Err(err).Msg("Failed to find junctions.")
+		return Result{}, err
+	}
+
+	state := checkpoint{Size: numTasks, Partition: partitionFingerprint(rects)}
+	if cfg.Restore != "" {
+		state, err = readCheckpoint(cfg, myId, numTasks, state.Partition, engine, g)
+		if err != nil {
+			return Result{}, err
+		}
+		if err := g.SetGhosts(state.Ghosts); err != nil {
+			return Result{}, err
+		}
+	} else {
+		// receive the trips starting in the rect and plan their routes on the complete graph
+		trips, err := unmarshalTrips(c.RecvBytes(0, tripsTag))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal trips.")
+			return Result{}, err
+		}
+		vehicles, err := planRoutes(trips, rawEdges, rects, cfg)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to plan routes.")
+			return Result{}, err
+		}
+		for i := range vehicles {
+			vehicles[i].SetGraph(&g.Graph)
+			engine.Add(&vehicles[i])
+		}
+	}
+	log.Info().Msgf("Process %d: Number of vehicles: %d", myId, engine.Len())
+
+	halo := streets.NewHalo(rawEdges, rects, myId-1, cfg.Halo)
+
+	result, err := drive(c, cfg, g, streets.VertexOwners(rects), halo, engine, state)
+	log.Info().Msgf("Process %d: Vehicles parked: %d", myId, result.Parked)
+	if engine.Rerouting != nil {
+		log.Info().Msgf("Process %d: Vehicles rerouted: %d", myId, engine.Reroutes)
+	}
+
+	return result, err
+}
+
+// drive advances the vehicles of the engine on the worker's graph tick by tick
+// and hands off every vehicle leaving it to the rank owning its next edge.
+// Ghost copies of the vehicles in the halo of other ranks are exchanged after
+// every tick. Rank 0 keeps all workers on the same tick. The steps continue
+// from state, which is written as checkpoint if one is due.
+func drive(
+	c comm.Communicator,
+	cfg Config,
+	g *streets.StreetGraph,
+	owners map[int]int,
+	halo *streets.Halo,
+	engine *streets.Engine,
+	state checkpoint,
+) (Result, error) {
+	result := Result{Steps: state.Steps, Parked: state.Parked}
+	summaries := state.Summaries
+	if summaries == nil {
+		summaries = make([]streets.VehicleSummary, 0)
+	}
+
+	// worker i owns rect i-1
+	owner := func(vertex int) int {
+		return owners[vertex] + 1
+	}
+	rank := func(rect int) int {
+		return rect + 1
+	}
+
+	removed := state.Removed
+	for {
+		result.Steps++
+		report := stepReport{Removed: removed}
+		parked := engine.Step()
+		for _, v := range parked {
+			summaries = append(summaries, v.Summary(engine.DT))
+		}
+		report.Parked = len(parked)
+		report.Handoffs = engine.Handoffs(owner)
+		report.Ghosts = halo.Ghosts(engine.Vehicles(), rank)
+		report.Active = engine.Len()
+		report.Blocked = engine.BlockedEdges()
+		result.Parked += report.Parked
+
+		c.SendBytes(report.marshal(), 0, reportTag)
+
+		order, err := unmarshalStepOrder(c.RecvBytes(0, orderTag))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal step order.")
+			return result, err
+		}
+
+		if order.Done {
+			return result, sendResults(c, summaries, engine.EdgeCounters())
+		}
+
+		if err := g.SetGhosts(order.Ghosts); err != nil {
+			return result, err
+		}
+
+		for i := range order.Vehicles {
+			order.Vehicles[i].SetGraph(&g.Graph)
+			engine.Add(&order.Vehicles[i])
+		}
+
+		removed = 0
+		for _, gl := range order.Unblock {
+			removed += len(engine.Unblock(gl))
+		}
+		if removed > 0 {
+			log.Warn().Msgf("Process %d: Removed %d vehicles stuck in gridlocks", c.Rank(), removed)
+		}
+
+		if cfg.checkpointDue(result.Steps) {
+			state.Steps, state.Parked, state.Removed = result.Steps, result.Parked, removed
+			state.Summaries, state.Ghosts = summaries, order.Ghosts
+			if err := writeCheckpoint(cfg, c.Rank(), state, engine, g); err != nil {
+				return result, err
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package streets
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/dominikbraun/graph"
+)
+
+// jamSpacing is the length of a lane in metres a vehicle takes up in a
+// standing queue
+const jamSpacing = VehicleLength + 2.5
+
+// entryMargin is the distance in metres to the end of its edge a vehicle
+// waits at if its next edge is full
+const entryMargin = 0.01
+
+// Capacity returns the number of vehicles a queue on an edge of the length
+// in metres and the number of lanes holds, at least one
+func Capacity(length float64, lanes int) int {
+	if lanes < 1 {
+		lanes = 1
+	}
+	return int(math.Max(1, math.Floor(length/jamSpacing)*float64(lanes)))
+}
+
+// Capacity returns the storage capacity of the edge
+func (d Data) Capacity() int {
+	return Capacity(d.Length, d.Lanes)
+}
+
+// isFull checks if the edge holds as many vehicles as it can store, so no
+// other vehicle may enter it
+func isFull(edge *graph.Edge[JVertex]) bool {
+	data, ok := edge.Properties.Data.(Data)
+	return ok && data.Map.Len() >= data.Capacity()
+}
+
+// nextEdgeFull checks if the edge after the vehicle's current one is full.
+// Ghost edges count with the vehicles of their last update.
+func (v *Vehicle) nextEdgeFull() bool {
+	idx, _ := v.deductCurrentPathVertexIndex()
+	if !v.seesEdgeByIndex(idx + 1) {
+		return false
+	}
+	edge, err := v.getEdgeByIndex(idx + 1)
+	return err == nil && isFull(edge)
+}
+
+// entryLimit returns the distance up to moved metres the vehicle may drive
+// without entering a full edge
+func (v *Vehicle) entryLimit(moved float64) float64 {
+	idx, position := v.deductCurrentPathVertexIndex()
+	if idx >= len(v.PathLengths) {
+		return moved
+	}
+
+	// distance from the vehicle to the start of edge i
+	start := v.PathLengths[idx] - position
+	for i := idx + 1; i < len(v.PathLengths) && start <= moved; i++ {
+		if v.seesEdgeByIndex(i) {
+			edge, err := v.getEdgeByIndex(i)
+			if err == nil && isFull(edge) {
+				return math.Max(0, start-entryMargin)
+			}
+		}
+		start += v.PathLengths[i]
+	}
+	return moved
+}
+
+// blockLine returns the distance from the vehicle to the end of its current
+// edge if the next edge is full, or +Inf
+func blockLine(v *Vehicle) float64 {
+	if v.IsParked || !v.nextEdgeFull() {
+		return math.Inf(1)
+	}
+	idx, position := v.deductCurrentPathVertexIndex()
+	return v.PathLengths[idx] - position
+}
+
+// BlockedEdge is an edge whose first vehicle waits because the next edge on
+// its path is full
+type BlockedEdge struct {
+	Source, Target int
+	// Next is the target of the full edge after the blocked one
+	Next int
+}
+
+// BlockedEdges returns the edges of the engine whose first vehicle waits for
+// its full next edge, standing at the end or still rolling up to it, sorted by
+// source and target
+func (e *Engine) BlockedEdges() []BlockedEdge {
+	blocked := make([]BlockedEdge, 0)
+	for key, v := range e.firstVehicles() {
+		idx, _ := v.deductCurrentPathVertexIndex()
+		if idx+2 >= len(v.Path) || !v.nextEdgeFull() {
+			continue
+		}
+		blocked = append(blocked, BlockedEdge{Source: key.source, Target: key.target, Next: v.Path[idx+2]})
+	}
+	sort.Slice(blocked, func(i, j int) bool {
+		if blocked[i].Source != blocked[j].Source {
+			return blocked[i].Source < blocked[j].Source
+		}
+		return blocked[i].Target < blocked[j].Target
+	})
+	return blocked
+}
+
+// firstVehicles returns the vehicle furthest along every edge the engine's
+// vehicles drive on, by edge
+func (e *Engine) firstVehicles() map[edgeKey]*Vehicle {
+	type first struct {
+		vehicle  *Vehicle
+		position float64
+	}
+	firsts := make(map[edgeKey]first)
+	for _, v := range e.vehicles {
+		idx, position := v.deductCurrentPathVertexIndex()
+		if v.IsParked || !v.hasEdgeByIndex(idx) {
+			continue
+		}
+		key := edgeKey{source: v.Path[idx], target: v.Path[idx+1]}
+		f, ok := firsts[key]
+		if !ok || position > f.position || (position == f.position && v.ID > f.vehicle.ID) {
+			firsts[key] = first{vehicle: v, position: position}
+		}
+	}
+
+	vehicles := make(map[edgeKey]*Vehicle, len(firsts))
+	for key, f := range firsts {
+		vehicles[key] = f.vehicle
+	}
+	return vehicles
+}
+
+// Gridlock is a cycle of blocked edges, every one of them full and waiting
+// for the next
+type Gridlock struct {
+	// Tick is the tick the gridlock was detected in
+	Tick int `json:"tick"`
+	// Vertices are the vertices along the cycle, starting at the smallest
+	Vertices []int `json:"vertices"`
+}
+
+// Gridlocks returns the cycles of the blocked edges, which may come from the
+// engines of several ranks, sorted by their first vertex
+func Gridlocks(blocked []BlockedEdge, tick int) []Gridlock {
+	next := make(map[edgeKey]edgeKey, len(blocked))
+	keys := make([]edgeKey, 0, len(blocked))
+	for _, b := range blocked {
+		key := edgeKey{source: b.Source, target: b.Target}
+		next[key] = edgeKey{source: b.Target, target: b.Next}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].source != keys[j].source {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].target < keys[j].target
+	})
+
+	// every blocked edge waits for at most one other, so following the
+	// edges from any of them either ends or runs into a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[edgeKey]int, len(keys))
+	gridlocks := make([]Gridlock, 0)
+	for _, start := range keys {
+		walk := make([]edgeKey, 0)
+		key := start
+		for state[key] == unvisited {
+			if _, ok := next[key]; !ok {
+				break
+			}
+			state[key] = visiting
+			walk = append(walk, key)
+			key = next[key]
+		}
+
+		if state[key] == visiting {
+			// the walk ran into itself, the cycle starts at key
+			g := Gridlock{Tick: tick}
+			for i := len(walk) - 1; i >= 0; i-- {
+				g.Vertices = append([]int{walk[i].source}, g.Vertices...)
+				if walk[i] == key {
+					break
+				}
+			}
+			gridlocks = append(gridlocks, g.rotated())
+		}
+		for _, k := range walk {
+			state[k] = visited
+		}
+	}
+
+	sort.Slice(gridlocks, func(i, j int) bool { return gridlocks[i].Vertices[0] < gridlocks[j].Vertices[0] })
+	return gridlocks
+}
+
+// rotated returns the gridlock with its cycle starting at the smallest vertex
+func (g Gridlock) rotated() Gridlock {
+	smallest := 0
+	for i, v := range g.Vertices {
+		if v < g.Vertices[smallest] {
+			smallest = i
+		}
+	}
+	vertices := make([]int, 0, len(g.Vertices))
+	vertices = append(vertices, g.Vertices[smallest:]...)
+	g.Vertices = append(vertices, g.Vertices[:smallest]...)
+	return g
+}
+
+// Unblock takes the first vehicle of every edge of the gridlock off the graph
+// and out of the engine, so the vehicles behind them can move on, and returns
+// the removed vehicles. Edges of the gridlock the engine does not drive on are
+// left to the engines of other ranks.
+func (e *Engine) Unblock(g Gridlock) []*Vehicle {
+	firsts := e.firstVehicles()
+	stuck := make(map[*Vehicle]bool)
+	for i, source := range g.Vertices {
+		target := g.Vertices[(i+1)%len(g.Vertices)]
+		if v, ok := firsts[edgeKey{source: source, target: target}]; ok {
+			stuck[v] = true
+		}
+	}
+
+	removed := make([]*Vehicle, 0, len(stuck))
+	active := e.vehicles[:0]
+	for _, v := range e.vehicles {
+		if stuck[v] {
+			v.Detach()
+			removed = append(removed, v)
+			continue
+		}
+		active = append(active, v)
+	}
+	e.vehicles = active
+	return removed
+}
+
+// GridlockTracker follows the gridlocks of a run from tick to tick, so every
+// gridlock is reported once when it forms, and expires after lasting Timeout
+// ticks
+type GridlockTracker struct {
+	// Timeout is the number of ticks a gridlock lasts before it expires, 0
+	// for never
+	Timeout int
+
+	// current are the gridlocks of the last update by their vertices, each
+	// with the tick it formed in
+	current map[string]Gridlock
+}
+
+// NewGridlockTracker creates a tracker whose gridlocks expire after timeout ticks
+func NewGridlockTracker(timeout int) *GridlockTracker {
+	return &GridlockTracker{Timeout: timeout, current: make(map[string]Gridlock)}
+}
+
+// Update finds the gridlocks of the blocked edges in tick and returns those
+// which formed in it and those which have lasted Timeout ticks or longer. An
+// expired gridlock keeps expiring until it is resolved.
+func (t *GridlockTracker) Update(blocked []BlockedEdge, tick int) (formed, expired []Gridlock) {
+	current := make(map[string]Gridlock)
+	for _, g := range Gridlocks(blocked, tick) {
+		key := fmt.Sprint(g.Vertices)
+		if known, ok := t.current[key]; ok {
+			g = known
+		} else {
+			formed = append(formed, g)
+		}
+		current[key] = g
+		if t.Timeout > 0 && tick-g.Tick >= t.Timeout {
+			expired = append(expired, g)
+		}
+	}
+	t.current = current
+	return formed, expired
+}
+
+// Current returns the gridlocks of the last update, sorted by their vertices
+func (t *GridlockTracker) Current() []Gridlock {
+	keys := make([]string, 0, len(t.current))
+	for key := range t.current {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	gridlocks := make([]Gridlock, 0, len(keys))
+	for _, key := range keys {
+		gridlocks = append(gridlocks, t.current[key])
+	}
+	return gridlocks
+}
+
+// Restore replaces the gridlocks of the tracker by those returned by Current,
+// so a resumed run neither reports them again nor restarts their timeout
+func (t *GridlockTracker) Restore(gridlocks []Gridlock) {
+	t.current = make(map[string]Gridlock, len(gridlocks))
+	for _, g := range gridlocks {
+		t.current[fmt.Sprint(g.Vertices)] = g
+	}
+}
@@ -0,0 +1,140 @@
+package streets
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// buildRoad builds a graph of the edges between the vertices, every vertex at
+// the given coordinates and every edge of the given length
+func buildRoad(t *testing.T, coords [][2]float64, edges [][2]int, length float64) *StreetGraph {
+	t.Helper()
+
+	vertices := make([]JVertex, len(coords))
+	for i, c := range coords {
+		vertices[i] = JVertex{ID: i + 1, X: c[0], Y: c[1]}
+	}
+	jEdges := make([]JEdge, len(edges))
+	for i, e := range edges {
+		jEdges[i] = JEdge{From: e[0], To: e[1], Length: length, MaxSpeed: "50", ID: strconv.Itoa(i)}
+	}
+
+	g, err := NewGraphBuilder().WithVertices(vertices).WithEdges(jEdges).
+		WithRectangleParts(1).SetTopRightBottomLeftVertices().DivideGraphsIntoRects().
+		PickRect(0).FilterForRect().IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestCapacity(t *testing.T) {
+	assert.Equal(t, 1, Capacity(0, 1))
+	assert.Equal(t, 1, Capacity(5, 0))
+	assert.Equal(t, 13, Capacity(100, 1))
+	assert.Equal(t, 26, Capacity(100, 2))
+}
+
+func TestEngine_RefusesFullEdge(t *testing.T) {
+	setupLogger(t)
+	// a 7.5 m edge 2 -> 3 stores a single vehicle
+	g := buildRoad(t, [][2]float64{{10, 50}, {10.001, 50}, {10.002, 50}}, [][2]int{{1, 2}, {2, 3}}, 7.5)
+
+	engine := NewEngine(0.5)
+	engine.Model = constantSpeed{}
+	standing := NewVehicle("standing", 0, []int{2, 3}, &g.Graph)
+	arriving := NewVehicle("arriving", 5, []int{1, 2, 3}, &g.Graph)
+	engine.Add(&standing)
+	engine.Add(&arriving)
+
+	for i := 0; i < 20; i++ {
+		engine.Step()
+	}
+
+	// the arriving vehicle waits at the end of its edge
+	idx, position := arriving.deductCurrentPathVertexIndex()
+	assert.Equal(t, 0, idx)
+	assert.True(t, position > 7)
+	assert.Equal(t, 0.0, arriving.Speed)
+	assert.Equal(t, []BlockedEdge{{Source: 1, Target: 2, Next: 3}}, engine.BlockedEdges())
+	assert.Equal(t, 0, len(Gridlocks(engine.BlockedEdges(), engine.Tick)))
+}
+
+func TestEngine_Gridlock(t *testing.T) {
+	setupLogger(t)
+	// a ring 1 -> 2 -> 3 -> 4 -> 1 of edges storing a single vehicle each
+	g := buildRoad(t, [][2]float64{{10, 50}, {10.001, 50}, {10.001, 50.001}, {10, 50.001}},
+		[][2]int{{1, 2}, {2, 3}, {3, 4}, {4, 1}}, 7.5)
+
+	engine := NewEngine(0.5)
+	engine.Model = constantSpeed{}
+	vehicles := make([]Vehicle, 4)
+	for i := range vehicles {
+		path := []int{i + 1, (i+1)%4 + 1, (i+2)%4 + 1}
+		vehicles[i] = NewVehicle(strconv.Itoa(i), 5, path, &g.Graph)
+		engine.Add(&vehicles[i])
+	}
+
+	for i := 0; i < 10; i++ {
+		engine.Step()
+	}
+
+	gridlocks := Gridlocks(engine.BlockedEdges(), engine.Tick)
+	assert.Equal(t, 1, len(gridlocks))
+	assert.Equal(t, []int{1, 2, 3, 4}, gridlocks[0].Vertices)
+	assert.Equal(t, 4, engine.Len())
+}
+
+func TestEngine_Unblock(t *testing.T) {
+	setupLogger(t)
+	g := buildRoad(t, [][2]float64{{10, 50}, {10.001, 50}, {10.001, 50.001}, {10, 50.001}},
+		[][2]int{{1, 2}, {2, 3}, {3, 4}, {4, 1}}, 7.5)
+
+	engine := NewEngine(0.5)
+	engine.Model = constantSpeed{}
+	vehicles := make([]Vehicle, 4)
+	for i := range vehicles {
+		path := []int{i + 1, (i+1)%4 + 1, (i+2)%4 + 1}
+		vehicles[i] = NewVehicle(strconv.Itoa(i), 5, path, &g.Graph)
+		engine.Add(&vehicles[i])
+	}
+
+	tracker := NewGridlockTracker(5)
+	reported, removed := 0, 0
+	for engine.Len() > 0 && engine.Tick < 100 {
+		engine.Step()
+		formed, expired := tracker.Update(engine.BlockedEdges(), engine.Tick)
+		reported += len(formed)
+		for _, gl := range expired {
+			assert.True(t, engine.Tick-gl.Tick >= 5)
+			removed += len(engine.Unblock(gl))
+		}
+	}
+
+	// the gridlock is reported once and its vehicles taken off the ring
+	assert.Equal(t, 1, reported)
+	assert.Equal(t, 4, removed)
+	assert.Equal(t, 0, engine.Len())
+	edges, _ := g.Graph.Edges()
+	for _, e := range edges {
+		assert.Equal(t, 0, e.Properties.Data.(Data).Map.Len())
+	}
+}
+
+func TestGridlocks(t *testing.T) {
+	blocked := []BlockedEdge{
+		// a tail leading into the cycle 5 -> 2 -> 3 -> 5
+		{Source: 1, Target: 2, Next: 3},
+		{Source: 3, Target: 5, Next: 2},
+		{Source: 5, Target: 2, Next: 3},
+		{Source: 2, Target: 3, Next: 5},
+		// a chain without a cycle
+		{Source: 7, Target: 8, Next: 9},
+	}
+	gridlocks := Gridlocks(blocked, 42)
+	assert.Equal(t, 1, len(gridlocks))
+	assert.Equal(t, 42, gridlocks[0].Tick)
+	assert.Equal(t, []int{2, 3, 5}, gridlocks[0].Vertices)
+}
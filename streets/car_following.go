@@ -0,0 +1,120 @@
+package streets
+
+import (
+	"fmt"
+	"math"
+
+	"pchpc/utils"
+)
+
+// VehicleLength is the length of a vehicle in metres
+const VehicleLength = 5.0
+
+// CarFollowingModel computes the speed of a vehicle from the gap to its leader
+type CarFollowingModel interface {
+	// NextSpeed returns the speed in m/s after dt seconds for a vehicle driving
+	// at speed, wishing to drive at desired. gap is the free distance to the
+	// leader driving at leaderSpeed, or +Inf without a leader. rng is the
+	// random stream of the vehicle.
+	NextSpeed(speed, desired, gap, leaderSpeed, dt float64, rng *utils.RNG) float64
+}
+
+// NewCarFollowingModel returns the car-following model with the given name,
+// "idm" or "krauss"
+func NewCarFollowingModel(name string) (CarFollowingModel, error) {
+	switch name {
+	case "", "idm":
+		return NewIDM(), nil
+	case "krauss":
+		return NewKrauss(), nil
+	default:
+		return nil, fmt.Errorf("unknown car-following model %q", name)
+	}
+}
+
+// IDM is the Intelligent Driver Model by Treiber, Hennecke and Helbing
+type IDM struct {
+	// MaxAcceleration is the maximum acceleration in m/s²
+	MaxAcceleration float64
+	// ComfortableDeceleration is the comfortable deceleration in m/s²
+	ComfortableDeceleration float64
+	// TimeHeadway is the safe time headway in seconds
+	TimeHeadway float64
+	// MinGap is the minimum gap to the leader in metres
+	MinGap float64
+	// Delta is the acceleration exponent
+	Delta float64
+}
+
+// NewIDM returns an IDM with typical parameters for urban traffic
+func NewIDM() *IDM {
+	return &IDM{
+		MaxAcceleration:         1.0,
+		ComfortableDeceleration: 1.5,
+		TimeHeadway:             1.5,
+		MinGap:                  2.0,
+		Delta:                   4.0,
+	}
+}
+
+// NextSpeed returns the speed after accelerating dt seconds with the IDM acceleration
+func (m *IDM) NextSpeed(speed, desired, gap, leaderSpeed, dt float64, _ *utils.RNG) float64 {
+	return math.Max(0, speed+m.acceleration(speed, desired, gap, leaderSpeed)*dt)
+}
+
+// acceleration returns the IDM acceleration in m/s²
+func (m *IDM) acceleration(speed, desired, gap, leaderSpeed float64) float64 {
+	free := 1.0
+	if desired > 0 {
+		free -= math.Pow(speed/desired, m.Delta)
+	}
+
+	interaction := 0.0
+	if !math.IsInf(gap, 1) {
+		approach := speed * (speed - leaderSpeed) / (2 * math.Sqrt(m.MaxAcceleration*m.ComfortableDeceleration))
+		desiredGap := m.MinGap + math.Max(0, speed*m.TimeHeadway+approach)
+		interaction = math.Pow(desiredGap/math.Max(gap, 0.01), 2)
+	}
+
+	return m.MaxAcceleration * (free - interaction)
+}
+
+// Krauss is the car-following model by Krauss, as used by SUMO
+type Krauss struct {
+	// MaxAcceleration is the maximum acceleration in m/s²
+	MaxAcceleration float64
+	// Deceleration is the maximum deceleration in m/s²
+	Deceleration float64
+	// ReactionTime is the reaction time of the driver in seconds
+	ReactionTime float64
+	// Sigma is the driver imperfection between 0 and 1
+	Sigma float64
+}
+
+// NewKrauss returns a Krauss model with SUMO's default parameters
+func NewKrauss() *Krauss {
+	return &Krauss{
+		MaxAcceleration: 2.6,
+		Deceleration:    4.5,
+		ReactionTime:    1.0,
+		Sigma:           0.5,
+	}
+}
+
+// NextSpeed returns the safe speed, reduced by a random dawdling of the driver
+// drawn from the vehicle's stream
+func (m *Krauss) NextSpeed(speed, desired, gap, leaderSpeed, dt float64, rng *utils.RNG) float64 {
+	next := math.Min(desired, speed+m.MaxAcceleration*dt)
+
+	if !math.IsInf(gap, 1) {
+		safe := leaderSpeed + (gap-leaderSpeed*m.ReactionTime)/
+			((speed+leaderSpeed)/(2*m.Deceleration)+m.ReactionTime)
+		next = math.Min(next, safe)
+	}
+
+	if m.Sigma > 0 {
+		next -= rng.Between(0, m.Sigma*m.MaxAcceleration*dt)
+	}
+
+	return math.Max(0, next)
+}
@@ -0,0 +1,95 @@
+package streets
+
+import (
+	"math"
+	"testing"
+
+	"pchpc/utils"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+func TestIDM_NextSpeed(t *testing.T) {
+	m := NewIDM()
+	desired := 50 / 3.6
+
+	// free road: accelerate towards, but not beyond the desired speed
+	speed := 0.0
+	for i := 0; i < 200; i++ {
+		next := m.NextSpeed(speed, desired, math.Inf(1), 0, 0.5, nil)
+		assert.True(t, next >= speed)
+		speed = next
+	}
+	assert.True(t, speed <= desired)
+	assert.True(t, speed > 0.9*desired)
+
+	// approaching a standing leader: brake, the closer the harder
+	far := m.NextSpeed(desired, desired, 50, 0, 1, nil)
+	near := m.NextSpeed(desired, desired, 10, 0, 1, nil)
+	assert.True(t, far < desired)
+	assert.True(t, near < far)
+
+	// standing at the minimum gap behind a standing leader: stay
+	assert.Equal(t, 0.0, m.NextSpeed(0, desired, m.MinGap, 0, 1, nil))
+}
+
+func TestIDM_Queue(t *testing.T) {
+	m := NewIDM()
+	desired := 50 / 3.6
+	dt := 0.5
+
+	// a platoon approaching a standing obstacle stops without a collision
+	positions := []float64{100, 80, 60, 40, 20}
+	speeds := []float64{desired, desired, desired, desired, desired}
+	obstacle := 200.0
+
+	for tick := 0; tick < 600; tick++ {
+		next := make([]float64, len(speeds))
+		for i := range speeds {
+			leaderPos, leaderSpeed := obstacle+VehicleLength, 0.0
+			if i > 0 {
+				leaderPos, leaderSpeed = positions[i-1], speeds[i-1]
+			}
+			next[i] = m.NextSpeed(speeds[i], desired, leaderPos-positions[i]-VehicleLength, leaderSpeed, dt, nil)
+		}
+		for i := range speeds {
+			speeds[i] = next[i]
+			positions[i] += speeds[i] * dt
+		}
+	}
+
+	for i := range positions {
+		assert.True(t, speeds[i] < 0.1)
+		if i > 0 {
+			assert.True(t, positions[i-1]-positions[i] > VehicleLength)
+		}
+	}
+	assert.True(t, positions[0] < obstacle)
+}
+
+func TestKrauss_NextSpeed(t *testing.T) {
+	m := NewKrauss()
+	m.Sigma = 0
+	rng := utils.NewRNG(1)
+	desired := 50 / 3.6
+
+	// free road: accelerate with the maximum acceleration up to the desired speed
+	assert.Equal(t, m.MaxAcceleration, m.NextSpeed(0, desired, math.Inf(1), 0, 1, &rng))
+	assert.Equal(t, desired, m.NextSpeed(desired, desired, math.Inf(1), 0, 1, &rng))
+
+	// behind a standing leader: slower than on a free road, zero without a gap
+	assert.True(t, m.NextSpeed(desired, desired, 20, 0, 1, &rng) < desired)
+	assert.Equal(t, 0.0, m.NextSpeed(desired, desired, 0, 0, 1, &rng))
+
+	// dawdling never makes a driver faster
+	m.Sigma = 1
+	for i := 0; i < 100; i++ {
+		assert.True(t, m.NextSpeed(desired, desired, math.Inf(1), 0, 1, &rng) <= desired)
+	}
+
+	// the dawdling only depends on the vehicle's stream
+	a, b := utils.NewRNG(7), utils.NewRNG(7)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, m.NextSpeed(desired, desired, math.Inf(1), 0, 1, &a), m.NextSpeed(desired, desired, math.Inf(1), 0, 1, &b))
+	}
+}
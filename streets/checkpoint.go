@@ -0,0 +1,139 @@
+package streets
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// Engine writes the state of the engine as a record: its tick, the lane
+// change and reroute counts, the edge counters, the active vehicles, the vehicles on every own edge of g and the
+// vehicles waiting for their departure.
+// Vehicles on ghost edges are not written, they are set by SetGhosts.
+func (w *Writer) Engine(e *Engine, g graph.Graph[int, JVertex]) error {
+	edges, err := g.Edges()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get edges.")
+		return err
+	}
+
+	type occupancy struct {
+		source, target int
+		ids            []string
+	}
+	occupied := make([]occupancy, 0)
+	for _, edge := range edges {
+		data, ok := edge.Properties.Data.(Data)
+		if !ok || data.Ghost || data.Map.Len() == 0 {
+			continue
+		}
+		o := occupancy{source: edge.Source, target: edge.Target}
+		for _, v := range data.Map.ToList() {
+			o.ids = append(o.ids, v.ID)
+		}
+		sort.Strings(o.ids)
+		occupied = append(occupied, o)
+	}
+	sort.Slice(occupied, func(i, j int) bool {
+		if occupied[i].source != occupied[j].source {
+			return occupied[i].source < occupied[j].source
+		}
+		return occupied[i].target < occupied[j].target
+	})
+
+	w.Record(func(w *Writer) {
+		w.Int(e.Tick)
+		w.Int(e.LaneChanges)
+		w.Int(e.Reroutes)
+
+		counters := e.EdgeCounters()
+		w.Uvarint(uint64(len(counters)))
+		for _, c := range counters {
+			w.Int(c.Source)
+			w.Int(c.Target)
+			w.Int(c.Entered)
+			w.Int(c.VehicleTicks)
+		}
+
+		w.Uvarint(uint64(len(e.vehicles)))
+		for _, v := range e.vehicles {
+			w.Vehicle(v)
+			w.Int(v.edgeIndex)
+		}
+
+		w.Uvarint(uint64(len(occupied)))
+		for _, o := range occupied {
+			w.Int(o.source)
+			w.Int(o.target)
+			w.Uvarint(uint64(len(o.ids)))
+			for _, id := range o.ids {
+				w.String(id)
+			}
+		}
+
+		w.Uvarint(uint64(len(e.pending)))
+		for _, v := range e.pending {
+			w.Vehicle(v)
+		}
+	})
+
+	return nil
+}
+
+// Engine reads the state written by Writer.Engine into the empty engine e and
+// puts its vehicles back on the edges of g they occupied
+func (r *Reader) Engine(e *Engine, g *graph.Graph[int, JVertex]) {
+	r.Record(func(r *Reader) {
+		e.Tick = r.Int()
+		e.LaneChanges = r.Int()
+		e.Reroutes = r.Int()
+
+		for i, n := 0, r.Len(); i < n; i++ {
+			c := &EdgeCounter{Source: r.Int(), Target: r.Int(), Entered: r.Int(), VehicleTicks: r.Int()}
+			e.counters[edgeKey{source: c.Source, target: c.Target}] = c
+		}
+
+		byID := make(map[string]*Vehicle)
+		for i, n := 0, r.Len(); i < n; i++ {
+			v := r.Vehicle()
+			v.edgeIndex = r.Int()
+			v.SetGraph(g)
+			e.vehicles = append(e.vehicles, &v)
+			byID[v.ID] = &v
+		}
+
+		for i, n := 0, r.Len(); i < n; i++ {
+			source, target := r.Int(), r.Int()
+			ids := make([]string, r.Len())
+			for j := range ids {
+				ids[j] = r.String()
+			}
+			if r.err != nil {
+				return
+			}
+
+			edge, err := (*g).Edge(source, target)
+			if err != nil {
+				r.err = fmt.Errorf("%w: unknown edge %d -> %d", ErrCodec, source, target)
+				return
+			}
+			data := edge.Properties.Data.(Data)
+			for _, id := range ids {
+				v, ok := byID[id]
+				if !ok {
+					log.Warn().Msgf("Vehicle %s on edge %d -> %d is not active, skipping it.", id, source, target)
+					continue
+				}
+				data.Map.Set(id, v)
+			}
+		}
+
+		for i, n := 0, r.Len(); i < n; i++ {
+			v := r.Vehicle()
+			v.SetGraph(g)
+			e.pending = append(e.pending, &v)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package streets
+
+import (
+	"strconv"
+	"testing"
+
+	"pchpc/utils"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+)
+
+func TestEngine_Checkpoint(t *testing.T) {
+	setupLogger(t)
+	g := setupStreetGraph(t)
+
+	path, err := graph.ShortestPath(g, 269910246, 213322463)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewEngine(1)
+	engine.Model = NewKrauss()
+	vehicles := make([]Vehicle, 0)
+	for i := 0; i < 4; i++ {
+		v := NewVehicle(strconv.Itoa(i), 4.0, path, &g)
+		v.DistanceTravelled = float64(10 * i)
+		v.SetRNG(utils.NewRNG(int64(i)))
+		vehicles = append(vehicles, v)
+	}
+	for i := range vehicles {
+		engine.Add(&vehicles[i])
+	}
+	for i := 0; i < 5; i++ {
+		engine.Step()
+	}
+	engine.LaneChanges, engine.Reroutes = 3, 2
+
+	w := NewWriter(KindVehicles)
+	if err := w.Engine(engine, g); err != nil {
+		t.Fatal(err)
+	}
+
+	// restore on a fresh copy of the graph
+	restoredGraph := setupStreetGraph(t)
+	restored := NewEngine(1)
+	restored.Model = NewKrauss()
+	r, err := NewReader(w.Bytes(), KindVehicles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Engine(restored, &restoredGraph)
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, engine.Tick, restored.Tick)
+	assert.Equal(t, engine.LaneChanges, restored.LaneChanges)
+	assert.Equal(t, engine.Reroutes, restored.Reroutes)
+	assert.Equal(t, engine.Len(), restored.Len())
+	assert.Equal(t, engine.EdgeCounters(), restored.EdgeCounters())
+
+	// the restored vehicles occupy the same edges
+	edge, err := restoredGraph.Edge(path[0], path[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := g.Edge(path[0], path[1])
+	assert.Equal(t, original.Properties.Data.(Data).Map.Len(), edge.Properties.Data.(Data).Map.Len())
+
+	// both engines continue the same, including the random dawdling
+	for engine.Len() > 0 {
+		engine.Step()
+		restored.Step()
+		for i, v := range engine.Vehicles() {
+			assert.Equal(t, v.DistanceTravelled, restored.Vehicles()[i].DistanceTravelled)
+			assert.Equal(t, v.Speed, restored.Vehicles()[i].Speed)
+		}
+	}
+	assert.Equal(t, engine.Tick, restored.Tick)
+	assert.Equal(t, 0, restored.Len())
+	assert.Equal(t, engine.EdgeCounters(), restored.EdgeCounters())
+}
@@ -0,0 +1,488 @@
+package streets
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"pchpc/utils"
+)
+
+// CodecVersion is the version of the binary wire format written by the
+// encoder. It changes with the layout of any message or record, so messages
+// of an older layout are rejected instead of misread.
+const CodecVersion = 7
+
+// Kinds of the messages of the binary wire format
+const (
+	KindVehicles byte = iota + 1
+	KindRects
+	KindRawEdges
+	KindSignals
+)
+
+// ErrCodec is returned for malformed binary messages
+var ErrCodec = errors.New("malformed binary message")
+
+// Writer appends values in the binary wire format to a buffer. Integers are
+// varints, floats are 8 bytes in little endian and strings and records are
+// prefixed by their length.
+type Writer struct {
+	buf []byte
+}
+
+// NewWriter returns a writer for a message of the given kind
+func NewWriter(kind byte) *Writer {
+	return &Writer{buf: []byte{CodecVersion, kind}}
+}
+
+// Bytes returns the message written so far
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// Uvarint writes an unsigned integer
+func (w *Writer) Uvarint(x uint64) {
+	w.buf = binary.AppendUvarint(w.buf, x)
+}
+
+// Varint writes a signed integer
+func (w *Writer) Varint(x int64) {
+	w.buf = binary.AppendVarint(w.buf, x)
+}
+
+// Int writes an int
+func (w *Writer) Int(x int) {
+	w.Varint(int64(x))
+}
+
+// Float64 writes a float
+func (w *Writer) Float64(x float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(x))
+}
+
+// Bool writes a bool as a single byte
+func (w *Writer) Bool(x bool) {
+	if x {
+		w.buf = append(w.buf, 1)
+		return
+	}
+	w.buf = append(w.buf, 0)
+}
+
+// String writes a length-prefixed string
+func (w *Writer) String(s string) {
+	w.Uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// Record writes the values written by fn, prefixed by their length, so a
+// reader never reads past the record. Records are not compatible across
+// versions: a change of their layout changes CodecVersion.
+func (w *Writer) Record(fn func(w *Writer)) {
+	inner := &Writer{}
+	fn(inner)
+	w.Uvarint(uint64(len(inner.buf)))
+	w.buf = append(w.buf, inner.buf...)
+}
+
+// IDs writes vertex IDs, each as the difference to its predecessor
+func (w *Writer) IDs(ids []int) {
+	w.Uvarint(uint64(len(ids)))
+	previous := 0
+	for _, id := range ids {
+		w.Int(id - previous)
+		previous = id
+	}
+}
+
+// Float64s writes a slice of floats
+func (w *Writer) Float64s(xs []float64) {
+	w.Uvarint(uint64(len(xs)))
+	for _, x := range xs {
+		w.Float64(x)
+	}
+}
+
+// Vehicle writes a vehicle as a record
+func (w *Writer) Vehicle(v *Vehicle) {
+	w.Record(func(w *Writer) {
+		w.String(v.ID)
+		w.IDs(v.Path)
+		w.Float64(v.DistanceTravelled)
+		w.Float64(v.Speed)
+		w.Bool(v.IsParked)
+		w.Float64s(v.PathLengths)
+		w.Float64(v.PathLimit)
+		w.Int(v.DepartureTick)
+		w.Int(v.ArrivalTick)
+		w.Uvarint(v.rng.State())
+		w.Int(v.Lane)
+	})
+}
+
+// Rect writes a rect as a record
+func (w *Writer) Rect(r *Rect) {
+	w.Record(func(w *Writer) {
+		w.Float64(r.TopRight.X)
+		w.Float64(r.TopRight.Y)
+		w.Float64(r.BotLeft.X)
+		w.Float64(r.BotLeft.Y)
+		w.Uvarint(uint64(len(r.Vertices)))
+		previous := 0
+		for _, v := range r.Vertices {
+			w.Int(v.ID - previous)
+			w.Float64(v.X)
+			w.Float64(v.Y)
+			previous = v.ID
+		}
+	})
+}
+
+// RawEdge writes a raw edge as a record
+func (w *Writer) RawEdge(e *RawEdge) {
+	w.Record(func(w *Writer) {
+		w.Int(e.Source)
+		w.Int(e.Target)
+		w.Float64(e.Length)
+		w.String(e.MaxSpeed)
+		w.String(e.Name)
+		w.String(e.ID)
+		w.Int(e.Lanes)
+	})
+}
+
+// SignalPlan writes a signal plan as a record
+func (w *Writer) SignalPlan(p *SignalPlan) {
+	w.Record(func(w *Writer) {
+		w.Int(p.Vertex)
+		w.Float64(p.Offset)
+		w.Uvarint(uint64(len(p.Phases)))
+		for _, phase := range p.Phases {
+			w.Uvarint(uint64(len(phase.From)))
+			for _, from := range phase.From {
+				w.Int(from)
+			}
+			w.Float64(phase.Green)
+			w.Float64(phase.Yellow)
+			w.Float64(phase.Red)
+		}
+	})
+}
+
+// Gridlock writes a gridlock as a record
+func (w *Writer) Gridlock(g *Gridlock) {
+	w.Record(func(w *Writer) {
+		w.Int(g.Tick)
+		w.IDs(g.Vertices)
+	})
+}
+
+// Reader reads values in the binary wire format. The first error is kept and
+// every following read returns zero values, so it is enough to check Err once.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+// NewReader checks the version and kind of the message and returns a reader for its values
+func NewReader(b []byte, kind byte) (*Reader, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("%w: missing header", ErrCodec)
+	}
+	if b[0] != CodecVersion {
+		return nil, fmt.Errorf("unsupported codec version %d, expected %d", b[0], CodecVersion)
+	}
+	if b[1] != kind {
+		return nil, fmt.Errorf("%w: message of kind %d, expected %d", ErrCodec, b[1], kind)
+	}
+	return &Reader{buf: b[2:]}, nil
+}
+
+// Err returns the first error while reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// fail keeps the first error
+func (r *Reader) fail(what string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: truncated %s", ErrCodec, what)
+	}
+	r.buf = nil
+}
+
+// Uvarint reads an unsigned integer
+func (r *Reader) Uvarint() uint64 {
+	x, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail("uvarint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return x
+}
+
+// Varint reads a signed integer
+func (r *Reader) Varint() int64 {
+	x, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.fail("varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return x
+}
+
+// Int reads an int
+func (r *Reader) Int() int {
+	return int(r.Varint())
+}
+
+// Len reads a length, which can not be larger than the remaining bytes
+func (r *Reader) Len() int {
+	n := r.Uvarint()
+	if n > uint64(len(r.buf)) {
+		r.fail("length")
+		return 0
+	}
+	return int(n)
+}
+
+// Float64 reads a float
+func (r *Reader) Float64() float64 {
+	if len(r.buf) < 8 {
+		r.fail("float")
+		return 0
+	}
+	x := math.Float64frombits(binary.LittleEndian.Uint64(r.buf))
+	r.buf = r.buf[8:]
+	return x
+}
+
+// Bool reads a bool
+func (r *Reader) Bool() bool {
+	if len(r.buf) < 1 {
+		r.fail("bool")
+		return false
+	}
+	x := r.buf[0] != 0
+	r.buf = r.buf[1:]
+	return x
+}
+
+// String reads a length-prefixed string
+func (r *Reader) String() string {
+	n := r.Len()
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// Record reads a length-prefixed record with fn, skipping its unread rest
+func (r *Reader) Record(fn func(r *Reader)) {
+	n := r.Len()
+	inner := &Reader{buf: r.buf[:n]}
+	r.buf = r.buf[n:]
+	fn(inner)
+	if inner.err != nil && r.err == nil {
+		r.err = inner.err
+	}
+}
+
+// IDs reads vertex IDs written by Writer.IDs
+func (r *Reader) IDs() []int {
+	n := r.Len()
+	ids := make([]int, n)
+	previous := 0
+	for i := range ids {
+		ids[i] = previous + r.Int()
+		previous = ids[i]
+	}
+	return ids
+}
+
+// Float64s reads a slice of floats
+func (r *Reader) Float64s() []float64 {
+	n := r.Len()
+	xs := make([]float64, n)
+	for i := range xs {
+		xs[i] = r.Float64()
+	}
+	return xs
+}
+
+// Vehicle reads a vehicle record. The vehicle has no graph yet, see SetGraph.
+func (r *Reader) Vehicle() Vehicle {
+	var v Vehicle
+	r.Record(func(r *Reader) {
+		v.ID = r.String()
+		v.Path = r.IDs()
+		v.DistanceTravelled = r.Float64()
+		v.Speed = r.Float64()
+		v.IsParked = r.Bool()
+		v.PathLengths = r.Float64s()
+		v.PathLimit = r.Float64()
+		v.DepartureTick = r.Int()
+		v.ArrivalTick = r.Int()
+		v.rng = utils.RestoreRNG(r.Uvarint())
+		v.Lane = r.Int()
+	})
+	return v
+}
+
+// Rect reads a rect record
+func (r *Reader) Rect() Rect {
+	var rect Rect
+	r.Record(func(r *Reader) {
+		rect.TopRight = point{X: r.Float64(), Y: r.Float64()}
+		rect.BotLeft = point{X: r.Float64(), Y: r.Float64()}
+		rect.Vertices = make([]JVertex, r.Len())
+		previous := 0
+		for i := range rect.Vertices {
+			id := previous + r.Int()
+			rect.Vertices[i] = JVertex{ID: id, X: r.Float64(), Y: r.Float64()}
+			previous = id
+		}
+	})
+	return rect
+}
+
+// RawEdge reads a raw edge record
+func (r *Reader) RawEdge() RawEdge {
+	var e RawEdge
+	r.Record(func(r *Reader) {
+		e.Source = r.Int()
+		e.Target = r.Int()
+		e.Length = r.Float64()
+		e.MaxSpeed = r.String()
+		e.Name = r.String()
+		e.ID = r.String()
+		e.Lanes = r.Int()
+	})
+	return e
+}
+
+// SignalPlan reads a signal plan record
+func (r *Reader) SignalPlan() SignalPlan {
+	var p SignalPlan
+	r.Record(func(r *Reader) {
+		p.Vertex = r.Int()
+		p.Offset = r.Float64()
+		p.Phases = make([]SignalPhase, r.Len())
+		for i := range p.Phases {
+			phase := &p.Phases[i]
+			phase.From = make([]int, r.Len())
+			for j := range phase.From {
+				phase.From[j] = r.Int()
+			}
+			phase.Green = r.Float64()
+			phase.Yellow = r.Float64()
+			phase.Red = r.Float64()
+		}
+	})
+	return p
+}
+
+// Gridlock reads a gridlock record
+func (r *Reader) Gridlock() Gridlock {
+	var g Gridlock
+	r.Record(func(r *Reader) {
+		g.Tick = r.Int()
+		g.Vertices = r.IDs()
+	})
+	return g
+}
+
+// MarshalVehicles encodes the vehicles in the binary wire format
+func MarshalVehicles(vehicles []Vehicle) []byte {
+	w := NewWriter(KindVehicles)
+	w.Uvarint(uint64(len(vehicles)))
+	for i := range vehicles {
+		w.Vehicle(&vehicles[i])
+	}
+	return w.Bytes()
+}
+
+// UnmarshalVehicles decodes vehicles encoded by MarshalVehicles
+func UnmarshalVehicles(b []byte) ([]Vehicle, error) {
+	r, err := NewReader(b, KindVehicles)
+	if err != nil {
+		return nil, err
+	}
+	vehicles := make([]Vehicle, r.Len())
+	for i := range vehicles {
+		vehicles[i] = r.Vehicle()
+	}
+	return vehicles, r.Err()
+}
+
+// MarshalRects encodes the rects in the binary wire format
+func MarshalRects(rects []Rect) []byte {
+	w := NewWriter(KindRects)
+	w.Uvarint(uint64(len(rects)))
+	for i := range rects {
+		w.Rect(&rects[i])
+	}
+	return w.Bytes()
+}
+
+// UnmarshalRects decodes rects encoded by MarshalRects
+func UnmarshalRects(b []byte) ([]Rect, error) {
+	r, err := NewReader(b, KindRects)
+	if err != nil {
+		return nil, err
+	}
+	rects := make([]Rect, r.Len())
+	for i := range rects {
+		rects[i] = r.Rect()
+	}
+	return rects, r.Err()
+}
+
+// MarshalRawEdges encodes the raw edges in the binary wire format
+func MarshalRawEdges(edges []RawEdge) []byte {
+	w := NewWriter(KindRawEdges)
+	w.Uvarint(uint64(len(edges)))
+	for i := range edges {
+		w.RawEdge(&edges[i])
+	}
+	return w.Bytes()
+}
+
+// UnmarshalRawEdges decodes raw edges encoded by MarshalRawEdges
+func UnmarshalRawEdges(b []byte) ([]RawEdge, error) {
+	r, err := NewReader(b, KindRawEdges)
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]RawEdge, r.Len())
+	for i := range edges {
+		edges[i] = r.RawEdge()
+	}
+	return edges, r.Err()
+}
+
+// MarshalSignals encodes the signal plans in the binary wire format
+func MarshalSignals(plans []SignalPlan) []byte {
+	w := NewWriter(KindSignals)
+	w.Uvarint(uint64(len(plans)))
+	for i := range plans {
+		w.SignalPlan(&plans[i])
+	}
+	return w.Bytes()
+}
+
+// UnmarshalSignals decodes signal plans encoded by MarshalSignals
+func UnmarshalSignals(b []byte) ([]SignalPlan, error) {
+	r, err := NewReader(b, KindSignals)
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]SignalPlan, r.Len())
+	for i := range plans {
+		plans[i] = r.SignalPlan()
+	}
+	return plans, r.Err()
+}
@@ -0,0 +1,183 @@
+package streets
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+)
+
+func TestCodec_Vehicles(t *testing.T) {
+	setupLogger(t)
+	g := setupStreetGraph(t)
+
+	path, err := graph.ShortestPath(g, 269910246, 4319682656)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vehicles := []Vehicle{NewVehicle("1", 6.5, path, &g), NewVehicle("2", 0, []int{2617388513, 2290171245}, &g)}
+	vehicles[0].DistanceTravelled = 123.25
+	vehicles[0].DepartureTick = 3
+	vehicles[1].IsParked = true
+	vehicles[1].ArrivalTick = 42
+
+	b := MarshalVehicles(vehicles)
+	decoded, err := UnmarshalVehicles(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(vehicles), len(decoded))
+	for i, v := range vehicles {
+		d := decoded[i]
+		assert.Equal(t, v.ID, d.ID)
+		assert.Equal(t, v.Path, d.Path)
+		assert.Equal(t, v.DistanceTravelled, d.DistanceTravelled)
+		assert.Equal(t, v.Speed, d.Speed)
+		assert.Equal(t, v.IsParked, d.IsParked)
+		assert.Equal(t, v.PathLengths, d.PathLengths)
+		assert.Equal(t, v.PathLimit, d.PathLimit)
+		assert.Equal(t, v.DepartureTick, d.DepartureTick)
+		assert.Equal(t, v.ArrivalTick, d.ArrivalTick)
+	}
+
+	jBytes, err := json.Marshal(vehicles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("binary: %d bytes, JSON: %d bytes", len(b), len(jBytes))
+	assert.True(t, len(b) < len(jBytes))
+}
+
+func TestCodec_RectsAndEdges(t *testing.T) {
+	root, _ := DefaultGraph(graphFile, 1)
+
+	rects, err := root.Partition(&BisectionPartitioner{}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawEdges, err := root.RawEdges()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedRects, err := UnmarshalRects(MarshalRects(rects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rects, decodedRects)
+
+	decodedEdges, err := UnmarshalRawEdges(MarshalRawEdges(rawEdges))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rawEdges, decodedEdges)
+}
+
+func TestCodec_Errors(t *testing.T) {
+	b := MarshalRawEdges([]RawEdge{{Source: 1, Target: 2, Length: 3.5, MaxSpeed: "50", Name: "Weender Straße", ID: "7"}})
+
+	// a message of another kind
+	_, err := UnmarshalVehicles(b)
+	assert.True(t, errors.Is(err, ErrCodec))
+
+	// a message of another version
+	other := append([]byte{}, b...)
+	other[0] = CodecVersion + 1
+	_, err = UnmarshalRawEdges(other)
+	assert.True(t, err != nil)
+
+	// every truncated message fails
+	for n := 0; n < len(b); n++ {
+		_, err := UnmarshalRawEdges(b[:n])
+		assert.True(t, err != nil)
+	}
+}
+
+func TestCodec_RejectsPreviousLayout(t *testing.T) {
+	// a vehicle in the layout before lanes
+	w := &Writer{buf: []byte{CodecVersion - 1, KindVehicles}}
+	w.Uvarint(1)
+	w.Record(func(w *Writer) {
+		w.String("1")
+		w.IDs([]int{1, 2})
+		w.Float64(12.5)
+		w.Float64(6.5)
+		w.Bool(false)
+		w.Float64s([]float64{100})
+		w.Float64(100)
+		w.Int(0)
+		w.Int(0)
+		w.Uvarint(7)
+	})
+	old := w.Bytes()
+
+	_, err := UnmarshalVehicles(old)
+	assert.True(t, err != nil)
+	assert.False(t, errors.Is(err, ErrCodec))
+	assert.True(t, strings.Contains(err.Error(), "unsupported codec version"))
+
+	// read as the current layout the record is malformed
+	current := append([]byte{}, old...)
+	current[0] = CodecVersion
+	_, err = UnmarshalVehicles(current)
+	assert.True(t, errors.Is(err, ErrCodec))
+}
+
+func TestCodec_SkipsUnknownFields(t *testing.T) {
+	// the fields of a record a reader does not read are skipped
+	w := NewWriter(KindRawEdges)
+	w.Uvarint(1)
+	w.Record(func(w *Writer) {
+		w.Int(1)
+		w.Int(2)
+		w.Float64(3.5)
+		w.String("50")
+		w.String("Weender Straße")
+		w.String("7")
+		w.Float64(1.0)
+	})
+
+	edges, err := UnmarshalRawEdges(w.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []RawEdge{{Source: 1, Target: 2, Length: 3.5, MaxSpeed: "50", Name: "Weender Straße", ID: "7"}}, edges)
+}
+
+func TestCodec_Signals(t *testing.T) {
+	plans := []SignalPlan{
+		{Vertex: 7, Offset: 4.5, Phases: []SignalPhase{
+			{From: []int{3, 1}, Green: 30, Yellow: 3, Red: 2},
+			{From: []int{2}, Green: 20, Yellow: 3},
+		}},
+		{Vertex: 9, Phases: []SignalPhase{}},
+	}
+
+	decoded, err := UnmarshalSignals(MarshalSignals(plans))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, plans, decoded)
+}
+
+func TestCodec_Gridlocks(t *testing.T) {
+	gridlocks := []Gridlock{{Tick: 7, Vertices: []int{2, 3, 5}}, {Tick: 0, Vertices: []int{}}}
+
+	w := NewWriter(KindVehicles)
+	for i := range gridlocks {
+		w.Gridlock(&gridlocks[i])
+	}
+	r, err := NewReader(w.Bytes(), KindVehicles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range gridlocks {
+		assert.Equal(t, g, r.Gridlock())
+	}
+	assert.True(t, r.Err() == nil)
+}
@@ -0,0 +1,281 @@
+package streets
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// Engine advances all active vehicles on a graph together, in ticks of a fixed
+// length. In every tick the vehicles due first reroute and change lanes one
+// after the other, then all of them decide their speed on the same snapshot
+// of the edges and finally all of them commit their move.
+type Engine struct {
+	// DT is the length of a tick in seconds
+	DT float64
+	// Tick is the number of ticks simulated so far
+	Tick int
+	// Parallel decides the speeds of the vehicles in goroutines
+	Parallel bool
+	// Model is the car-following model of all vehicles
+	Model CarFollowingModel
+	// Signals are the traffic signals by vertex, vehicles stop at red
+	Signals map[int]*SignalPlan
+	// Junctions are the unsignalized junctions by vertex, vehicles give way
+	// by their priority rules
+	Junctions map[int]*Junction
+	// LaneChanging is the lane-changing model, nil keeps every vehicle in
+	// its lane
+	LaneChanging *MOBIL
+	// LaneChanges is the number of lane changes so far
+	LaneChanges int
+	// Rerouting lets vehicles switch to faster routes around congestion, nil
+	// keeps every vehicle on its route
+	Rerouting *Rerouting
+	// Reroutes is the number of times vehicles switched their route so far
+	Reroutes int
+
+	vehicles []*Vehicle
+	// pending are the vehicles waiting for their departure, sorted by
+	// departure tick and ID
+	pending  []*Vehicle
+	counters map[edgeKey]*EdgeCounter
+}
+
+// NewEngine creates an engine with ticks of dt seconds
+func NewEngine(dt float64) *Engine {
+	if dt <= 0 {
+		log.Warn().Msgf("Invalid time step %f, using 1s.", dt)
+		dt = 1
+	}
+	return &Engine{
+		DT:           dt,
+		Model:        NewIDM(),
+		LaneChanging: NewMOBIL(),
+		vehicles:     make([]*Vehicle, 0),
+		counters:     make(map[edgeKey]*EdgeCounter),
+	}
+}
+
+// Add adds the vehicle to the engine. A vehicle departing in a later tick
+// waits for it, any other enters its current edge, if it is part of the
+// vehicle's graph.
+func (e *Engine) Add(v *Vehicle) {
+	if v.DepartureTick > e.Tick {
+		i := sort.Search(len(e.pending), func(i int) bool {
+			p := e.pending[i]
+			return p.DepartureTick > v.DepartureTick || (p.DepartureTick == v.DepartureTick && p.ID > v.ID)
+		})
+		e.pending = append(e.pending, nil)
+		copy(e.pending[i+1:], e.pending[i:])
+		e.pending[i] = v
+		return
+	}
+	e.enter(v)
+}
+
+// depart enters the pending vehicles departing until the current tick. A
+// vehicle whose first edge is full waits until there is room on it.
+func (e *Engine) depart() {
+	waiting := e.pending[:0]
+	for i, v := range e.pending {
+		if v.DepartureTick > e.Tick {
+			waiting = append(waiting, e.pending[i:]...)
+			break
+		}
+		idx, _ := v.deductCurrentPathVertexIndex()
+		if v.hasEdgeByIndex(idx) {
+			if edge, err := v.getEdgeByIndex(idx); err == nil && isFull(edge) {
+				waiting = append(waiting, v)
+				continue
+			}
+		}
+		e.enter(v)
+	}
+	e.pending = waiting
+}
+
+// enter adds the vehicle to the active vehicles and enters its current edge
+func (e *Engine) enter(v *Vehicle) {
+	idx, _ := v.deductCurrentPathVertexIndex()
+	if v.hasEdgeByIndex(idx) {
+		edge, err := v.getEdgeByIndex(idx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get edge.")
+		} else if err := v.AddVehicleToEdge(edge); err != nil {
+			log.Error().Err(err).Msg("Failed to add vehicle to map.")
+		}
+		e.counter(v, idx).Entered++
+	}
+	v.edgeIndex = idx
+	e.vehicles = append(e.vehicles, v)
+}
+
+// counter returns the counter of the edge at index of the vehicle's path
+func (e *Engine) counter(v *Vehicle, index int) *EdgeCounter {
+	key := edgeKey{source: v.Path[index], target: v.Path[index+1]}
+	c, ok := e.counters[key]
+	if !ok {
+		c = &EdgeCounter{Source: key.source, Target: key.target}
+		e.counters[key] = c
+	}
+	return c
+}
+
+// count counts the edges the vehicle entered in the last tick and the tick on its current edge
+func (e *Engine) count(v *Vehicle) {
+	idx, _ := v.deductCurrentPathVertexIndex()
+	for i := v.edgeIndex + 1; i <= idx; i++ {
+		if v.hasEdgeByIndex(i) {
+			e.counter(v, i).Entered++
+		}
+	}
+	v.edgeIndex = idx
+
+	if !v.IsParked && v.hasEdgeByIndex(idx) {
+		e.counter(v, idx).VehicleTicks++
+	}
+}
+
+// EdgeCounters returns the counters of all edges vehicles drove on, sorted by source and target
+func (e *Engine) EdgeCounters() []EdgeCounter {
+	counters := make([]EdgeCounter, 0, len(e.counters))
+	for _, c := range e.counters {
+		counters = append(counters, *c)
+	}
+	return MergeEdgeCounters(counters)
+}
+
+// SetSignals sets the traffic signals of the engine
+func (e *Engine) SetSignals(plans []SignalPlan) {
+	e.Signals = make(map[int]*SignalPlan, len(plans))
+	for i := range plans {
+		e.Signals[plans[i].Vertex] = &plans[i]
+	}
+}
+
+// SetJunctions sets the junctions of the graph the vehicles drive on, every
+// vertex with two or more incoming edges and no signal. Set the signals first.
+func (e *Engine) SetJunctions(g graph.Graph[int, JVertex]) error {
+	junctions, err := NewJunctions(g, e.Signals)
+	if err != nil {
+		return err
+	}
+	e.Junctions = junctions
+	return nil
+}
+
+// Time returns the simulated time in seconds at the start of the next tick
+func (e *Engine) Time() float64 {
+	return float64(e.Tick) * e.DT
+}
+
+// Len returns the number of active vehicles and vehicles waiting for their departure
+func (e *Engine) Len() int {
+	return len(e.vehicles) + len(e.pending)
+}
+
+// Vehicles returns the active vehicles
+func (e *Engine) Vehicles() []*Vehicle {
+	return e.vehicles
+}
+
+// Pending returns the vehicles waiting for their departure
+func (e *Engine) Pending() []*Vehicle {
+	return e.pending
+}
+
+// Step advances all active vehicles by one tick and returns the vehicles which
+// parked in it. Vehicles waiting for a hand-off to another rank do not move.
+func (e *Engine) Step() []*Vehicle {
+	e.depart()
+
+	moving := make([]*Vehicle, 0, len(e.vehicles))
+	for _, v := range e.vehicles {
+		if _, atBoundary := v.BoundaryVertex(); !atBoundary {
+			moving = append(moving, v)
+		}
+	}
+
+	// reroute before changing lanes, which follow the route
+	if e.Rerouting != nil {
+		e.Reroutes += e.Rerouting.reroute(moving, e.Tick, e.DT)
+	}
+
+	// change lanes one after the other, so no two vehicles move into the
+	// same gap
+	if e.LaneChanging != nil {
+		for _, v := range moving {
+			if e.LaneChanging.ChangeLane(v) {
+				e.LaneChanges++
+			}
+		}
+	}
+
+	// the stop lines of signals, full edges and junctions, from the same snapshot
+	now := e.Time()
+	stops := make([]float64, len(moving))
+	for i, v := range moving {
+		stops[i] = math.Min(stopLine(v, e.Signals, now), blockLine(v))
+	}
+	yieldLines(moving, stops, e.Junctions)
+
+	// decide
+	if e.Parallel {
+		var wg sync.WaitGroup
+		for i, v := range moving {
+			wg.Add(1)
+			go func(v *Vehicle, stop float64) {
+				defer wg.Done()
+				v.decide(e.Model, e.DT, stop)
+			}(v, stops[i])
+		}
+		wg.Wait()
+	} else {
+		for i, v := range moving {
+			v.decide(e.Model, e.DT, stops[i])
+		}
+	}
+
+	// commit
+	for _, v := range moving {
+		v.Commit(e.DT)
+		e.count(v)
+	}
+	e.Tick++
+
+	parked := make([]*Vehicle, 0)
+	active := e.vehicles[:0]
+	for _, v := range e.vehicles {
+		if v.IsParked {
+			v.ArrivalTick = e.Tick
+			parked = append(parked, v)
+			continue
+		}
+		active = append(active, v)
+	}
+	e.vehicles = active
+
+	return parked
+}
+
+// Handoffs removes every vehicle that has left the graph from the engine and
+// addresses it to the rank returned by owner for its boundary vertex
+func (e *Engine) Handoffs(owner func(vertex int) int) []Handoff {
+	handoffs := make([]Handoff, 0)
+	active := e.vehicles[:0]
+
+	for _, v := range e.vehicles {
+		if vertex, atBoundary := v.BoundaryVertex(); atBoundary {
+			handoffs = append(handoffs, v.HandoffTo(owner(vertex)))
+			continue
+		}
+		active = append(active, v)
+	}
+	e.vehicles = active
+
+	return handoffs
+}
@@ -0,0 +1,213 @@
+package streets
+
+import (
+	"strconv"
+	"testing"
+
+	"pchpc/utils"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+)
+
+// constantSpeed keeps every vehicle at its speed
+type constantSpeed struct{}
+
+func (constantSpeed) NextSpeed(speed, desired, gap, leaderSpeed, dt float64, _ *utils.RNG) float64 {
+	return speed
+}
+
+func TestEngine_Step(t *testing.T) {
+	setupLogger(t)
+	g := setupStreetGraph(t)
+
+	path, err := graph.ShortestPath(g, 269910246, 213322463)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewEngine(0.5)
+	engine.Model = constantSpeed{}
+	vehicles := []Vehicle{NewVehicle("1", 4.0, path, &g), NewVehicle("2", 3.0, path, &g)}
+	vehicles[0].DistanceTravelled = 1.0
+	for i := range vehicles {
+		engine.Add(&vehicles[i])
+	}
+
+	parked := len(engine.Step())
+	assert.Equal(t, 0, parked)
+	assert.Equal(t, 1, engine.Tick)
+	// a tick of half a second covers half the speed
+	assert.Equal(t, 3.0, vehicles[0].DistanceTravelled)
+
+	for engine.Len() > 0 {
+		parked += len(engine.Step())
+	}
+
+	assert.Equal(t, 2, parked)
+	assert.True(t, float64(engine.Tick)*engine.DT >= vehicles[0].PathLimit/4.0)
+
+	for _, v := range vehicles {
+		assert.True(t, v.IsParked)
+	}
+
+	edges, _ := g.Edges()
+	for _, e := range edges {
+		assert.Equal(t, 0, e.Properties.Data.(Data).Map.Len())
+	}
+}
+
+func TestEngine_Parallel(t *testing.T) {
+	setupLogger(t)
+	model, err := NewCarFollowingModel("krauss")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same scenario, once deciding in goroutines and once in sequence
+	run := func(parallel bool) (*Engine, []Vehicle) {
+		g := setupStreetGraph(t)
+		path, err := graph.ShortestPath(g, 269910246, 4319682656)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine := NewEngine(0.5)
+		engine.Parallel = parallel
+		engine.Model = model
+		rng := utils.NewRNG(7)
+		vehicles := make([]Vehicle, 20)
+		for i := range vehicles {
+			vehicles[i] = NewVehicle(strconv.Itoa(i), 4+float64(i%5), append([]int(nil), path...), &g)
+			vehicles[i].SetRNG(rng.Stream(uint64(i)))
+			vehicles[i].DepartureTick = i / 2
+			engine.Add(&vehicles[i])
+		}
+		return engine, vehicles
+	}
+	parallel, pVehicles := run(true)
+	sequential, sVehicles := run(false)
+
+	for parallel.Len() > 0 && parallel.Tick < 10000 {
+		parallel.Step()
+		sequential.Step()
+		assert.Equal(t, sequential.Len(), parallel.Len())
+		for i := range pVehicles {
+			assert.Equal(t, sVehicles[i].Speed, pVehicles[i].Speed)
+			assert.Equal(t, sVehicles[i].DistanceTravelled, pVehicles[i].DistanceTravelled)
+		}
+	}
+	assert.Equal(t, 0, parallel.Len())
+}
+
+func TestEngine_Snapshot(t *testing.T) {
+	setupLogger(t)
+
+	// the vehicles decide on the state before the tick, no matter in which
+	// order they are stepped
+	speeds := make([]float64, 0)
+	distances := make([]float64, 0)
+
+	for _, reversed := range []bool{false, true} {
+		g := setupStreetGraph(t)
+		path := []int{2617388513, 2290171245}
+
+		leader := NewVehicle("3", 2.0, path, &g)
+		leader.DistanceTravelled = 8.0
+		follower := NewVehicle("4", 6.0, path, &g)
+
+		engine := NewEngine(1)
+		if reversed {
+			engine.Add(&follower)
+			engine.Add(&leader)
+		} else {
+			engine.Add(&leader)
+			engine.Add(&follower)
+		}
+		engine.Step()
+
+		// the follower brakes for the slower leader
+		assert.True(t, follower.Speed < 6.0)
+		speeds = append(speeds, follower.Speed, leader.Speed)
+		distances = append(distances, follower.DistanceTravelled, leader.DistanceTravelled)
+	}
+
+	assert.Equal(t, speeds[0], speeds[2])
+	assert.Equal(t, speeds[1], speeds[3])
+	assert.Equal(t, distances[0], distances[2])
+	assert.Equal(t, distances[1], distances[3])
+}
+
+func TestEngine_EdgeCounters(t *testing.T) {
+	setupLogger(t)
+	g := setupStreetGraph(t)
+
+	path, err := graph.ShortestPath(g, 269910246, 213322463)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewEngine(1)
+	engine.Model = constantSpeed{}
+	vh := NewVehicle("5", 4.0, path, &g)
+	engine.Add(&vh)
+	for engine.Len() > 0 {
+		engine.Step()
+	}
+
+	counters := engine.EdgeCounters()
+	assert.Equal(t, len(path)-1, len(counters))
+
+	vehicleTicks := 0
+	for _, c := range counters {
+		// the vehicle entered every edge of its path once
+		assert.Equal(t, 1, c.Entered)
+		vehicleTicks += c.VehicleTicks
+	}
+	// the vehicle is counted on an edge in every tick but the one it parked in
+	assert.Equal(t, engine.Tick-1, vehicleTicks)
+
+	summary := vh.Summary(engine.DT)
+	assert.Equal(t, path[0], summary.Origin)
+	assert.Equal(t, path[len(path)-1], summary.Destination)
+	assert.Equal(t, engine.Tick, summary.ArrivalTick)
+	assert.Equal(t, vh.PathLimit, summary.Distance)
+	assert.Equal(t, vh.PathLimit/float64(engine.Tick), summary.AverageSpeed)
+}
+
+func TestEngine_Departures(t *testing.T) {
+	setupLogger(t)
+	// a 7.5 m edge 1 -> 2 stores a single vehicle
+	g := buildRoad(t, [][2]float64{{10, 50}, {10.001, 50}, {10.002, 50}}, [][2]int{{1, 2}, {2, 3}}, 7.5)
+
+	engine := NewEngine(1)
+	engine.Model = constantSpeed{}
+	late := NewVehicle("late", 5, []int{1, 2, 3}, &g.Graph)
+	late.DepartureTick = 3
+	early := NewVehicle("early", 5, []int{1, 2, 3}, &g.Graph)
+	early.DepartureTick = 2
+	engine.Add(&late)
+	engine.Add(&early)
+
+	// the vehicles wait for their departure
+	assert.Equal(t, 2, engine.Len())
+	assert.Equal(t, 0, len(engine.Vehicles()))
+	assert.Equal(t, []*Vehicle{&early, &late}, engine.Pending())
+
+	engine.Step()
+	engine.Step()
+	assert.Equal(t, 0, len(engine.Vehicles()))
+	engine.Step()
+	assert.Equal(t, []*Vehicle{&early}, engine.Vehicles())
+	assert.Equal(t, 5.0, early.DistanceTravelled)
+
+	// the first edge is full, so the late vehicle departs after the early one left it
+	engine.Step()
+	assert.Equal(t, 1, len(engine.Pending()))
+	engine.Step()
+	assert.Equal(t, 0, len(engine.Pending()))
+
+	for engine.Len() > 0 {
+		engine.Step()
+	}
+	assert.True(t, late.ArrivalTick > early.ArrivalTick)
+}
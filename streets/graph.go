@@ -3,6 +3,7 @@ package streets
 import (
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/dominikbraun/graph"
 	"github.com/rs/zerolog/log"
@@ -19,6 +20,9 @@ type StreetGraph struct {
 
 	// graph is the graph
 	Graph graph.Graph[int, JVertex]
+
+	// Signals are the plans of the traffic signals read with the graph
+	Signals []SignalPlan
 }
 
 // convertEdgeToJEdge converts an edge to a JEdge
@@ -35,20 +39,45 @@ func convertEdgeToJEdge(edge *graph.Edge[int]) (JEdge, error) {
 		MaxSpeed: fmt.Sprintf("%.2f", edgeData.MaxSpeed),
 		Name:     edgeData.Name,
 		ID:       edgeData.ID,
+		Lanes:    edgeData.Lanes,
 		Data:     edgeData,
 	}, nil
 }
 
+// RawEdge is the plain representation of an edge, used to ship a graph to
+// other ranks without its vehicle maps
+type RawEdge struct {
+	Source   int
+	Target   int
+	Length   float64
+	MaxSpeed string
+	Name     string
+	ID       string
+	Lanes    int
+}
+
+// toJEdge converts a raw edge back into a JEdge
+func (e RawEdge) toJEdge() JEdge {
+	return JEdge{
+		From:     e.Source,
+		To:       e.Target,
+		Length:   e.Length,
+		MaxSpeed: e.MaxSpeed,
+		Name:     e.Name,
+		ID:       e.ID,
+		Lanes:    e.Lanes,
+	}
+}
+
 // produceRootGraph produces a root graph
 func produceRootGraph(filePath string) *StreetGraph {
-	gb := NewGraphBuilder().FromJsonFile(filePath).WithRectangleParts(1)
+	gb := NewGraphBuilder().FromFile(filePath).WithRectangleParts(1)
 	gb = gb.SetTopRightBottomLeftVertices().DivideGraphsIntoRects()
 	gb = gb.PickRect(0).FilterForRect().IsRoot()
 	g, err := gb.Build()
 	if err != nil {
 		log.Error().Msgf("Error creating root graph: %v", err)
 		panic(err)
-		return nil
 	}
 	return g
 }
@@ -59,13 +88,11 @@ func produceLeafGraph(index, parts int, rootGraph *StreetGraph) *StreetGraph {
 	if err != nil {
 		log.Error().Msgf("Error getting vertices from root graph: %v", err)
 		panic(err)
-		return nil
 	}
 	gEdges, err := rootGraph.Graph.Edges()
 	if err != nil {
 		log.Error().Msgf("Error getting edges from root graph: %v", err)
 		panic(err)
-		return nil
 	}
 
 	edges := make([]JEdge, len(gEdges))
@@ -74,12 +101,11 @@ func produceLeafGraph(index, parts int, rootGraph *StreetGraph) *StreetGraph {
 		if err != nil {
 			log.Error().Msgf("Error converting edge to JEdge: %v", err)
 			panic(err)
-			return nil
 		}
 	}
 
 	gb := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).WithRectangleParts(parts)
-	gb = gb.PickRect(index).FilterForRect().IsLeaf()
+	gb = gb.PickRect(index).FilterForRect().IsLeaf(rootGraph)
 
 	g, err := gb.Build()
 	if err != nil {
@@ -99,18 +125,150 @@ func DefaultGraph(filePath string, nRects int) (root *StreetGraph, leafs []*Stre
 
 	leafs = make([]*StreetGraph, nRects)
 	for i := 0; i < nRects; i++ {
-		l := produceLeafGraph(i, root)
+		l := produceLeafGraph(i, nRects, root)
 		leafs[i] = l
 	}
 	return root, leafs
 }
 
+// GraphFromRect builds the leaf graph of the rectangle at index from the raw
+// edges and rectangles of the root graph, with a halo of the given depth
+func GraphFromRect(rawEdges []RawEdge, rects []Rect, index, halo int) (*StreetGraph, error) {
+	vertices := make([]JVertex, 0)
+	for _, r := range rects {
+		vertices = append(vertices, r.Vertices...)
+	}
+
+	edges := make([]JEdge, len(rawEdges))
+	for i, e := range rawEdges {
+		edges[i] = e.toJEdge()
+	}
+
+	gb := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).SetTopRightBottomLeftVertices()
+	gb = gb.WithRects(rects).WithHalo(halo).PickRect(index).FilterForRect().IsLeaf(nil)
+
+	return gb.Build()
+}
+
+// GraphFromRects builds the complete graph of all rectangles from the raw
+// edges of the root graph, e.g. to plan routes on a worker
+func GraphFromRects(rawEdges []RawEdge, rects []Rect) (*StreetGraph, error) {
+	vertices := make([]JVertex, 0)
+	for _, r := range rects {
+		vertices = append(vertices, r.Vertices...)
+	}
+
+	edges := make([]JEdge, len(rawEdges))
+	for i, e := range rawEdges {
+		edges[i] = e.toJEdge()
+	}
+
+	gb := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).WithRectangleParts(1)
+	gb = gb.SetTopRightBottomLeftVertices().DivideGraphsIntoRects().PickRect(0).FilterForRect().IsRoot()
+
+	return gb.Build()
+}
+
+// VertexOwners maps every vertex ID to the index of the rectangle holding it
+func VertexOwners(rects []Rect) map[int]int {
+	owners := make(map[int]int)
+	for i, r := range rects {
+		for _, v := range r.Vertices {
+			owners[v.ID] = i
+		}
+	}
+	return owners
+}
+
+// DivideIntoRects divides the graph column-wise into n rectangles
+func (g *StreetGraph) DivideIntoRects(n int) ([]Rect, error) {
+	return g.Partition(&StripPartitioner{}, n)
+}
+
+// Partition divides the graph into n rectangles with the partitioner
+func (g *StreetGraph) Partition(p Partitioner, n int) ([]Rect, error) {
+	vertices, err := g.GetVertices()
+	if err != nil {
+		log.Error().Msgf("Error getting vertices from graph: %v", err)
+		return nil, err
+	}
+	edges, err := g.jEdges()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Partition(vertices, edges, n)
+}
+
+// PartitionReport measures the rectangles the graph is divided into
+func (g *StreetGraph) PartitionReport(rects []Rect) (PartitionReport, error) {
+	edges, err := g.jEdges()
+	if err != nil {
+		return PartitionReport{}, err
+	}
+	return NewPartitionReport(rects, edges), nil
+}
+
+// jEdges returns all edges of the graph as JEdges
+func (g *StreetGraph) jEdges() ([]JEdge, error) {
+	gEdges, err := g.Graph.Edges()
+	if err != nil {
+		log.Error().Msgf("Error getting edges from graph: %v", err)
+		return nil, err
+	}
+
+	edges := make([]JEdge, 0, len(gEdges))
+	for i := range gEdges {
+		edge, err := convertEdgeToJEdge(&gEdges[i])
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+// RawEdges returns all edges of the graph as raw edges
+func (g *StreetGraph) RawEdges() ([]RawEdge, error) {
+	gEdges, err := g.Graph.Edges()
+	if err != nil {
+		log.Error().Msgf("Error getting edges from graph: %v", err)
+		return nil, err
+	}
+
+	rawEdges := make([]RawEdge, 0, len(gEdges))
+	for _, edge := range gEdges {
+		data, err := GetEdgeData(edge)
+		if err != nil {
+			log.Error().Msgf("Error getting edge data: %v", err)
+			return nil, err
+		}
+		rawEdges = append(rawEdges, RawEdge{
+			Source:   edge.Source,
+			Target:   edge.Target,
+			Length:   data.Length,
+			MaxSpeed: strconv.FormatFloat(data.MaxSpeed, 'f', -1, 64),
+			Name:     data.Name,
+			ID:       data.ID,
+			Lanes:    data.Lanes,
+		})
+	}
+
+	return rawEdges, nil
+}
+
 // VertexInGraph checks if a vertex is in a graph
 func (g *StreetGraph) VertexInGraph(v JVertex) bool {
 	_, err := (*g).Graph.Vertex(v.ID)
 	return err == nil
 }
 
+// vertexInGraph checks if a vertex ID is in a graph
+func vertexInGraph(g *graph.Graph[int, JVertex], id int) bool {
+	_, err := (*g).Vertex(id)
+	return err == nil
+}
+
 // GetVertices gets all vertices in a graph
 func (g *StreetGraph) GetVertices() ([]JVertex, error) {
 	gr := (*g).Graph
@@ -142,7 +300,7 @@ func (g *StreetGraph) GetVertices() ([]JVertex, error) {
 			vertices = append(vertices, dst)
 		}
 		if !slices.Contains(vertices, src) {
-			vertices = append(vertices, dst, src)
+			vertices = append(vertices, src)
 		}
 	}
 
@@ -3,9 +3,9 @@ package streets
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"strconv"
 
-	"github.com/aidarkhanov/nanoid"
 	"github.com/dominikbraun/graph"
 	"github.com/rs/zerolog/log"
 
@@ -17,24 +17,14 @@ type point struct {
 	X, Y float64
 }
 
-// rect is a rectangle in 2D space, holding the top right and bottom left points
+// Rect is a rectangle in 2D space, holding the top right and bottom left points
 // and the vertices of the rectangle
-type rect struct {
+type Rect struct {
 	TopRight point
 	BotLeft  point
 	Vertices []JVertex
 }
 
-// inRect checks if a vertex is in a rectangle
-func (r *rect) inRect(v JVertex) bool {
-	for _, vertex := range r.Vertices {
-		if vertex.ID == v.ID {
-			return true
-		}
-	}
-	return false
-}
-
 // GraphBuilder is a builder for a graph
 type GraphBuilder struct {
 	graph                StreetGraph
@@ -42,8 +32,11 @@ type GraphBuilder struct {
 	edges                []JEdge
 	rectangleParts, pick int
 	bot, top             point
-	rects                []rect
-	pickedRect           rect
+	rects                []Rect
+	pickedRect           Rect
+	partitioner          Partitioner
+	halo                 int
+	signals              []SignalPlan
 	id                   string
 	root                 *StreetGraph
 }
@@ -65,14 +58,13 @@ func (gb *GraphBuilder) WithVertices(vertices []JVertex) *GraphBuilder {
 // it also sets the Data struct of each edge
 func (gb *GraphBuilder) WithEdges(edges []JEdge) *GraphBuilder {
 	// new edge slice
-	nEdges := make([]JEdge, len(edges))
+	nEdges := make([]JEdge, 0, len(edges))
 
 	for _, e := range edges {
 		// Nil check may be redundant
 		if e.Data.Map == nil {
 			// Convert max speed to float64
-			msi, err := strconv.Atoi(e.MaxSpeed)
-			msf := float64(msi)
+			msf, err := strconv.ParseFloat(e.MaxSpeed, 64)
 			if err != nil {
 				msf = 50.0 // Default max speed, aka. 'the inchident'
 			}
@@ -86,6 +78,10 @@ func (gb *GraphBuilder) WithEdges(edges []JEdge) *GraphBuilder {
 			e.Data.Length = e.Length
 			e.Data.ID = e.ID
 			e.Data.Name = e.Name
+			e.Data.Lanes = e.Lanes
+			if e.Lanes <= 0 {
+				e.Data.Lanes = DefaultLanes(msf)
+			}
 		}
 		nEdges = append(nEdges, e)
 	}
@@ -108,7 +104,18 @@ func (gb *GraphBuilder) FromJsonBytes(jBytes []byte) *GraphBuilder {
 		panic(err)
 	}
 
-	return gb.WithVertices(jGraph.Graph.Vertices).WithEdges(jGraph.Graph.Edges)
+	return gb.FromGraphJSON(&jGraph)
+}
+
+// FromGraphJSON uses the vertices, edges and signal plans of the graph JSON, e.g. from the OSM importer
+func (gb *GraphBuilder) FromGraphJSON(jGraph *GraphJSON) *GraphBuilder {
+	return gb.WithVertices(jGraph.Graph.Vertices).WithEdges(jGraph.Graph.Edges).WithSignals(jGraph.Graph.Signals)
+}
+
+// WithSignals sets the plans of the traffic signals of the graph
+func (gb *GraphBuilder) WithSignals(plans []SignalPlan) *GraphBuilder {
+	gb.signals = plans
+	return gb
 }
 
 // FromJsonFile reads the graph JSON file and unmarshals it into a graph
@@ -122,6 +129,16 @@ func (gb *GraphBuilder) FromJsonFile(jFile string) *GraphBuilder {
 	return gb.FromJsonBytes(jBytes)
 }
 
+// FromFile reads the graph from a SQLite database (.sqlite, .db) or a graph JSON file
+func (gb *GraphBuilder) FromFile(path string) *GraphBuilder {
+	switch filepath.Ext(path) {
+	case ".sqlite", ".db":
+		return gb.FromSQLite(path)
+	default:
+		return gb.FromJsonFile(path)
+	}
+}
+
 // SetTopRightBottomLeftVertices returns the top right and bottom left vertices of the graph
 func (gb *GraphBuilder) SetTopRightBottomLeftVertices() *GraphBuilder {
 	if len(gb.vertices) == 0 {
@@ -176,7 +193,8 @@ func (gb *GraphBuilder) NumberOfRects(n int) *GraphBuilder {
 	return gb
 }
 
-// DivideGraphsIntoRects divides the graph into n parts. Column-wise division.
+// DivideGraphsIntoRects divides the graph into n parts with the partitioner,
+// column-wise by default
 func (gb *GraphBuilder) DivideGraphsIntoRects() *GraphBuilder {
 	if gb.top == (point{}) || gb.bot == (point{}) {
 		gb.SetTopRightBottomLeftVertices()
@@ -185,48 +203,27 @@ func (gb *GraphBuilder) DivideGraphsIntoRects() *GraphBuilder {
 		gb.rectangleParts = 1
 	}
 
-	n := gb.rectangleParts
-	rootBot := gb.bot
-	rootTop := gb.top
-
-	// Get all vertices
-	vertices := gb.vertices
-
-	rects := make([]rect, n)
-
-	xDelta := rootTop.X - rootBot.X
-
-	for i := 0; i < n; i++ {
-		botX := rootBot.X + (xDelta/float64(n))*float64(i)
-		topX := rootBot.X + (xDelta/float64(n))*float64(i+1)
-
-		rects[i] = rect{
-			TopRight: point{
-				X: topX,
-				Y: rootTop.Y,
-			},
-			BotLeft: point{
-				X: botX,
-				Y: rootBot.Y,
-			},
-			Vertices: make([]JVertex, 0),
-		}
-
-		for _, vertex := range vertices {
-			isInYInterval := vertex.Y >= rootBot.Y && vertex.Y <= rootTop.Y
-			isInXInterval := vertex.X >= botX && vertex.X <= topX
-
-			if isInYInterval && isInXInterval {
-				rects[i].Vertices = append(rects[i].Vertices, vertex)
-			}
-		}
+	partitioner := gb.partitioner
+	if partitioner == nil {
+		partitioner = &StripPartitioner{}
 	}
 
+	rects, err := partitioner.Partition(gb.vertices, gb.edges, gb.rectangleParts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to partition graph.")
+		panic(err)
+	}
 	gb.rects = rects
 
 	return gb
 }
 
+// WithPartitioner sets the partitioner used by DivideGraphsIntoRects
+func (gb *GraphBuilder) WithPartitioner(p Partitioner) *GraphBuilder {
+	gb.partitioner = p
+	return gb
+}
+
 // PickRect picks a rectangle from the graph
 func (gb *GraphBuilder) PickRect(i int) *GraphBuilder {
 	if gb.rects == nil {
@@ -240,46 +237,48 @@ func (gb *GraphBuilder) PickRect(i int) *GraphBuilder {
 		return gb
 	}
 
+	gb.pick = i
 	gb.pickedRect = gb.rects[i]
 
 	return gb
 }
 
-// FilterForRect filters the graph for the picked rectangle
-func (gb *GraphBuilder) FilterForRect() *GraphBuilder {
-	rect := gb.pickedRect
-	filteredEdges := make([]JEdge, 0)
-
-	// filter for coordinates in rect
-	for _, edge := range gb.edges {
-		src := edge.From
-		dst := edge.To
+// WithRects sets already divided rectangles, e.g. received from another rank
+func (gb *GraphBuilder) WithRects(rects []Rect) *GraphBuilder {
+	gb.rects = rects
+	gb.rectangleParts = len(rects)
+	return gb
+}
 
-		srcInRect := false
-		dstInRect := false
+// Rects returns the rectangles the graph has been divided into
+func (gb *GraphBuilder) Rects() []Rect {
+	return gb.rects
+}
 
-		for _, vertex := range rect.Vertices {
-			if vertex.ID == src {
-				srcInRect = true
-			}
-			if vertex.ID == dst {
-				dstInRect = true
-			}
-		}
+// FilterForRect filters the graph for the picked rectangle. An edge belongs to
+// the rectangle holding its source vertex, so edges leaving the rectangle are
+// kept together with their target vertex. This lets a vehicle drive up to the
+// first vertex of the neighbouring rectangle before it is handed off.
+// With a halo, the edges of other rectangles close to the picked one are kept
+// as ghost edges, see WithHalo.
+func (gb *GraphBuilder) FilterForRect() *GraphBuilder {
+	filteredEdges, ghostEdges := rectEdges(gb.edges, gb.rects, gb.pick, gb.halo)
+	filteredEdges = append(filteredEdges, ghostEdges...)
 
-		if srcInRect && dstInRect {
-			filteredEdges = append(filteredEdges, edge)
-		}
+	// filter for vertices in rect or reached by a kept edge
+	keep := make(map[int]bool)
+	for _, vertex := range gb.pickedRect.Vertices {
+		keep[vertex.ID] = true
+	}
+	for _, edge := range filteredEdges {
+		keep[edge.From] = true
+		keep[edge.To] = true
 	}
 
-	// filter for vertices in rect
 	filteredVertices := make([]JVertex, 0)
-
 	for _, vertex := range gb.vertices {
-		for _, rectVertex := range rect.Vertices {
-			if vertex.ID == rectVertex.ID {
-				filteredVertices = append(filteredVertices, vertex)
-			}
+		if keep[vertex.ID] {
+			filteredVertices = append(filteredVertices, vertex)
 		}
 	}
 
@@ -289,13 +288,21 @@ func (gb *GraphBuilder) FilterForRect() *GraphBuilder {
 	return gb
 }
 
+// WithHalo sets the depth of the halo kept by FilterForRect. The halo holds
+// read-only ghost copies of the edges of other rectangles up to depth edges
+// away from the picked rectangle, tagged with the rectangle owning them.
+func (gb *GraphBuilder) WithHalo(depth int) *GraphBuilder {
+	gb.halo = depth
+	return gb
+}
+
 func (gb *GraphBuilder) IsRoot() *GraphBuilder {
 	gb.id = "root"
 	return gb
 }
 
 func (gb *GraphBuilder) IsLeaf(root *StreetGraph) *GraphBuilder {
-	gb.id = nanoid.New()
+	gb.id = "leaf-" + strconv.Itoa(gb.pick)
 	gb.root = root
 	return gb
 }
@@ -366,6 +373,7 @@ func (gb *GraphBuilder) Build() (*StreetGraph, error) {
 		ID:        gb.id,
 		RootGraph: gb.root,
 		Graph:     g,
+		Signals:   gb.signals,
 	}
 
 	return &gb.graph, nil
@@ -25,6 +25,8 @@ type GraphJSON struct {
 type JGraph struct {
 	Vertices []JVertex `json:"vertices"`
 	Edges    []JEdge   `json:"edges"`
+	// Signals are the plans of the traffic signals at the vertices
+	Signals []SignalPlan `json:"signals,omitempty"`
 }
 
 type JEdge struct {
@@ -34,7 +36,10 @@ type JEdge struct {
 	MaxSpeed string  `json:"max_speed"`
 	Name     string  `json:"name"`
 	ID       string  `json:"osm_id"`
-	Data     Data
+	// Lanes is the number of lanes in the direction of the edge, 0 for the
+	// default of its speed limit, see DefaultLanes
+	Lanes int `json:"lanes,omitempty"`
+	Data  Data
 }
 
 type JVertex struct {
@@ -49,4 +54,10 @@ type Data struct {
 	MaxSpeed float64
 	Length   float64
 	Map      *utils.HashMap[string, *Vehicle]
+	// Lanes is the number of lanes, lane 0 is the rightmost
+	Lanes int
+	// Owner is the index of the rect owning the edge
+	Owner int
+	// Ghost marks a read-only copy of an edge owned by another rect
+	Ghost bool
 }
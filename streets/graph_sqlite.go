@@ -0,0 +1,122 @@
+package streets
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+)
+
+// nodeProperties is the JSON stored in the properties column of TrafficNode
+type nodeProperties struct {
+	Highway string  `json:"highway"`
+	OsmID   int     `json:"osmid"`
+	Ref     string  `json:"ref"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+}
+
+// verticesFromSQLite reads the nodes referenced by an edge from the TrafficNode table
+func verticesFromSQLite(db *sql.DB) ([]JVertex, error) {
+	rows, err := db.Query(`
+		SELECT node_id, properties FROM TrafficNode
+		WHERE node_id IN (SELECT src FROM TrafficEdge UNION SELECT dst FROM TrafficEdge)
+		GROUP BY node_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vertices := make([]JVertex, 0)
+	for rows.Next() {
+		var nodeID, properties string
+		if err := rows.Scan(&nodeID, &properties); err != nil {
+			return nil, err
+		}
+
+		id, err := strconv.Atoi(nodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		var props nodeProperties
+		if err := json.Unmarshal([]byte(properties), &props); err != nil {
+			return nil, err
+		}
+
+		vertices = append(vertices, JVertex{
+			X:  props.X,
+			Y:  props.Y,
+			ID: id,
+		})
+	}
+
+	return vertices, rows.Err()
+}
+
+// edgesFromSQLite reads the TrafficEdge table. The table has neither street
+// names nor OSM way IDs, so the row ID is used as the edge's ID.
+func edgesFromSQLite(db *sql.DB) ([]JEdge, error) {
+	rows, err := db.Query(`SELECT id, src, dst, maxSpeed, length FROM TrafficEdge`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := make([]JEdge, 0)
+	for rows.Next() {
+		var (
+			id, maxSpeed int
+			src, dst     string
+			length       float64
+		)
+		if err := rows.Scan(&id, &src, &dst, &maxSpeed, &length); err != nil {
+			return nil, err
+		}
+
+		from, err := strconv.Atoi(src)
+		if err != nil {
+			return nil, err
+		}
+		to, err := strconv.Atoi(dst)
+		if err != nil {
+			return nil, err
+		}
+
+		edges = append(edges, JEdge{
+			From:     from,
+			To:       to,
+			Length:   length,
+			MaxSpeed: strconv.Itoa(maxSpeed),
+			ID:       strconv.Itoa(id),
+		})
+	}
+
+	return edges, rows.Err()
+}
+
+// FromSQLite reads the graph from the TrafficNode and TrafficEdge tables of a SQLite database
+func (gb *GraphBuilder) FromSQLite(path string) *GraphBuilder {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open SQLite database.")
+		panic(err)
+	}
+	defer db.Close()
+
+	vertices, err := verticesFromSQLite(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read vertices from SQLite database.")
+		panic(err)
+	}
+
+	edges, err := edgesFromSQLite(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read edges from SQLite database.")
+		panic(err)
+	}
+
+	return gb.WithVertices(vertices).WithEdges(edges)
+}
@@ -0,0 +1,43 @@
+package streets
+
+import (
+	"math"
+	"testing"
+
+	"pchpc/utils"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+func TestGraphBuilder_FromSQLite(t *testing.T) {
+	setupLogger(t)
+	setupDB(t)
+
+	dbRoot, _ := DefaultGraph(utils.GetDbPath(), 1)
+	jsonRoot, _ := DefaultGraph(graphFile, 1)
+
+	dbEdges, err := dbRoot.Graph.Edges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonSize, _ := jsonRoot.Graph.Size()
+	assert.Equal(t, jsonSize, len(dbEdges))
+
+	// the database holds the same streets as the bundled JSON
+	for _, e := range dbEdges {
+		jsonEdge, err := jsonRoot.Graph.Edge(e.Source, e.Target)
+		if err != nil {
+			t.Fatalf("Edge %d -> %d missing in JSON graph", e.Source, e.Target)
+		}
+
+		dbData := e.Properties.Data.(Data)
+		jsonData := jsonEdge.Properties.Data.(Data)
+		assert.True(t, math.Abs(jsonData.Length-dbData.Length) < 1e-6)
+		assert.True(t, dbData.MaxSpeed > 0)
+		assert.True(t, dbData.Map != nil)
+
+		dbVertex, _ := dbRoot.Graph.Vertex(e.Source)
+		jsonVertex, _ := jsonRoot.Graph.Vertex(e.Source)
+		assert.Equal(t, jsonVertex, dbVertex)
+	}
+}
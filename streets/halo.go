@@ -0,0 +1,164 @@
+package streets
+
+import (
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// edgeKey identifies an edge by its source and target
+type edgeKey struct {
+	source, target int
+}
+
+// rectEdges returns the edges owned by the rect at index and the ghost edges
+// of its halo, up to depth edges away from the rect. Every edge is tagged with
+// the index of the rect holding its source.
+func rectEdges(edges []JEdge, rects []Rect, index, depth int) (own, ghosts []JEdge) {
+	owners := VertexOwners(rects)
+	reached := make(map[int]bool)
+	if index >= 0 && index < len(rects) {
+		for _, v := range rects[index].Vertices {
+			reached[v.ID] = true
+		}
+	}
+
+	owned := make(map[int]bool)
+	own = make([]JEdge, 0)
+	for i, e := range edges {
+		if owner, ok := owners[e.From]; !ok || owner != index {
+			continue
+		}
+		e.Data.Owner = index
+		e.Data.Ghost = false
+		own = append(own, e)
+		owned[i] = true
+		reached[e.To] = true
+	}
+
+	// grow the halo by one edge in either direction per level
+	ghosts = make([]JEdge, 0)
+	for d := 0; d < depth; d++ {
+		next := make([]int, 0)
+		for i, e := range edges {
+			if owned[i] || !(reached[e.From] || reached[e.To]) {
+				continue
+			}
+			e.Data.Owner = owners[e.From]
+			e.Data.Ghost = true
+			ghosts = append(ghosts, e)
+			owned[i] = true
+			next = append(next, e.From, e.To)
+		}
+		for _, v := range next {
+			reached[v] = true
+		}
+	}
+
+	return own, ghosts
+}
+
+// Ghost is a read-only copy of a vehicle, addressed to a rank holding the
+// vehicle's edge in its halo
+type Ghost struct {
+	Rank    int     `json:"rank"`
+	Vehicle Vehicle `json:"vehicle"`
+}
+
+// Halo knows which other rects hold ghost copies of the edges of a rect
+type Halo struct {
+	watchers map[edgeKey][]int
+}
+
+// NewHalo finds the rects whose halo of the given depth holds edges of the
+// rect at index
+func NewHalo(rawEdges []RawEdge, rects []Rect, index, depth int) *Halo {
+	edges := make([]JEdge, len(rawEdges))
+	for i, e := range rawEdges {
+		edges[i] = e.toJEdge()
+	}
+
+	h := &Halo{watchers: make(map[edgeKey][]int)}
+	if depth < 1 {
+		return h
+	}
+
+	for r := range rects {
+		if r == index {
+			continue
+		}
+		_, ghosts := rectEdges(edges, rects, r, depth)
+		for _, e := range ghosts {
+			if e.Data.Owner == index {
+				key := edgeKey{source: e.From, target: e.To}
+				h.watchers[key] = append(h.watchers[key], r)
+			}
+		}
+	}
+
+	return h
+}
+
+// Ghosts returns a ghost copy of every vehicle on an edge in the halo of other
+// rects, addressed to the rank returned by rank for each of these rects
+func (h *Halo) Ghosts(vehicles []*Vehicle, rank func(rect int) int) []Ghost {
+	ghosts := make([]Ghost, 0)
+	if len(h.watchers) == 0 {
+		return ghosts
+	}
+
+	for _, v := range vehicles {
+		if v.IsParked {
+			continue
+		}
+		idx, _ := v.deductCurrentPathVertexIndex()
+		if !v.hasEdgeByIndex(idx) {
+			continue
+		}
+
+		for _, r := range h.watchers[edgeKey{source: v.Path[idx], target: v.Path[idx+1]}] {
+			ghosts = append(ghosts, Ghost{Rank: rank(r), Vehicle: *v})
+		}
+	}
+
+	return ghosts
+}
+
+// SetGhosts replaces the vehicles on the ghost edges of the graph with the
+// given ghost copies, so vehicles see their leaders across the boundary
+func (g *StreetGraph) SetGhosts(vehicles []Vehicle) error {
+	edges, err := g.Graph.Edges()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get edges.")
+		return err
+	}
+	for _, e := range edges {
+		if data, ok := e.Properties.Data.(Data); ok && data.Ghost {
+			data.Map.Clear()
+		}
+	}
+
+	for i := range vehicles {
+		v := &vehicles[i]
+		v.SetGraph(&g.Graph)
+		idx, _ := v.deductCurrentPathVertexIndex()
+		if idx >= len(v.Path)-1 {
+			continue
+		}
+
+		edge, err := g.Graph.Edge(v.Path[idx], v.Path[idx+1])
+		if err != nil {
+			continue
+		}
+		if data := edge.Properties.Data.(Data); data.Ghost {
+			data.Map.Set(v.ID, v)
+		}
+	}
+
+	return nil
+}
+
+// isGhostEdge checks if the edge is a ghost copy of an edge of another rect
+func isGhostEdge(edge graph.Edge[JVertex]) bool {
+	data, ok := edge.Properties.Data.(Data)
+	return ok && data.Ghost
+}
@@ -0,0 +1,135 @@
+package streets
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+)
+
+func TestGraphFromRect_Halo(t *testing.T) {
+	root, _ := DefaultGraph(graphFile, 1)
+
+	rects, err := root.DivideIntoRects(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawEdges, err := root.RawEdges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := VertexOwners(rects)
+	rootSize, _ := root.Graph.Size()
+
+	ghostsByDepth := make([]int, 3)
+	for depth := range ghostsByDepth {
+		total := 0
+		for i := range rects {
+			leaf, err := GraphFromRect(rawEdges, rects, i, depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			edges, _ := leaf.Graph.Edges()
+			for _, e := range edges {
+				data := e.Properties.Data.(Data)
+				// every edge is tagged with the rect owning it
+				assert.Equal(t, owners[e.Source], data.Owner)
+				assert.Equal(t, data.Owner != i, data.Ghost)
+				if data.Ghost {
+					ghostsByDepth[depth]++
+				} else {
+					total++
+				}
+			}
+		}
+
+		// ghosts do not change the edges owned by the leafs
+		assert.Equal(t, rootSize, total)
+	}
+
+	assert.Equal(t, 0, ghostsByDepth[0])
+	assert.True(t, ghostsByDepth[1] > 0)
+	assert.True(t, ghostsByDepth[2] > ghostsByDepth[1])
+}
+
+func TestHalo_LeaderAcrossBoundary(t *testing.T) {
+	setupLogger(t)
+	root, _ := DefaultGraph(graphFile, 1)
+
+	rects, err := root.DivideIntoRects(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawEdges, err := root.RawEdges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := VertexOwners(rects)
+
+	leafs := make([]*StreetGraph, len(rects))
+	for i := range rects {
+		leafs[i], err = GraphFromRect(rawEdges, rects, i, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// path crossing the whole city from west to east
+	path, err := graph.ShortestPath(root.Graph, 269910246, 4319682656)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := NewVehicle("1", 0, path, &root.Graph)
+
+	// first edge of the path owned by rect 1
+	k := 0
+	for owners[path[k]] != 1 {
+		k++
+	}
+	boundary := 0.0
+	for _, l := range template.PathLengths[:k] {
+		boundary += l
+	}
+	ahead := math.Min(20, template.PathLengths[k]/2)
+
+	follower := NewVehicle("2", 5, path, &root.Graph)
+	follower.ID = "follower"
+	follower.DistanceTravelled = boundary - 1
+	follower.SetGraph(&leafs[0].Graph)
+
+	leader := NewVehicle("3", 0, path, &root.Graph)
+	leader.ID = "leader"
+	leader.DistanceTravelled = boundary + ahead
+	leader.SetGraph(&leafs[1].Graph)
+
+	// the follower cannot drive on the ghost edge, the leader is owned by rect 1
+	_, atBoundary := follower.BoundaryVertex()
+	assert.False(t, atBoundary)
+	_, atBoundary = leader.BoundaryVertex()
+	assert.False(t, atBoundary)
+
+	front, _ := follower.leader(leaderLookahead)
+	assert.True(t, front == nil)
+
+	ghosts := NewHalo(rawEdges, rects, 1, 1).Ghosts([]*Vehicle{&leader}, func(rect int) int { return rect })
+	assert.Equal(t, 1, len(ghosts))
+	assert.Equal(t, 0, ghosts[0].Rank)
+
+	if err := leafs[0].SetGhosts([]Vehicle{ghosts[0].Vehicle}); err != nil {
+		t.Fatal(err)
+	}
+
+	front, gap := follower.leader(leaderLookahead)
+	assert.True(t, front != nil)
+	assert.Equal(t, "leader", front.ID)
+	assert.True(t, math.Abs(gap-(1+ahead-VehicleLength)) < 1e-6)
+
+	// ghosts are replaced on every update
+	if err := leafs[0].SetGhosts(nil); err != nil {
+		t.Fatal(err)
+	}
+	front, _ = follower.leader(leaderLookahead)
+	assert.True(t, front == nil)
+}
@@ -0,0 +1,72 @@
+package streets
+
+import (
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// Handoff is a vehicle leaving the graph of a rank, addressed to the rank
+// owning the next edge on its path
+type Handoff struct {
+	Rank    int     `json:"rank"`
+	Vehicle Vehicle `json:"vehicle"`
+}
+
+// SetGraph sets the graph the vehicle drives on, e.g. after it has been received from another rank
+func (v *Vehicle) SetGraph(g *graph.Graph[int, JVertex]) {
+	v.g = g
+}
+
+// BoundaryVertex returns the source vertex of the vehicle's current edge if that
+// edge is not part of the vehicle's graph, i.e. the vehicle has to be handed off
+// to the rank owning that vertex
+func (v *Vehicle) BoundaryVertex() (vertex int, atBoundary bool) {
+	if v.IsParked || len(v.Path) < 2 {
+		return 0, false
+	}
+
+	idx, _ := v.deductCurrentPathVertexIndex()
+	if v.hasEdgeByIndex(idx) {
+		return 0, false
+	}
+
+	return v.Path[idx], true
+}
+
+// Detach removes the vehicle from the last edge of its path that is part of its
+// graph, so it can leave the graph without a stale entry in the edge's hashmap
+func (v *Vehicle) Detach() {
+	idx, _ := v.deductCurrentPathVertexIndex()
+
+	for i := idx; i >= 0; i-- {
+		if !v.hasEdgeByIndex(i) {
+			continue
+		}
+
+		edge, err := v.getEdgeByIndex(i)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get edge.")
+			return
+		}
+
+		hashMap, err := v.getHashMapByEdge(edge)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get hashmap.")
+			return
+		}
+
+		if v.isInMap(hashMap) {
+			hashMap.Del(v.ID)
+			return
+		}
+	}
+}
+
+// HandoffTo prepares the vehicle for being sent to the given rank
+func (v *Vehicle) HandoffTo(rank int) Handoff {
+	v.Detach()
+	return Handoff{
+		Rank:    rank,
+		Vehicle: *v,
+	}
+}
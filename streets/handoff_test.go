@@ -0,0 +1,82 @@
+package streets
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+	"github.com/dominikbraun/graph"
+)
+
+func TestVehicle_Handoff(t *testing.T) {
+	setupLogger(t)
+	root, _ := DefaultGraph(graphFile, 1)
+
+	rects, err := root.DivideIntoRects(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawEdges, err := root.RawEdges()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafs := make([]*StreetGraph, len(rects))
+	for i := range rects {
+		leafs[i], err = GraphFromRect(rawEdges, rects, i, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	owners := VertexOwners(rects)
+
+	// path crossing the whole city from west to east
+	path, err := graph.ShortestPath(root.Graph, 269910246, 4319682656)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vh := NewVehicle("1", 5.0, path, &root.Graph)
+	rank := owners[path[0]]
+	vh.SetGraph(&leafs[rank].Graph)
+
+	handoffs := 0
+	for !vh.IsParked {
+		vertex, atBoundary := vh.BoundaryVertex()
+		if !atBoundary {
+			vh.Step()
+			continue
+		}
+
+		h := vh.HandoffTo(owners[vertex])
+		assert.True(t, h.Rank != rank)
+
+		bytes, err := json.Marshal(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received Handoff
+		if err := json.Unmarshal(bytes, &received); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, vh.DistanceTravelled, received.Vehicle.DistanceTravelled)
+		assert.Equal(t, vh.Speed, received.Vehicle.Speed)
+
+		rank = received.Rank
+		vh = received.Vehicle
+		vh.SetGraph(&leafs[rank].Graph)
+		handoffs++
+	}
+
+	assert.True(t, handoffs >= 2)
+	assert.True(t, vh.DistanceTravelled >= vh.PathLimit)
+
+	// no vehicle is left behind on any rank
+	for _, leaf := range leafs {
+		edges, _ := leaf.Graph.Edges()
+		for _, e := range edges {
+			assert.Equal(t, 0, e.Properties.Data.(Data).Map.Len())
+		}
+	}
+}
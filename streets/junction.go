@@ -0,0 +1,333 @@
+package streets
+
+import (
+	"math"
+	"sort"
+
+	"pchpc/utils"
+
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// junctionLookahead is the distance in metres to a junction from which a
+// vehicle takes part in its priority rules
+const junctionLookahead = 50.0
+
+// criticalGap is the time in seconds a yielding vehicle needs between itself
+// and the next vehicle with priority to enter a junction
+const criticalGap = 4.0
+
+// junctionAcceleration is the acceleration in m/s² expected from a vehicle
+// starting at a junction
+const junctionAcceleration = 1.0
+
+// yieldDeceleration is the deceleration in m/s² up to which a vehicle stops
+// to yield instead of entering the junction
+const yieldDeceleration = 4.5
+
+// Turn is the direction of a movement through a junction
+type Turn int
+
+const (
+	TurnStraight Turn = iota
+	TurnRight
+	TurnLeft
+)
+
+// Movement is the way of a vehicle through a vertex, from the source of its
+// current edge to the target of its next one
+type Movement struct {
+	From, Via, To int
+}
+
+// junctionApproach is an incoming edge of a junction
+type junctionApproach struct {
+	from int
+	// priority is the speed limit of the edge, streets with a higher limit
+	// have priority
+	priority float64
+	vehicles *utils.HashMap[string, *Vehicle]
+}
+
+// Junction is a vertex without traffic signal where two or more streets
+// meet. Vehicles on a street with a higher speed limit have priority,
+// between streets of the same limit the vehicle coming from the right. A
+// vehicle turning left yields to oncoming traffic. A yielding vehicle waits
+// at the end of its street until the next vehicle with priority is more
+// than the critical gap away.
+type Junction struct {
+	Vertex     int
+	approaches []junctionApproach
+	// coords are the coordinates of the vertex and its neighbours
+	coords map[int]JVertex
+}
+
+// NewJunctions returns the junctions of the graph, the vertices with two or
+// more incoming edges and no signal. Ghost edges are approaches as well, so
+// vehicles see conflicting traffic of other ranks in the halo.
+func NewJunctions(g graph.Graph[int, JVertex], signals map[int]*SignalPlan) (map[int]*Junction, error) {
+	predecessors, err := g.PredecessorMap()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get predecessors.")
+		return nil, err
+	}
+	successors, err := g.AdjacencyMap()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get successors.")
+		return nil, err
+	}
+
+	coord := func(id int) (JVertex, bool) {
+		v, err := g.Vertex(id)
+		return v, err == nil
+	}
+
+	junctions := make(map[int]*Junction)
+	for vertex, incoming := range predecessors {
+		if _, signalized := signals[vertex]; signalized || len(incoming) < 2 {
+			continue
+		}
+		center, ok := coord(vertex)
+		if !ok {
+			continue
+		}
+
+		j := &Junction{Vertex: vertex, coords: map[int]JVertex{vertex: center}}
+		for from, edge := range incoming {
+			data, ok := edge.Properties.Data.(Data)
+			if !ok {
+				continue
+			}
+			j.approaches = append(j.approaches, junctionApproach{from: from, priority: data.MaxSpeed, vehicles: data.Map})
+			if v, ok := coord(from); ok {
+				j.coords[from] = v
+			}
+		}
+		for to := range successors[vertex] {
+			if v, ok := coord(to); ok {
+				j.coords[to] = v
+			}
+		}
+		sort.Slice(j.approaches, func(a, b int) bool { return j.approaches[a].from < j.approaches[b].from })
+		junctions[vertex] = j
+	}
+
+	return junctions, nil
+}
+
+// angle returns the direction from vertex a to vertex b
+func (j *Junction) angle(a, b int) float64 {
+	return heading(j.coords[a], j.coords[b])
+}
+
+// heading returns the direction from a to b, counterclockwise from east
+func heading(a, b JVertex) float64 {
+	return math.Atan2(b.Y-a.Y, b.X-a.X)
+}
+
+// relative returns the angle from heading a to heading b in (-π, π],
+// positive counterclockwise
+func relative(a, b float64) float64 {
+	d := math.Mod(b-a, 2*math.Pi)
+	switch {
+	case d > math.Pi:
+		d -= 2 * math.Pi
+	case d <= -math.Pi:
+		d += 2 * math.Pi
+	}
+	return d
+}
+
+// Turn returns the direction of the movement. U-turns count as left turns.
+func (j *Junction) Turn(m Movement) Turn {
+	return turn(j.coords[m.From], j.coords[j.Vertex], j.coords[m.To])
+}
+
+// turn returns the direction of driving from from over via to to
+func turn(from, via, to JVertex) Turn {
+	d := relative(heading(from, via), heading(via, to))
+	switch {
+	case d > math.Pi/4 || d < -3*math.Pi/4:
+		return TurnLeft
+	case d < -math.Pi/4:
+		return TurnRight
+	default:
+		return TurnStraight
+	}
+}
+
+// Conflict checks if the two movements from different approaches cross or
+// merge. Right turns only merge into their exit, opposite through movements
+// do not meet.
+func (j *Junction) Conflict(a, b Movement) bool {
+	if a.From == b.From {
+		return false
+	}
+	if a.To == b.To {
+		return true
+	}
+	ta, tb := j.Turn(a), j.Turn(b)
+	if ta == TurnRight || tb == TurnRight {
+		return false
+	}
+	if ta == TurnStraight && tb == TurnStraight {
+		return !j.opposite(a.From, b.From)
+	}
+	return true
+}
+
+// opposite checks if the approach from b is roughly straight ahead of the one from a
+func (j *Junction) opposite(a, b int) bool {
+	return math.Abs(relative(j.angle(a, j.Vertex), j.angle(j.Vertex, b))) < math.Pi/4
+}
+
+// priority returns the priority of the approach from the source vertex
+func (j *Junction) priority(from int) float64 {
+	for _, a := range j.approaches {
+		if a.from == from {
+			return a.priority
+		}
+	}
+	return 0
+}
+
+// Yields checks if a vehicle on movement a has to give way to one on the
+// conflicting movement b
+func (j *Junction) Yields(a, b Movement) bool {
+	if !j.Conflict(a, b) {
+		return false
+	}
+	if pa, pb := j.priority(a.From), j.priority(b.From); pa != pb {
+		return pa < pb
+	}
+
+	side := relative(j.angle(a.From, j.Vertex), j.angle(j.Vertex, b.From))
+	switch {
+	case side < -math.Pi/4 && side > -3*math.Pi/4:
+		// b comes from the right
+		return true
+	case j.opposite(a.From, b.From):
+		// turning left, yield to oncoming traffic
+		return j.Turn(a) == TurnLeft && j.Turn(b) != TurnLeft
+	default:
+		return false
+	}
+}
+
+// arrival is the first vehicle of an approach of a junction
+type arrival struct {
+	vehicle  *Vehicle
+	movement Movement
+	// remaining is the distance to the junction in metres
+	remaining float64
+	// time is the time in seconds until the vehicle reaches the junction
+	time float64
+}
+
+// arrivalTime returns the time in seconds a vehicle at speed needs for the
+// remaining distance. Slow vehicles are expected to accelerate, so a vehicle
+// waiting at the junction with priority still keeps others waiting.
+func arrivalTime(remaining, speed float64) float64 {
+	accelerating := math.Sqrt(2 * remaining / junctionAcceleration)
+	if speed <= 0 {
+		return accelerating
+	}
+	return math.Min(remaining/speed, accelerating)
+}
+
+// arrivals returns the first vehicle of every approach within the lookahead
+// that drives on through the junction
+func (j *Junction) arrivals() []arrival {
+	arrivals := make([]arrival, 0, len(j.approaches))
+	for _, a := range j.approaches {
+		var first *arrival
+		for _, v := range a.vehicles.ToList() {
+			idx, position := v.deductCurrentPathVertexIndex()
+			if v.IsParked || idx >= len(v.Path)-2 || v.Path[idx] != a.from || v.Path[idx+1] != j.Vertex {
+				continue
+			}
+			// a vehicle waiting for its full next edge does not enter the junction
+			if v.nextEdgeFull() {
+				continue
+			}
+			remaining := v.PathLengths[idx] - position
+			if remaining > junctionLookahead {
+				continue
+			}
+			if first == nil || remaining < first.remaining || (remaining == first.remaining && v.ID < first.vehicle.ID) {
+				first = &arrival{
+					vehicle:   v,
+					movement:  Movement{From: a.from, Via: j.Vertex, To: v.Path[idx+2]},
+					remaining: remaining,
+					time:      arrivalTime(remaining, v.Speed),
+				}
+			}
+		}
+		if first != nil {
+			arrivals = append(arrivals, *first)
+		}
+	}
+	return arrivals
+}
+
+// Waiting returns the vehicles which have to stop at the junction and the
+// distance to it. A vehicle yields if a vehicle with priority reaches the
+// junction within the critical gap, unless it can not stop comfortably any
+// more. If every vehicle would wait, the one arriving first goes.
+func (j *Junction) Waiting() map[*Vehicle]float64 {
+	arrivals := j.arrivals()
+	waiting := make(map[*Vehicle]float64)
+
+	for _, a := range arrivals {
+		if a.remaining < a.vehicle.Speed*a.vehicle.Speed/(2*yieldDeceleration) {
+			continue
+		}
+		for _, b := range arrivals {
+			if b.vehicle != a.vehicle && b.time < criticalGap && j.Yields(a.movement, b.movement) {
+				waiting[a.vehicle] = a.remaining
+				break
+			}
+		}
+	}
+
+	if len(arrivals) > 1 && len(waiting) == len(arrivals) {
+		first := arrivals[0]
+		for _, a := range arrivals[1:] {
+			if a.time < first.time || (a.time == first.time && a.movement.From < first.movement.From) {
+				first = a
+			}
+		}
+		delete(waiting, first.vehicle)
+	}
+
+	return waiting
+}
+
+// yieldLines lowers the stop lines of the vehicles which have to give way at
+// the junction at the end of their current edge
+func yieldLines(vehicles []*Vehicle, stops []float64, junctions map[int]*Junction) {
+	if len(junctions) == 0 {
+		return
+	}
+
+	waiting := make(map[int]map[*Vehicle]float64)
+	for i, v := range vehicles {
+		idx, _ := v.deductCurrentPathVertexIndex()
+		if v.IsParked || idx >= len(v.Path)-2 {
+			continue
+		}
+		j, ok := junctions[v.Path[idx+1]]
+		if !ok {
+			continue
+		}
+		w, ok := waiting[j.Vertex]
+		if !ok {
+			w = j.Waiting()
+			waiting[j.Vertex] = w
+		}
+		if stop, ok := w[v]; ok && stop < stops[i] {
+			stops[i] = stop
+		}
+	}
+}
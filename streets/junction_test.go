@@ -0,0 +1,116 @@
+package streets
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+func TestJunction_Rules(t *testing.T) {
+	setupLogger(t)
+	g := buildCrossing(t, "50")
+	junctions, err := NewJunctions(g.Graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the arms are dead ends, only the center is a junction
+	assert.Equal(t, 1, len(junctions))
+	j := junctions[crossingCenter]
+
+	move := func(from, to int) Movement {
+		return Movement{From: from, Via: crossingCenter, To: to}
+	}
+	northbound := move(crossingSouth, crossingNorth)
+	southbound := move(crossingNorth, crossingSouth)
+	westbound := move(crossingEast, crossingWest)
+
+	assert.Equal(t, TurnStraight, j.Turn(northbound))
+	assert.Equal(t, TurnRight, j.Turn(move(crossingSouth, crossingEast)))
+	assert.Equal(t, TurnLeft, j.Turn(move(crossingSouth, crossingWest)))
+
+	// opposite through movements and right turns into other exits do not meet
+	assert.False(t, j.Conflict(northbound, southbound))
+	assert.False(t, j.Conflict(move(crossingSouth, crossingEast), move(crossingWest, crossingNorth)))
+	assert.True(t, j.Conflict(northbound, westbound))
+	assert.True(t, j.Conflict(move(crossingSouth, crossingEast), move(crossingWest, crossingEast)))
+
+	// right before left
+	assert.True(t, j.Yields(northbound, westbound))
+	assert.False(t, j.Yields(westbound, northbound))
+
+	// turning left yields to oncoming traffic
+	assert.True(t, j.Yields(move(crossingSouth, crossingWest), southbound))
+	assert.False(t, j.Yields(southbound, move(crossingSouth, crossingWest)))
+
+	// a street with a higher speed limit has priority over the right
+	g = buildCrossing(t, "70")
+	junctions, err = NewJunctions(g.Graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j = junctions[crossingCenter]
+	eastbound := move(crossingWest, crossingEast)
+	assert.True(t, j.Yields(northbound, eastbound))
+	assert.False(t, j.Yields(eastbound, northbound))
+}
+
+// passages drives the vehicles through the crossing and returns the tick each
+// of them entered the junction
+func passages(t *testing.T, g *StreetGraph, paths [][]int, remaining []float64) []int {
+	t.Helper()
+
+	engine := NewEngine(0.5)
+	if err := engine.SetJunctions(g.Graph); err != nil {
+		t.Fatal(err)
+	}
+
+	vehicles := make([]Vehicle, len(paths))
+	for i, path := range paths {
+		vehicles[i] = NewVehicle(strconv.Itoa(i), 8, path, &g.Graph)
+		vehicles[i].DistanceTravelled = 100 - remaining[i]
+	}
+	for i := range vehicles {
+		engine.Add(&vehicles[i])
+	}
+
+	entered := make([]int, len(vehicles))
+	for engine.Len() > 0 && engine.Tick < 1000 {
+		engine.Step()
+		for i := range vehicles {
+			if idx, _ := vehicles[i].deductCurrentPathVertexIndex(); idx > 0 && entered[i] == 0 {
+				entered[i] = engine.Tick
+			}
+		}
+	}
+	assert.Equal(t, 0, engine.Len())
+	return entered
+}
+
+func TestEngine_GivesWay(t *testing.T) {
+	setupLogger(t)
+	northbound := []int{crossingSouth, crossingCenter, crossingNorth}
+	westbound := []int{crossingEast, crossingCenter, crossingWest}
+	eastbound := []int{crossingWest, crossingCenter, crossingEast}
+	southbound := []int{crossingNorth, crossingCenter, crossingSouth}
+
+	// the northbound vehicle is closer, but gives way to the one from its right
+	entered := passages(t, buildCrossing(t, "50"), [][]int{northbound, westbound}, []float64{45, 50})
+	assert.True(t, entered[1] < entered[0])
+
+	// on the major road the eastbound vehicle has priority, although it comes from the left
+	entered = passages(t, buildCrossing(t, "70"), [][]int{northbound, eastbound}, []float64{45, 50})
+	assert.True(t, entered[1] < entered[0])
+
+	// opposite vehicles going straight on pass together
+	entered = passages(t, buildCrossing(t, "50"), [][]int{northbound, southbound}, []float64{45, 45})
+	assert.Equal(t, entered[0], entered[1])
+
+	// everyone coming from the right of someone else: one vehicle goes first,
+	// no one waits forever
+	entered = passages(t, buildCrossing(t, "50"), [][]int{northbound, southbound, westbound, eastbound}, []float64{40, 40, 40, 40})
+	for _, tick := range entered {
+		assert.True(t, tick > 0)
+	}
+}
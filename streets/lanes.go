@@ -0,0 +1,225 @@
+package streets
+
+import (
+	"math"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultLanes returns the number of lanes of an edge with the speed limit in
+// km/h if the input does not give one. Streets of 70 km/h or more get two.
+func DefaultLanes(maxSpeed float64) int {
+	if maxSpeed >= 70 {
+		return 2
+	}
+	return 1
+}
+
+// clampLane returns the lane on an edge with the given number of lanes
+// closest to lane. Edges without a number of lanes have one.
+func clampLane(lane, lanes int) int {
+	if lanes < 1 {
+		lanes = 1
+	}
+	switch {
+	case lane >= lanes:
+		return lanes - 1
+	case lane < 0:
+		return 0
+	default:
+		return lane
+	}
+}
+
+// MOBIL is the lane-changing model MOBIL ("minimizing overall braking induced
+// by lane changes") by Kesting, Treiber and Helbing. A vehicle changes to an
+// adjacent lane if its new follower does not have to brake harder than the
+// safe deceleration and its own advantage, minus the disadvantage of the
+// followers weighted by the politeness, exceeds the threshold.
+type MOBIL struct {
+	// Politeness weighs the disadvantage of the followers, 0 is selfish
+	Politeness float64
+	// Threshold is the advantage in m/s² a lane change has to bring
+	Threshold float64
+	// SafeDeceleration is the largest deceleration in m/s² a lane change may
+	// force on the new follower
+	SafeDeceleration float64
+	// TurnBias is the advantage in m/s² of a lane towards the side of the
+	// next turn
+	TurnBias float64
+	// TurnDistance is the distance in metres to the end of the edge from
+	// which vehicles move towards the side of their next turn
+	TurnDistance float64
+	// Model computes the accelerations of the vehicles
+	Model *IDM
+}
+
+// NewMOBIL returns a MOBIL with typical parameters
+func NewMOBIL() *MOBIL {
+	return &MOBIL{
+		Politeness:       0.2,
+		Threshold:        0.1,
+		SafeDeceleration: 4.0,
+		TurnBias:         1.0,
+		TurnDistance:     150.0,
+		Model:            NewIDM(),
+	}
+}
+
+// lanes returns the number of lanes of the current edge of the vehicle
+func (v *Vehicle) lanes() int {
+	edge, err := v.getCurrentEdge()
+	if err != nil {
+		return 1
+	}
+	data, ok := edge.Properties.Data.(Data)
+	if !ok || data.Lanes < 1 {
+		return 1
+	}
+	return data.Lanes
+}
+
+// followerIn returns the closest vehicle behind the vehicle in lane on its
+// current edge and the free gap to it, or +Inf without a follower
+func (v *Vehicle) followerIn(lane int) (*Vehicle, float64) {
+	edge, err := v.getCurrentEdge()
+	if err != nil {
+		return nil, math.Inf(1)
+	}
+	hashMap, err := v.getHashMapByEdge(edge)
+	if err != nil {
+		return nil, math.Inf(1)
+	}
+
+	_, position := v.deductCurrentPathVertexIndex()
+	var back *Vehicle
+	backPosition := math.Inf(-1)
+	for _, vh := range hashMap.ToList() {
+		if vh.ID == v.ID || vh.Lane != lane {
+			continue
+		}
+		_, p := vh.deductCurrentPathVertexIndex()
+		if p > position || (p == position && vh.ID > v.ID) {
+			continue
+		}
+		if back == nil || p > backPosition || (p == backPosition && vh.ID > back.ID) {
+			back, backPosition = vh, p
+		}
+	}
+	if back == nil {
+		return nil, math.Inf(1)
+	}
+	return back, position - backPosition - VehicleLength
+}
+
+// desiredSpeed returns the speed limit of the vehicle's current edge in m/s
+func (v *Vehicle) desiredSpeed() float64 {
+	edge, err := v.getCurrentEdge()
+	if err != nil {
+		return 0
+	}
+	return edge.Properties.Data.(Data).MaxSpeed / 3.6
+}
+
+// acceleration returns the acceleration of the vehicle following a leader
+// gap metres ahead, or driving freely without one
+func (m *MOBIL) acceleration(v, leader *Vehicle, gap float64) float64 {
+	leaderSpeed := 0.0
+	if leader != nil {
+		leaderSpeed = leader.Speed
+	} else {
+		gap = math.Inf(1)
+	}
+	return m.Model.acceleration(v.Speed, v.desiredSpeed(), gap, leaderSpeed)
+}
+
+// turnLane returns the lane the vehicle wants to be in for its next turn, or
+// -1 if it is still too far from the end of its edge or drives straight on
+func (m *MOBIL) turnLane(v *Vehicle, lanes int) int {
+	idx, position := v.deductCurrentPathVertexIndex()
+	if idx >= len(v.Path)-2 || v.PathLengths[idx]-position > m.TurnDistance {
+		return -1
+	}
+
+	var coords [3]JVertex
+	for i := range coords {
+		vertex, err := (*v.g).Vertex(v.Path[idx+i])
+		if err != nil {
+			return -1
+		}
+		coords[i] = vertex
+	}
+
+	switch turn(coords[0], coords[1], coords[2]) {
+	case TurnRight:
+		return 0
+	case TurnLeft:
+		return lanes - 1
+	default:
+		return -1
+	}
+}
+
+// ChangeLane moves the vehicle to the adjacent lane with the largest
+// incentive if it is safe, and returns if it changed its lane
+func (m *MOBIL) ChangeLane(v *Vehicle) bool {
+	lanes := v.lanes()
+	if v.IsParked || lanes < 2 {
+		return false
+	}
+
+	// the situation in the current lane
+	leader, gap := v.leaderIn(v.Lane, leaderLookahead)
+	current := m.acceleration(v, leader, gap)
+	oldFollower, oldGap := v.followerIn(v.Lane)
+	oldFollowerGain := 0.0
+	if oldFollower != nil {
+		// the old follower closes up to the leader
+		oldFollowerGain = m.acceleration(oldFollower, leader, oldGap+VehicleLength+gap) -
+			m.acceleration(oldFollower, v, oldGap)
+	}
+	target := m.turnLane(v, lanes)
+
+	best, bestIncentive := -1, m.Threshold
+	for _, lane := range []int{v.Lane - 1, v.Lane + 1} {
+		if lane < 0 || lane >= lanes {
+			continue
+		}
+
+		newLeader, newGap := v.leaderIn(lane, leaderLookahead)
+		newFollower, followerGap := v.followerIn(lane)
+		if newGap <= 0 || followerGap < 0 {
+			continue
+		}
+
+		newFollowerGain := 0.0
+		if newFollower != nil {
+			after := m.acceleration(newFollower, v, followerGap)
+			if after < -m.SafeDeceleration {
+				continue
+			}
+			newFollowerGain = after - m.acceleration(newFollower, newLeader, followerGap+VehicleLength+newGap)
+		}
+
+		incentive := m.acceleration(v, newLeader, newGap) - current +
+			m.Politeness*(newFollowerGain+oldFollowerGain)
+		if target >= 0 {
+			if math.Abs(float64(target-lane)) < math.Abs(float64(target-v.Lane)) {
+				incentive += m.TurnBias
+			} else {
+				incentive -= m.TurnBias
+			}
+		}
+
+		if incentive > bestIncentive {
+			best, bestIncentive = lane, incentive
+		}
+	}
+
+	if best < 0 {
+		return false
+	}
+	log.Debug().Msgf("Vehicle %s changes from lane %d to %d.", v.ID, v.Lane, best)
+	v.Lane = best
+	return true
+}
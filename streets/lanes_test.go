@@ -0,0 +1,100 @@
+package streets
+
+import (
+	"testing"
+
+	"pchpc/utils"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// dawdler keeps vehicles at or below slow m/s at their speed, every other
+// vehicle follows the IDM
+type dawdler struct {
+	slow float64
+}
+
+func (m dawdler) NextSpeed(speed, desired, gap, leaderSpeed, dt float64, rng *utils.RNG) float64 {
+	if speed <= m.slow {
+		return speed
+	}
+	return NewIDM().NextSpeed(speed, desired, gap, leaderSpeed, dt, rng)
+}
+
+func TestDefaultLanes(t *testing.T) {
+	assert.Equal(t, 1, DefaultLanes(50))
+	assert.Equal(t, 2, DefaultLanes(70))
+	assert.Equal(t, 2, DefaultLanes(130))
+
+	// the builder falls back to the default of the speed limit
+	g := buildCrossing(t, "70")
+	for _, c := range []struct {
+		from, to, lanes int
+	}{
+		{crossingWest, crossingCenter, 2},
+		{crossingNorth, crossingCenter, 1},
+	} {
+		edge, err := g.Graph.Edge(c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.lanes, edge.Properties.Data.(Data).Lanes)
+	}
+}
+
+func TestEngine_Overtakes(t *testing.T) {
+	setupLogger(t)
+	g, err := NewGraphBuilder().
+		WithVertices([]JVertex{{ID: 1, X: 10, Y: 50}, {ID: 2, X: 10.01, Y: 50}}).
+		WithEdges([]JEdge{{From: 1, To: 2, Length: 1000, MaxSpeed: "80", Lanes: 2, ID: "1-2"}}).
+		WithRectangleParts(1).SetTopRightBottomLeftVertices().DivideGraphsIntoRects().
+		PickRect(0).FilterForRect().IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewEngine(0.5)
+	engine.Model = dawdler{slow: 5}
+	slow := NewVehicle("slow", 5, []int{1, 2}, &g.Graph)
+	slow.DistanceTravelled = 60
+	fast := NewVehicle("fast", 20, []int{1, 2}, &g.Graph)
+	engine.Add(&slow)
+	engine.Add(&fast)
+
+	arrived := make(map[string]int)
+	for engine.Len() > 0 && engine.Tick < 1000 {
+		for _, v := range engine.Step() {
+			arrived[v.ID] = engine.Tick
+		}
+	}
+
+	assert.True(t, engine.LaneChanges > 0)
+	assert.True(t, arrived["fast"] > 0)
+	assert.True(t, arrived["fast"] < arrived["slow"])
+}
+
+func TestMOBIL_TurnLane(t *testing.T) {
+	setupLogger(t)
+	for _, c := range []struct {
+		to         int
+		lane, want int
+	}{
+		// turning left, the vehicle moves to the left lane
+		{crossingNorth, 0, 1},
+		// turning right, to the right one
+		{crossingSouth, 1, 0},
+		// going straight on, it stays in its lane
+		{crossingEast, 0, 0},
+		{crossingEast, 1, 1},
+	} {
+		g := buildCrossing(t, "70")
+		v := NewVehicle("1", 10, []int{crossingWest, crossingCenter, c.to}, &g.Graph)
+		v.DistanceTravelled = 20
+		v.Lane = c.lane
+
+		engine := NewEngine(0.5)
+		engine.Add(&v)
+		engine.Step()
+		assert.Equal(t, c.want, v.Lane)
+	}
+}
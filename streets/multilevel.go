@@ -0,0 +1,520 @@
+package streets
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// coarsenTo is the number of vertices below which a graph is not coarsened any further
+	coarsenTo = 40
+	// initialTries is the number of grown initial bisections of the coarsest graph
+	initialTries = 4
+	// refinePasses is the maximum number of refinement passes per level
+	refinePasses = 8
+	// refineStall stops a refinement pass after this many moves without an improvement
+	refineStall = 50
+)
+
+// MultilevelPartitioner divides the graph into parts with few streets between
+// them. The graph is coarsened by heavy-edge matching, the coarsest graph is
+// bisected and the bisection is refined by Fiduccia–Mattheyses moves while it
+// is projected back to the original graph. More than two parts are created by
+// recursive bisection.
+//
+// The parts are returned as rects holding the vertices of a part, their
+// corners are the bounding box of the vertices and may overlap.
+type MultilevelPartitioner struct {
+	// Imbalance is the allowed ratio of the length of the streets in a part
+	// to its share of the total length, e.g. 1.03
+	Imbalance float64
+	// Seed seeds the random visiting order of the matching
+	Seed int64
+}
+
+// NewMultilevelPartitioner returns a multilevel partitioner allowing an imbalance of 3 %
+func NewMultilevelPartitioner() *MultilevelPartitioner {
+	return &MultilevelPartitioner{
+		Imbalance: 1.03,
+		Seed:      1,
+	}
+}
+
+// Partition divides the vertices into n parts, minimising the edge cut
+func (p *MultilevelPartitioner) Partition(vertices []JVertex, edges []JEdge, n int) ([]Rect, error) {
+	vertices = uniqueVertices(vertices)
+	assignment, err := p.Assign(vertices, edges, n)
+	if err != nil {
+		return nil, err
+	}
+	return RectsFromAssignment(vertices, assignment, n), nil
+}
+
+// Assign maps every vertex ID to its part in [0, n). A vertex is weighted with
+// the length of its outgoing edges, as an edge belongs to the part of its source.
+func (p *MultilevelPartitioner) Assign(vertices []JVertex, edges []JEdge, n int) (map[int]int, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of partitions %d", n)
+	}
+
+	vertices = uniqueVertices(vertices)
+	sort.Slice(vertices, func(i, j int) bool { return vertices[i].ID < vertices[j].ID })
+	index := make(map[int]int, len(vertices))
+	for i, v := range vertices {
+		index[v.ID] = i
+	}
+
+	weights := make([]float64, len(vertices))
+	total := 0.0
+	links := make(map[[2]int]float64)
+	for _, e := range edges {
+		from, okFrom := index[e.From]
+		to, okTo := index[e.To]
+		if !okFrom || !okTo {
+			continue
+		}
+		weights[from] += e.Length
+		total += e.Length
+		if from == to {
+			continue
+		}
+		// a street in both directions weighs twice
+		links[[2]int{from, to}]++
+		links[[2]int{to, from}]++
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	g := newWeightedGraph(weights, links)
+	parts := make([]int, len(vertices))
+	nodes := make([]int, len(vertices))
+	for i := range nodes {
+		nodes[i] = i
+	}
+
+	rng := rand.New(rand.NewSource(p.Seed))
+	p.assign(g, nodes, n, 0, parts, rng)
+
+	assignment := make(map[int]int, len(vertices))
+	for i, v := range vertices {
+		assignment[v.ID] = parts[i]
+	}
+	return assignment, nil
+}
+
+// assign divides the nodes of g into the parts offset to offset+n-1 by recursive bisection
+func (p *MultilevelPartitioner) assign(g *weightedGraph, nodes []int, n, offset int, parts []int, rng *rand.Rand) {
+	if n == 1 || len(nodes) == 0 {
+		for _, v := range nodes {
+			parts[v] = offset
+		}
+		return
+	}
+
+	nLow := n / 2
+	sub := g.subgraph(nodes)
+	side := p.bisect(sub, float64(nLow)/float64(n), rng)
+
+	low, high := make([]int, 0), make([]int, 0)
+	for i, v := range nodes {
+		if side[i] == 0 {
+			low = append(low, v)
+		} else {
+			high = append(high, v)
+		}
+	}
+
+	p.assign(g, low, nLow, offset, parts, rng)
+	p.assign(g, high, n-nLow, offset+nLow, parts, rng)
+}
+
+// coarseLevel is a graph together with the mapping of its nodes to the next coarser graph
+type coarseLevel struct {
+	g       *weightedGraph
+	mapping []int
+}
+
+// bisect divides g into side 0 holding fraction of the weight and side 1
+func (p *MultilevelPartitioner) bisect(g *weightedGraph, fraction float64, rng *rand.Rand) []int {
+	levels := make([]coarseLevel, 0)
+	current := g
+	for current.len() > coarsenTo {
+		coarse, mapping := current.coarsen(rng)
+		if coarse.len() > current.len()*95/100 {
+			break
+		}
+		levels = append(levels, coarseLevel{g: current, mapping: mapping})
+		current = coarse
+	}
+
+	side := p.initialBisection(current, fraction, rng)
+
+	for i := len(levels) - 1; i >= 0; i-- {
+		level := levels[i]
+		fine := make([]int, level.g.len())
+		for v := range fine {
+			fine[v] = side[level.mapping[v]]
+		}
+		side = fine
+		p.refine(level.g, side, fraction)
+	}
+
+	return side
+}
+
+// initialBisection grows side 0 from random nodes in breadth-first order and
+// keeps the refined bisection with the smallest cut
+func (p *MultilevelPartitioner) initialBisection(g *weightedGraph, fraction float64, rng *rand.Rand) []int {
+	var best []int
+	var bestState partitionState
+
+	for try := 0; try < initialTries && g.len() > 0; try++ {
+		side := make([]int, g.len())
+		for i := range side {
+			side[i] = 1
+		}
+
+		target := fraction * g.total
+		grown := 0.0
+		queue := []int{rng.Intn(g.len())}
+		next := 0
+		for grown < target {
+			if len(queue) == 0 {
+				// disconnected graph, continue with the next node of side 1
+				for next < len(side) && side[next] == 0 {
+					next++
+				}
+				if next == len(side) {
+					break
+				}
+				queue = append(queue, next)
+			}
+
+			v := queue[0]
+			queue = queue[1:]
+			if side[v] == 0 {
+				continue
+			}
+			side[v] = 0
+			grown += g.weights[v]
+			for _, nb := range g.adj[v] {
+				if side[nb.node] == 1 {
+					queue = append(queue, nb.node)
+				}
+			}
+		}
+
+		p.refine(g, side, fraction)
+		state := p.state(g, side, fraction)
+		if best == nil || state.better(bestState) {
+			best, bestState = side, state
+		}
+	}
+
+	return best
+}
+
+// partitionState is the quality of a bisection
+type partitionState struct {
+	cut       float64
+	imbalance float64
+	balanced  bool
+}
+
+// better prefers balanced bisections with a smaller cut, then a smaller imbalance
+func (s partitionState) better(o partitionState) bool {
+	if s.balanced != o.balanced {
+		return s.balanced
+	}
+	if !s.balanced && s.imbalance != o.imbalance {
+		return s.imbalance < o.imbalance
+	}
+	return s.cut < o.cut
+}
+
+// state measures the bisection
+func (p *MultilevelPartitioner) state(g *weightedGraph, side []int, fraction float64) partitionState {
+	var sideWeights [2]float64
+	cut := 0.0
+	for v, s := range side {
+		sideWeights[s] += g.weights[v]
+		for _, nb := range g.adj[v] {
+			if side[nb.node] != s {
+				cut += nb.weight
+			}
+		}
+	}
+	return p.measure(g, sideWeights, cut/2, fraction)
+}
+
+// measure returns the state of a bisection with the given side weights and cut
+func (p *MultilevelPartitioner) measure(g *weightedGraph, sideWeights [2]float64, cut, fraction float64) partitionState {
+	imbalance := 0.0
+	for s, share := range [2]float64{fraction, 1 - fraction} {
+		if target := share * g.total; target > 0 {
+			imbalance = math.Max(imbalance, sideWeights[s]/target)
+		}
+	}
+	return partitionState{
+		cut:       cut,
+		imbalance: imbalance,
+		balanced:  imbalance <= p.Imbalance,
+	}
+}
+
+// refine improves the bisection with Fiduccia–Mattheyses passes. Every pass
+// moves boundary nodes with the highest gain once and rolls back to the best
+// bisection seen.
+func (p *MultilevelPartitioner) refine(g *weightedGraph, side []int, fraction float64) {
+	for pass := 0; pass < refinePasses; pass++ {
+		if !p.refinePass(g, side, fraction) {
+			return
+		}
+	}
+}
+
+// refinePass runs a single Fiduccia–Mattheyses pass and reports whether it improved the bisection
+func (p *MultilevelPartitioner) refinePass(g *weightedGraph, side []int, fraction float64) bool {
+	n := g.len()
+	gains := make([]float64, n)
+	stamps := make([]int, n)
+	locked := make([]bool, n)
+	var sideWeights [2]float64
+	cut := 0.0
+	queue := &gainQueue{}
+
+	for v := 0; v < n; v++ {
+		sideWeights[side[v]] += g.weights[v]
+		boundary := false
+		for _, nb := range g.adj[v] {
+			if side[nb.node] != side[v] {
+				gains[v] += nb.weight
+				cut += nb.weight
+				boundary = true
+			} else {
+				gains[v] -= nb.weight
+			}
+		}
+		if boundary {
+			heap.Push(queue, gainEntry{gain: gains[v], node: v})
+		}
+	}
+	cut /= 2
+
+	start := p.measure(g, sideWeights, cut, fraction)
+	best, bestMoves := start, 0
+	moves := make([]int, 0)
+
+	for queue.Len() > 0 && len(moves)-bestMoves < refineStall {
+		entry := heap.Pop(queue).(gainEntry)
+		v := entry.node
+		if locked[v] || entry.stamp != stamps[v] {
+			continue
+		}
+
+		from, to := side[v], 1-side[v]
+		moved := sideWeights
+		moved[from] -= g.weights[v]
+		moved[to] += g.weights[v]
+		current := p.measure(g, sideWeights, cut, fraction)
+		next := p.measure(g, moved, cut-gains[v], fraction)
+		// moves may not unbalance the bisection, but may repair it
+		if !next.balanced && next.imbalance > current.imbalance {
+			continue
+		}
+
+		side[v] = to
+		locked[v] = true
+		sideWeights = moved
+		cut -= gains[v]
+		moves = append(moves, v)
+
+		for _, nb := range g.adj[v] {
+			u := nb.node
+			if side[u] == to {
+				gains[u] -= 2 * nb.weight
+			} else {
+				gains[u] += 2 * nb.weight
+			}
+			if !locked[u] {
+				stamps[u]++
+				heap.Push(queue, gainEntry{gain: gains[u], node: u, stamp: stamps[u]})
+			}
+		}
+
+		if next.better(best) {
+			best, bestMoves = next, len(moves)
+		}
+	}
+
+	// roll back the moves after the best bisection
+	for _, v := range moves[bestMoves:] {
+		side[v] = 1 - side[v]
+	}
+
+	return bestMoves > 0
+}
+
+// neighbour is an adjacent node and the weight of the link to it
+type neighbour struct {
+	node   int
+	weight float64
+}
+
+// weightedGraph is an undirected graph with weighted nodes and links
+type weightedGraph struct {
+	weights []float64
+	adj     [][]neighbour
+	total   float64
+}
+
+// newWeightedGraph creates a graph from the node weights and the links in both directions
+func newWeightedGraph(weights []float64, links map[[2]int]float64) *weightedGraph {
+	g := &weightedGraph{
+		weights: weights,
+		adj:     make([][]neighbour, len(weights)),
+	}
+	for _, w := range weights {
+		g.total += w
+	}
+	for link, w := range links {
+		g.adj[link[0]] = append(g.adj[link[0]], neighbour{node: link[1], weight: w})
+	}
+	// sorted neighbours keep the partition deterministic
+	for _, nbs := range g.adj {
+		sort.Slice(nbs, func(i, j int) bool { return nbs[i].node < nbs[j].node })
+	}
+	return g
+}
+
+// len returns the number of nodes
+func (g *weightedGraph) len() int {
+	return len(g.weights)
+}
+
+// subgraph returns the graph induced by the ascending nodes
+func (g *weightedGraph) subgraph(nodes []int) *weightedGraph {
+	local := make(map[int]int, len(nodes))
+	for i, v := range nodes {
+		local[v] = i
+	}
+
+	weights := make([]float64, len(nodes))
+	links := make(map[[2]int]float64)
+	for i, v := range nodes {
+		weights[i] = g.weights[v]
+		for _, nb := range g.adj[v] {
+			if j, ok := local[nb.node]; ok {
+				links[[2]int{i, j}] = nb.weight
+			}
+		}
+	}
+	return newWeightedGraph(weights, links)
+}
+
+// coarsen matches every node with the unmatched neighbour it shares the
+// heaviest link with and merges the pairs. It returns the coarse graph and
+// the coarse node of every node.
+func (g *weightedGraph) coarsen(rng *rand.Rand) (*weightedGraph, []int) {
+	match := make([]int, g.len())
+	for i := range match {
+		match[i] = -1
+	}
+
+	for _, v := range rng.Perm(g.len()) {
+		if match[v] >= 0 {
+			continue
+		}
+		match[v] = v
+		heaviest := 0.0
+		for _, nb := range g.adj[v] {
+			if match[nb.node] < 0 && nb.weight > heaviest {
+				match[v], heaviest = nb.node, nb.weight
+			}
+		}
+		match[match[v]] = v
+	}
+
+	mapping := make([]int, g.len())
+	for i := range mapping {
+		mapping[i] = -1
+	}
+	weights := make([]float64, 0)
+	for v := range mapping {
+		if mapping[v] >= 0 {
+			continue
+		}
+		mapping[v] = len(weights)
+		weight := g.weights[v]
+		if u := match[v]; u != v {
+			mapping[u] = len(weights)
+			weight += g.weights[u]
+		}
+		weights = append(weights, weight)
+	}
+
+	links := make(map[[2]int]float64)
+	for v, nbs := range g.adj {
+		for _, nb := range nbs {
+			if cv, cu := mapping[v], mapping[nb.node]; cv != cu {
+				links[[2]int{cv, cu}] += nb.weight
+			}
+		}
+	}
+
+	return newWeightedGraph(weights, links), mapping
+}
+
+// gainEntry is a node in the gain queue, outdated if its stamp is behind the node's
+type gainEntry struct {
+	gain  float64
+	node  int
+	stamp int
+}
+
+// gainQueue is a max-heap of nodes by gain
+type gainQueue []gainEntry
+
+func (q gainQueue) Len() int { return len(q) }
+
+func (q gainQueue) Less(i, j int) bool {
+	if q[i].gain != q[j].gain {
+		return q[i].gain > q[j].gain
+	}
+	return q[i].node < q[j].node
+}
+
+func (q gainQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *gainQueue) Push(x any) { *q = append(*q, x.(gainEntry)) }
+
+func (q *gainQueue) Pop() any {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// RectsFromAssignment groups the vertices by their part into n rects, spanning
+// the bounding box of their vertices
+func RectsFromAssignment(vertices []JVertex, assignment map[int]int, n int) []Rect {
+	rects := make([]Rect, n)
+	for i := range rects {
+		rects[i].Vertices = make([]JVertex, 0)
+	}
+	for _, v := range uniqueVertices(vertices) {
+		if part, ok := assignment[v.ID]; ok && part >= 0 && part < n {
+			rects[part].Vertices = append(rects[part].Vertices, v)
+		}
+	}
+	for i := range rects {
+		rects[i].BotLeft, rects[i].TopRight = bounds(rects[i].Vertices)
+	}
+	return rects
+}
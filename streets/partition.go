@@ -0,0 +1,264 @@
+package streets
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Partitioner divides the vertices of a graph into rects, one per rank
+type Partitioner interface {
+	// Partition divides the vertices into n rects, every vertex is held by
+	// exactly one of them. An edge belongs to the rect holding its source.
+	Partition(vertices []JVertex, edges []JEdge, n int) ([]Rect, error)
+}
+
+// NewPartitioner returns the partitioner with the given name, "strips", "rcb",
+// "length" or "multilevel"
+func NewPartitioner(name string) (Partitioner, error) {
+	switch name {
+	case "", "strips":
+		return &StripPartitioner{}, nil
+	case "rcb":
+		return &BisectionPartitioner{}, nil
+	case "length":
+		return &BisectionPartitioner{ByEdgeLength: true}, nil
+	case "multilevel":
+		return NewMultilevelPartitioner(), nil
+	default:
+		return nil, fmt.Errorf("unknown partitioner %q", name)
+	}
+}
+
+// StripPartitioner divides the bounding box into vertical strips of equal
+// width. Vertices on a shared border belong to the right-hand strip.
+type StripPartitioner struct{}
+
+// Partition divides the vertices column-wise into n strips
+func (p *StripPartitioner) Partition(vertices []JVertex, _ []JEdge, n int) ([]Rect, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of partitions %d", n)
+	}
+
+	vertices = uniqueVertices(vertices)
+	bot, top := bounds(vertices)
+	xDelta := top.X - bot.X
+
+	rects := make([]Rect, n)
+	for i := 0; i < n; i++ {
+		botX := bot.X + (xDelta/float64(n))*float64(i)
+		topX := bot.X + (xDelta/float64(n))*float64(i+1)
+
+		rects[i] = Rect{
+			TopRight: point{X: topX, Y: top.Y},
+			BotLeft:  point{X: botX, Y: bot.Y},
+			Vertices: make([]JVertex, 0),
+		}
+
+		for _, vertex := range vertices {
+			// half-open intervals, so that every vertex is owned by exactly one rect
+			if vertex.X >= botX && (vertex.X < topX || i == n-1) {
+				rects[i].Vertices = append(rects[i].Vertices, vertex)
+			}
+		}
+	}
+
+	return rects, nil
+}
+
+// BisectionPartitioner is a recursive coordinate bisection. The vertices are
+// split at their median, alternating between X and Y, until there is a rect
+// for every rank.
+type BisectionPartitioner struct {
+	// ByEdgeLength weights every vertex with the length of its outgoing edges,
+	// so the rects hold the same length of streets instead of the same number
+	// of vertices
+	ByEdgeLength bool
+}
+
+// Partition divides the vertices into n rects by recursive coordinate bisection
+func (p *BisectionPartitioner) Partition(vertices []JVertex, edges []JEdge, n int) ([]Rect, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid number of partitions %d", n)
+	}
+
+	vertices = uniqueVertices(vertices)
+	weights := make(map[int]float64, len(vertices))
+	for _, v := range vertices {
+		weights[v.ID] = 1
+	}
+	if p.ByEdgeLength {
+		for _, v := range vertices {
+			weights[v.ID] = 0
+		}
+		for _, e := range edges {
+			weights[e.From] += e.Length
+		}
+	}
+
+	bot, top := bounds(vertices)
+	return bisect(vertices, weights, n, false, bot, top), nil
+}
+
+// bisect splits the vertices inside bot and top into n rects, along Y if
+// alongY is set. The first half of the rects gets its share of the weights.
+func bisect(vertices []JVertex, weights map[int]float64, n int, alongY bool, bot, top point) []Rect {
+	if n == 1 {
+		return []Rect{{TopRight: top, BotLeft: bot, Vertices: vertices}}
+	}
+
+	coord := func(v JVertex) float64 {
+		if alongY {
+			return v.Y
+		}
+		return v.X
+	}
+	sort.Slice(vertices, func(i, j int) bool {
+		ci, cj := coord(vertices[i]), coord(vertices[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return vertices[i].ID < vertices[j].ID
+	})
+
+	total := 0.0
+	for _, v := range vertices {
+		total += weights[v.ID]
+	}
+
+	// cut where the weight of the lower part is closest to its share
+	nLow := n / 2
+	target := total * float64(nLow) / float64(n)
+	cut, sum, best := 0, 0.0, math.Abs(target)
+	for i, v := range vertices {
+		sum += weights[v.ID]
+		if d := math.Abs(target - sum); d < best {
+			cut, best = i+1, d
+		}
+	}
+
+	// the border runs between the last vertex of the lower and the first of the upper part
+	var border float64
+	switch {
+	case cut == 0:
+		border = axisValue(bot, alongY)
+	case cut == len(vertices):
+		border = axisValue(top, alongY)
+	default:
+		border = (coord(vertices[cut-1]) + coord(vertices[cut])) / 2
+	}
+
+	lowTop, highBot := top, bot
+	if alongY {
+		lowTop.Y, highBot.Y = border, border
+	} else {
+		lowTop.X, highBot.X = border, border
+	}
+
+	low := make([]JVertex, cut)
+	copy(low, vertices[:cut])
+	high := make([]JVertex, len(vertices)-cut)
+	copy(high, vertices[cut:])
+
+	rects := bisect(low, weights, nLow, !alongY, bot, lowTop)
+	return append(rects, bisect(high, weights, n-nLow, !alongY, highBot, top)...)
+}
+
+// axisValue returns the coordinate of the point along the split axis
+func axisValue(p point, alongY bool) float64 {
+	if alongY {
+		return p.Y
+	}
+	return p.X
+}
+
+// uniqueVertices returns the vertices without duplicate IDs, in their original order
+func uniqueVertices(vertices []JVertex) []JVertex {
+	seen := make(map[int]bool, len(vertices))
+	unique := make([]JVertex, 0, len(vertices))
+	for _, v := range vertices {
+		if seen[v.ID] {
+			continue
+		}
+		seen[v.ID] = true
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+// bounds returns the bottom left and top right corners of the bounding box of the vertices
+func bounds(vertices []JVertex) (bot, top point) {
+	if len(vertices) == 0 {
+		return point{}, point{}
+	}
+
+	bot = point{X: math.Inf(1), Y: math.Inf(1)}
+	top = point{X: math.Inf(-1), Y: math.Inf(-1)}
+	for _, v := range vertices {
+		bot.X, bot.Y = math.Min(bot.X, v.X), math.Min(bot.Y, v.Y)
+		top.X, top.Y = math.Max(top.X, v.X), math.Max(top.Y, v.Y)
+	}
+	return bot, top
+}
+
+// PartitionReport describes the sizes of the rects a graph is divided into
+type PartitionReport struct {
+	// Vertices is the number of vertices per rect
+	Vertices []int
+	// Edges is the number of edges per rect
+	Edges []int
+	// Length is the length of the edges per rect in metres
+	Length []float64
+	// Imbalance is the largest length of a rect divided by the mean length,
+	// 1 for a perfectly balanced partition
+	Imbalance float64
+	// EdgeCut is the number of edges between two rects
+	EdgeCut int
+}
+
+// NewPartitionReport measures the rects, counting every edge for the rect holding its source
+func NewPartitionReport(rects []Rect, edges []JEdge) PartitionReport {
+	r := PartitionReport{
+		Vertices: make([]int, len(rects)),
+		Edges:    make([]int, len(rects)),
+		Length:   make([]float64, len(rects)),
+	}
+
+	owners := VertexOwners(rects)
+	for i, rect := range rects {
+		r.Vertices[i] = len(rect.Vertices)
+	}
+	for _, e := range edges {
+		owner, ok := owners[e.From]
+		if !ok {
+			continue
+		}
+		r.Edges[owner]++
+		r.Length[owner] += e.Length
+		if target, ok := owners[e.To]; ok && target != owner {
+			r.EdgeCut++
+		}
+	}
+
+	total, largest := 0.0, 0.0
+	for _, l := range r.Length {
+		total += l
+		largest = math.Max(largest, l)
+	}
+	if total > 0 {
+		r.Imbalance = largest / (total / float64(len(rects)))
+	}
+
+	return r
+}
+
+// String returns one line per rect, the imbalance and the edge cut
+func (r PartitionReport) String() string {
+	var sb strings.Builder
+	for i := range r.Vertices {
+		fmt.Fprintf(&sb, "rect %d: %d vertices, %d edges, %.0f m\n", i, r.Vertices[i], r.Edges[i], r.Length[i])
+	}
+	fmt.Fprintf(&sb, "imbalance: %.3f, edge cut: %d", r.Imbalance, r.EdgeCut)
+	return sb.String()
+}
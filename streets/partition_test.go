@@ -0,0 +1,154 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+func TestPartitioners(t *testing.T) {
+	root, _ := DefaultGraph(graphFile, 1)
+	vertices, err := root.GetVertices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"strips", "rcb", "length", "multilevel"} {
+		p, err := NewPartitioner(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rects, err := root.Partition(p, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 5, len(rects))
+
+		// every vertex is owned by exactly one rect
+		owned := make(map[int]int)
+		for _, r := range rects {
+			for _, v := range r.Vertices {
+				owned[v.ID]++
+			}
+		}
+		assert.Equal(t, len(vertices), len(owned))
+		for _, n := range owned {
+			assert.Equal(t, 1, n)
+		}
+	}
+}
+
+func TestBisectionPartitioner_Balance(t *testing.T) {
+	root, _ := DefaultGraph(graphFile, 1)
+
+	rects, err := root.Partition(&BisectionPartitioner{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the median splits the vertices evenly
+	minSize, maxSize := len(rects[0].Vertices), len(rects[0].Vertices)
+	for _, r := range rects {
+		if len(r.Vertices) < minSize {
+			minSize = len(r.Vertices)
+		}
+		if len(r.Vertices) > maxSize {
+			maxSize = len(r.Vertices)
+		}
+	}
+	assert.True(t, maxSize-minSize <= 1)
+
+	// vertices lie inside their rect
+	for _, r := range rects {
+		for _, v := range r.Vertices {
+			assert.True(t, v.X >= r.BotLeft.X && v.X <= r.TopRight.X)
+			assert.True(t, v.Y >= r.BotLeft.Y && v.Y <= r.TopRight.Y)
+		}
+	}
+}
+
+func TestBisectionPartitioner_ByEdgeLength(t *testing.T) {
+	root, _ := DefaultGraph(graphFile, 1)
+
+	strips, err := root.DivideIntoRects(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balanced, err := root.Partition(&BisectionPartitioner{ByEdgeLength: true}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripsReport, err := root.PartitionReport(strips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancedReport, err := root.PartitionReport(balanced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("strips:\n%s\nlength:\n%s", stripsReport, balancedReport)
+
+	assert.True(t, balancedReport.Imbalance < stripsReport.Imbalance)
+	assert.True(t, balancedReport.Imbalance < 1.1)
+}
+
+func TestMultilevelPartitioner(t *testing.T) {
+	root, _ := DefaultGraph(graphFile, 1)
+
+	strips, err := root.DivideIntoRects(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multilevel, err := root.Partition(NewMultilevelPartitioner(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripsReport, err := root.PartitionReport(strips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multilevelReport, err := root.PartitionReport(multilevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("strips:\n%s\nmultilevel:\n%s", stripsReport, multilevelReport)
+
+	assert.True(t, multilevelReport.EdgeCut < stripsReport.EdgeCut)
+	assert.True(t, multilevelReport.Imbalance < 1.1)
+
+	// the partition only depends on the seed
+	again, err := root.Partition(NewMultilevelPartitioner(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, VertexOwners(multilevel), VertexOwners(again))
+}
+
+func TestMultilevelPartitioner_Assign(t *testing.T) {
+	// two triangles joined by a single street
+	vertices := []JVertex{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}, {ID: 5}, {ID: 6}}
+	edges := []JEdge{
+		{From: 1, To: 2, Length: 10},
+		{From: 2, To: 3, Length: 10},
+		{From: 3, To: 1, Length: 10},
+		{From: 4, To: 5, Length: 10},
+		{From: 5, To: 6, Length: 10},
+		{From: 6, To: 4, Length: 10},
+		{From: 3, To: 4, Length: 10},
+		{From: 4, To: 3, Length: 10},
+	}
+
+	assignment, err := NewMultilevelPartitioner().Assign(vertices, edges, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, assignment[1], assignment[2])
+	assert.Equal(t, assignment[1], assignment[3])
+	assert.Equal(t, assignment[4], assignment[5])
+	assert.Equal(t, assignment[4], assignment[6])
+	assert.True(t, assignment[1] != assignment[4])
+}
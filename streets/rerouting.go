@@ -0,0 +1,251 @@
+package streets
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/dominikbraun/graph"
+	"github.com/rs/zerolog/log"
+)
+
+// crawlSpeed is the speed in m/s vehicles are assumed to pass a jammed edge
+// with, so a queue standing still has a finite travel time
+const crawlSpeed = 1.0
+
+// TravelTime returns the current travel time of the edge in seconds, its
+// length at the mean speed of the vehicles on it, or at the speed limit if
+// it is empty
+func (d Data) TravelTime() float64 {
+	speed := math.Max(crawlSpeed, d.MaxSpeed/3.6)
+	if d.Map != nil && d.Map.Len() > 0 {
+		sum := 0.0
+		vehicles := d.Map.ToList()
+		for _, v := range vehicles {
+			sum += v.Speed
+		}
+		speed = math.Min(speed, math.Max(crawlSpeed, sum/float64(len(vehicles))))
+	}
+	return d.Length / speed
+}
+
+// Rerouting lets a share of the vehicles reconsider the rest of their route
+// every Interval seconds, by the current travel times of the edges. A vehicle
+// keeps its current edge and switches to the fastest route from its end if
+// that saves enough time. Which vehicles reroute and when is derived from
+// their IDs, so it does not depend on the number of ranks.
+type Rerouting struct {
+	// Share is the share of the vehicles able to reroute, from 0 to 1
+	Share float64
+	// Interval is the time in seconds between two reroutes of a vehicle
+	Interval float64
+	// Threshold is the share of the travel time of its route a vehicle has
+	// to save to switch, which keeps vehicles from flapping between routes
+	Threshold float64
+}
+
+// NewRerouting returns the rerouting of the given share of the vehicles every
+// minute
+func NewRerouting(share float64) *Rerouting {
+	return &Rerouting{Share: share, Interval: 60, Threshold: 0.1}
+}
+
+// reroute reroutes the vehicles due in the tick of dt seconds and returns the
+// number of vehicles which switched their route
+func (r *Rerouting) reroute(vehicles []*Vehicle, tick int, dt float64) int {
+	period := uint64(math.Max(1, math.Round(r.Interval/dt)))
+	// the travel times of the graphs the vehicles drive on, read once per tick
+	times := make(map[*graph.Graph[int, JVertex]]map[int][]timedArc)
+
+	switched := 0
+	for _, v := range vehicles {
+		hash := fnv.New64a()
+		hash.Write([]byte(v.ID))
+		h := hash.Sum64()
+		// the high bits decide if the vehicle reroutes, the low ones when
+		if float64(h>>11)/(1<<53) >= r.Share || (uint64(tick)+h)%period != 0 {
+			continue
+		}
+
+		arcs, ok := times[v.g]
+		if !ok {
+			var err error
+			if arcs, err = travelTimes(*v.g); err != nil {
+				log.Error().Err(err).Msg("Failed to read travel times.")
+				return switched
+			}
+			times[v.g] = arcs
+		}
+		if v.reroute(arcs, r.Threshold) {
+			switched++
+		}
+	}
+	return switched
+}
+
+// timedArc is an outgoing edge with its current travel time
+type timedArc struct {
+	target int
+	time   float64
+}
+
+// travelTimes returns the outgoing edges of every vertex of the graph with
+// their current travel time, sorted by target
+func travelTimes(g graph.Graph[int, JVertex]) (map[int][]timedArc, error) {
+	edges, err := g.Edges()
+	if err != nil {
+		return nil, err
+	}
+
+	arcs := make(map[int][]timedArc)
+	for _, e := range edges {
+		data, ok := e.Properties.Data.(Data)
+		if !ok {
+			continue
+		}
+		arcs[e.Source] = append(arcs[e.Source], timedArc{target: e.Target, time: data.TravelTime()})
+	}
+	for _, a := range arcs {
+		sort.Slice(a, func(i, j int) bool { return a[i].target < a[j].target })
+	}
+	return arcs, nil
+}
+
+// reroute replaces the part of the vehicle's path after its current edge,
+// up to the last vertex of the path in its graph, by the fastest route by
+// the travel times if it is faster by the threshold. The lengths of the path
+// are spliced, so the distance travelled stays valid. It reports if the
+// vehicle switched its route.
+func (v *Vehicle) reroute(arcs map[int][]timedArc, threshold float64) bool {
+	if v.IsParked {
+		return false
+	}
+	idx, _ := v.deductCurrentPathVertexIndex()
+	from, to := idx+1, idx+1
+	for v.seesEdgeByIndex(to) {
+		to++
+	}
+	if to-from < 1 {
+		return false
+	}
+
+	current := 0.0
+	for i := from; i < to; i++ {
+		current += arcTime(arcs, v.Path[i], v.Path[i+1])
+	}
+	route, lengths, time := fastestRoute(arcs, v.Path[from], v.Path[to], *v.g)
+	if route == nil || time >= current*(1-threshold) {
+		return false
+	}
+
+	path := make([]int, 0, from+len(route)+len(v.Path)-to)
+	path = append(path, v.Path[:from]...)
+	path = append(path, route...)
+	path = append(path, v.Path[to+1:]...)
+
+	pathLengths := make([]float64, 0, len(path)-1)
+	pathLengths = append(pathLengths, v.PathLengths[:from]...)
+	pathLengths = append(pathLengths, lengths...)
+	pathLengths = append(pathLengths, v.PathLengths[to:]...)
+
+	v.Path, v.PathLengths, v.PathLimit = path, pathLengths, 0
+	for _, length := range pathLengths {
+		v.PathLimit += length
+	}
+	log.Debug().Msgf("Vehicle %s rerouted from %d to %d, saving %.1fs", v.ID, route[0], route[len(route)-1], current-time)
+	return true
+}
+
+// arcTime returns the travel time of the edge from source to target
+func arcTime(arcs map[int][]timedArc, source, target int) float64 {
+	for _, a := range arcs[source] {
+		if a.target == target {
+			return a.time
+		}
+	}
+	return math.Inf(1)
+}
+
+// fastestRoute returns the vertices of the fastest route from source to
+// target by the travel times, the lengths of its edges and its travel time,
+// or a nil route if there is none. Ties are broken by vertex ID.
+func fastestRoute(arcs map[int][]timedArc, source, target int, g graph.Graph[int, JVertex]) ([]int, []float64, float64) {
+	times := map[int]float64{source: 0}
+	previous := make(map[int]int)
+	done := make(map[int]bool)
+	queue := &timeQueue{{vertex: source}}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(timedVertex)
+		if done[current.vertex] {
+			continue
+		}
+		done[current.vertex] = true
+		if current.vertex == target {
+			break
+		}
+
+		for _, a := range arcs[current.vertex] {
+			time := current.time + a.time
+			if known, ok := times[a.target]; ok && known <= time {
+				continue
+			}
+			times[a.target] = time
+			previous[a.target] = current.vertex
+			heap.Push(queue, timedVertex{vertex: a.target, time: time})
+		}
+	}
+	if !done[target] {
+		return nil, nil, math.Inf(1)
+	}
+
+	route := []int{target}
+	for vertex := target; vertex != source; {
+		vertex = previous[vertex]
+		route = append(route, vertex)
+	}
+	for i, j := 0, len(route)-1; i < j; i, j = i+1, j-1 {
+		route[i], route[j] = route[j], route[i]
+	}
+
+	lengths := make([]float64, 0, len(route)-1)
+	for i := 1; i < len(route); i++ {
+		edge, err := g.Edge(route[i-1], route[i])
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get edge.")
+			return nil, nil, math.Inf(1)
+		}
+		lengths = append(lengths, edge.Properties.Data.(Data).Length)
+	}
+	return route, lengths, times[target]
+}
+
+// timedVertex is a vertex in the queue with its tentative travel time
+type timedVertex struct {
+	vertex int
+	time   float64
+}
+
+// timeQueue is a min-heap of vertices by travel time, then ID
+type timeQueue []timedVertex
+
+func (q timeQueue) Len() int { return len(q) }
+
+func (q timeQueue) Less(i, j int) bool {
+	if q[i].time != q[j].time {
+		return q[i].time < q[j].time
+	}
+	return q[i].vertex < q[j].vertex
+}
+
+func (q timeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *timeQueue) Push(x any) { *q = append(*q, x.(timedVertex)) }
+
+func (q *timeQueue) Pop() any {
+	old := *q
+	v := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return v
+}
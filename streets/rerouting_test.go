@@ -0,0 +1,67 @@
+package streets
+
+import (
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// buildDetour builds a road 1 -> 2 -> 3 -> 5 with a detour 2 -> 4 -> 3 of
+// twice the length of the direct edge 2 -> 3, every edge 100 m long
+func buildDetour(t *testing.T) *StreetGraph {
+	t.Helper()
+	return buildRoad(t, [][2]float64{{10, 50}, {10.001, 50}, {10.002, 50}, {10.0015, 50.001}, {10.003, 50}},
+		[][2]int{{1, 2}, {2, 3}, {2, 4}, {4, 3}, {3, 5}}, 100)
+}
+
+func TestData_TravelTime(t *testing.T) {
+	setupLogger(t)
+	g := buildDetour(t)
+	edge, err := g.Graph.Edge(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 100/(50/3.6), edge.Properties.Data.(Data).TravelTime())
+
+	// a standing queue is passed at crawl speed
+	standing := NewVehicle("standing", 0, []int{2, 3, 5}, &g.Graph)
+	if err := standing.AddVehicleToEdge(&edge); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 100/crawlSpeed, edge.Properties.Data.(Data).TravelTime())
+}
+
+func TestEngine_Reroutes(t *testing.T) {
+	setupLogger(t)
+	for _, c := range []struct {
+		share float64
+		path  []int
+	}{
+		{0, []int{1, 2, 3, 5}},
+		{1, []int{1, 2, 4, 3, 5}},
+	} {
+		g := buildDetour(t)
+		engine := NewEngine(1)
+		engine.Model = constantSpeed{}
+		engine.Rerouting = NewRerouting(c.share)
+		engine.Rerouting.Interval = 1
+
+		// a vehicle standing on the direct edge jams it
+		standing := NewVehicle("standing", 0, []int{2, 3, 5}, &g.Graph)
+		driver := NewVehicle("driver", 10, []int{1, 2, 3, 5}, &g.Graph)
+		engine.Add(&standing)
+		engine.Add(&driver)
+
+		for !driver.IsParked && engine.Tick < 100 {
+			engine.Step()
+		}
+
+		assert.True(t, driver.IsParked)
+		assert.Equal(t, c.path, driver.Path)
+		assert.Equal(t, len(c.path)-1, len(driver.PathLengths))
+		assert.Equal(t, float64(len(c.path)-1)*100, driver.PathLimit)
+		assert.Equal(t, driver.PathLimit, driver.DistanceTravelled)
+		assert.Equal(t, len(c.path)-4, engine.Reroutes)
+	}
+}
@@ -0,0 +1,75 @@
+package streets
+
+import (
+	"math"
+	"sort"
+)
+
+// VehicleSummary is the trip of a vehicle from its origin to its destination
+type VehicleSummary struct {
+	ID            string  `json:"id"`
+	Origin        int     `json:"origin"`
+	Destination   int     `json:"destination"`
+	DepartureTick int     `json:"departure_tick"`
+	ArrivalTick   int     `json:"arrival_tick"`
+	Distance      float64 `json:"distance"`
+	// AverageSpeed is the distance divided by the travel time in m/s
+	AverageSpeed float64 `json:"average_speed"`
+}
+
+// Summary summarises the trip of the vehicle in ticks of dt seconds
+func (v *Vehicle) Summary(dt float64) VehicleSummary {
+	s := VehicleSummary{
+		ID:            v.ID,
+		DepartureTick: v.DepartureTick,
+		ArrivalTick:   v.ArrivalTick,
+		Distance:      math.Min(v.DistanceTravelled, v.PathLimit),
+	}
+	if len(v.Path) > 0 {
+		s.Origin = v.Path[0]
+		s.Destination = v.Path[len(v.Path)-1]
+	}
+	if ticks := v.ArrivalTick - v.DepartureTick; ticks > 0 && dt > 0 {
+		s.AverageSpeed = s.Distance / (float64(ticks) * dt)
+	}
+	return s
+}
+
+// EdgeCounter counts the traffic on an edge
+type EdgeCounter struct {
+	Source int `json:"source"`
+	Target int `json:"target"`
+	// Entered is the number of vehicles which entered the edge
+	Entered int `json:"entered"`
+	// VehicleTicks is the number of ticks vehicles spent on the edge, summed over all vehicles
+	VehicleTicks int `json:"vehicle_ticks"`
+}
+
+// MergeEdgeCounters sums the counters of the same edge, sorted by source and target
+func MergeEdgeCounters(counters ...[]EdgeCounter) []EdgeCounter {
+	merged := make(map[edgeKey]*EdgeCounter)
+	for _, cs := range counters {
+		for _, c := range cs {
+			key := edgeKey{source: c.Source, target: c.Target}
+			if m, ok := merged[key]; ok {
+				m.Entered += c.Entered
+				m.VehicleTicks += c.VehicleTicks
+				continue
+			}
+			c := c
+			merged[key] = &c
+		}
+	}
+
+	result := make([]EdgeCounter, 0, len(merged))
+	for _, c := range merged {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Source != result[j].Source {
+			return result[i].Source < result[j].Source
+		}
+		return result[i].Target < result[j].Target
+	})
+	return result
+}
@@ -0,0 +1,255 @@
+package streets
+
+import (
+	"math"
+	"sort"
+)
+
+// Durations of the phases of auto-generated signal plans in seconds
+const (
+	DefaultGreen  = 30.0
+	DefaultYellow = 3.0
+	DefaultRed    = 2.0
+)
+
+// yellowDeceleration is the deceleration in m/s² up to which a vehicle stops
+// at a yellow signal instead of driving through
+const yellowDeceleration = 3.0
+
+// SignalState is the state of a signal for an approach
+type SignalState int
+
+const (
+	SignalGreen SignalState = iota
+	SignalYellow
+	SignalRed
+)
+
+// String returns the name of the state
+func (s SignalState) String() string {
+	switch s {
+	case SignalGreen:
+		return "green"
+	case SignalYellow:
+		return "yellow"
+	default:
+		return "red"
+	}
+}
+
+// SignalPhase gives green to some approaches of a signalized vertex, every
+// other approach is red
+type SignalPhase struct {
+	// From are the source vertices of the incoming edges with green
+	From []int `json:"from"`
+	// Green is the duration of green in seconds
+	Green float64 `json:"green"`
+	// Yellow is the duration of yellow after the green in seconds
+	Yellow float64 `json:"yellow"`
+	// Red is the duration of the all-red clearance after the yellow in seconds
+	Red float64 `json:"red"`
+}
+
+// SignalPlan is the fixed-time plan of the traffic signal at a vertex. The
+// phases follow each other in a cycle. Approaches not named by any phase are
+// not controlled by the signal.
+type SignalPlan struct {
+	Vertex int `json:"vertex"`
+	// Offset is the time in seconds into the simulation the first phase starts at
+	Offset float64       `json:"offset"`
+	Phases []SignalPhase `json:"phases"`
+}
+
+// Cycle returns the duration of a cycle of the plan in seconds
+func (p *SignalPlan) Cycle() float64 {
+	cycle := 0.0
+	for _, phase := range p.Phases {
+		cycle += phase.Green + phase.Yellow + phase.Red
+	}
+	return cycle
+}
+
+// controls checks if the approach from the source vertex is named by any phase
+func (p *SignalPlan) controls(from int) bool {
+	for _, phase := range p.Phases {
+		for _, f := range phase.From {
+			if f == from {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// State returns the state of the signal for the approach from the source
+// vertex, t seconds into the simulation
+func (p *SignalPlan) State(from int, t float64) SignalState {
+	cycle := p.Cycle()
+	if cycle <= 0 || !p.controls(from) {
+		return SignalGreen
+	}
+
+	t = math.Mod(t-p.Offset, cycle)
+	if t < 0 {
+		t += cycle
+	}
+
+	for _, phase := range p.Phases {
+		duration := phase.Green + phase.Yellow + phase.Red
+		if t >= duration {
+			t -= duration
+			continue
+		}
+		for _, f := range phase.From {
+			if f != from {
+				continue
+			}
+			switch {
+			case t < phase.Green:
+				return SignalGreen
+			case t < phase.Green+phase.Yellow:
+				return SignalYellow
+			}
+		}
+		return SignalRed
+	}
+
+	return SignalRed
+}
+
+// AutoSignals generates signal plans for the vertices with three or more
+// incoming edges. Approaches along the same axis share a phase, so a crossing
+// gets two phases. If all approaches lie on one axis, each gets its own.
+func AutoSignals(vertices []JVertex, edges []JEdge) []SignalPlan {
+	coords := make(map[int]JVertex, len(vertices))
+	for _, v := range vertices {
+		coords[v.ID] = v
+	}
+
+	incoming := make(map[int][]int)
+	for _, e := range edges {
+		if e.From == e.To {
+			continue
+		}
+		incoming[e.To] = append(incoming[e.To], e.From)
+	}
+
+	plans := make([]SignalPlan, 0)
+	for vertex, sources := range incoming {
+		sources = uniqueInts(sources)
+		to, ok := coords[vertex]
+		if len(sources) < 3 || !ok {
+			continue
+		}
+
+		// the axis of an approach, ignoring its direction
+		axis := func(from int) float64 {
+			f := coords[from]
+			a := math.Atan2(to.Y-f.Y, to.X-f.X)
+			return math.Mod(a+2*math.Pi, math.Pi)
+		}
+		first := axis(sources[0])
+
+		groups := [2][]int{}
+		for _, from := range sources {
+			d := math.Abs(axis(from) - first)
+			d = math.Min(d, math.Pi-d)
+			if d < math.Pi/4 {
+				groups[0] = append(groups[0], from)
+			} else {
+				groups[1] = append(groups[1], from)
+			}
+		}
+
+		plan := SignalPlan{Vertex: vertex}
+		if len(groups[1]) == 0 {
+			for _, from := range sources {
+				plan.Phases = append(plan.Phases, defaultPhase([]int{from}))
+			}
+		} else {
+			plan.Phases = []SignalPhase{defaultPhase(groups[0]), defaultPhase(groups[1])}
+		}
+		plans = append(plans, plan)
+	}
+
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Vertex < plans[j].Vertex })
+	return plans
+}
+
+// defaultPhase returns a phase with the default durations
+func defaultPhase(from []int) SignalPhase {
+	return SignalPhase{From: from, Green: DefaultGreen, Yellow: DefaultYellow, Red: DefaultRed}
+}
+
+// uniqueInts returns the sorted unique values
+func uniqueInts(values []int) []int {
+	sort.Ints(values)
+	unique := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// SignalPlans returns the signal plans of the graph, sorted by vertex. With
+// auto, plans are generated for the vertices without one, see AutoSignals.
+func (g *StreetGraph) SignalPlans(auto bool) ([]SignalPlan, error) {
+	plans := make([]SignalPlan, 0, len(g.Signals))
+	plans = append(plans, g.Signals...)
+
+	if auto {
+		vertices, err := g.GetVertices()
+		if err != nil {
+			return nil, err
+		}
+		edges, err := g.jEdges()
+		if err != nil {
+			return nil, err
+		}
+
+		signalized := make(map[int]bool, len(plans))
+		for _, p := range plans {
+			signalized[p.Vertex] = true
+		}
+		for _, p := range AutoSignals(vertices, edges) {
+			if !signalized[p.Vertex] {
+				plans = append(plans, p)
+			}
+		}
+	}
+
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Vertex < plans[j].Vertex })
+	return plans, nil
+}
+
+// stopLine returns the distance from the vehicle to the stop line at the end
+// of its current edge if the signal there is red for it t seconds into the
+// simulation, or +Inf. On yellow the vehicle only stops if it can do so
+// comfortably. A vehicle never stops at its destination.
+func stopLine(v *Vehicle, signals map[int]*SignalPlan, t float64) float64 {
+	if len(signals) == 0 || v.IsParked {
+		return math.Inf(1)
+	}
+
+	idx, position := v.deductCurrentPathVertexIndex()
+	if idx >= len(v.Path)-2 {
+		return math.Inf(1)
+	}
+	plan, ok := signals[v.Path[idx+1]]
+	if !ok {
+		return math.Inf(1)
+	}
+
+	remaining := v.PathLengths[idx] - position
+	switch plan.State(v.Path[idx], t) {
+	case SignalRed:
+		return remaining
+	case SignalYellow:
+		if remaining >= v.Speed*v.Speed/(2*yellowDeceleration) {
+			return remaining
+		}
+	}
+	return math.Inf(1)
+}
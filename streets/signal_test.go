@@ -0,0 +1,158 @@
+package streets
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/cornelk/hashmap/assert"
+)
+
+// Vertices of the crossing built by buildCrossing
+const (
+	crossingCenter = iota + 1
+	crossingNorth
+	crossingSouth
+	crossingEast
+	crossingWest
+)
+
+// buildCrossing builds a crossing of a north-south and an east-west two-way
+// street, every arm 100 m long. The north-south street has a speed limit of
+// 50 km/h, the east-west one of eastWest.
+func buildCrossing(t *testing.T, eastWest string) *StreetGraph {
+	t.Helper()
+
+	vertices := []JVertex{
+		{ID: crossingCenter, X: 10, Y: 50},
+		{ID: crossingNorth, X: 10, Y: 50.001},
+		{ID: crossingSouth, X: 10, Y: 49.999},
+		{ID: crossingEast, X: 10.001, Y: 50},
+		{ID: crossingWest, X: 9.999, Y: 50},
+	}
+	edges := make([]JEdge, 0)
+	for _, arm := range []int{crossingNorth, crossingSouth, crossingEast, crossingWest} {
+		speed := "50"
+		if arm == crossingEast || arm == crossingWest {
+			speed = eastWest
+		}
+		for _, e := range [][2]int{{arm, crossingCenter}, {crossingCenter, arm}} {
+			edges = append(edges, JEdge{
+				From:     e[0],
+				To:       e[1],
+				Length:   100,
+				MaxSpeed: speed,
+				ID:       strconv.Itoa(e[0]) + "-" + strconv.Itoa(e[1]),
+			})
+		}
+	}
+
+	g, err := NewGraphBuilder().WithVertices(vertices).WithEdges(edges).
+		WithRectangleParts(1).SetTopRightBottomLeftVertices().DivideGraphsIntoRects().
+		PickRect(0).FilterForRect().IsRoot().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestSignalPlan_State(t *testing.T) {
+	plan := SignalPlan{
+		Vertex: crossingCenter,
+		Offset: 10,
+		Phases: []SignalPhase{
+			{From: []int{crossingNorth, crossingSouth}, Green: 20, Yellow: 3, Red: 2},
+			{From: []int{crossingEast}, Green: 10, Yellow: 3, Red: 2},
+		},
+	}
+	assert.Equal(t, 40.0, plan.Cycle())
+
+	for _, tc := range []struct {
+		from  int
+		t     float64
+		state SignalState
+	}{
+		{crossingNorth, 10, SignalGreen},
+		{crossingSouth, 29.5, SignalGreen},
+		{crossingNorth, 31, SignalYellow},
+		{crossingNorth, 34, SignalRed},
+		{crossingEast, 34, SignalRed},
+		{crossingEast, 35, SignalGreen},
+		{crossingEast, 46, SignalYellow},
+		{crossingNorth, 49, SignalRed},
+		// the cycle repeats, also before the offset
+		{crossingNorth, 50, SignalGreen},
+		{crossingEast, 0, SignalGreen},
+		// approaches without a phase are not controlled
+		{crossingWest, 34, SignalGreen},
+	} {
+		assert.Equal(t, tc.state, plan.State(tc.from, tc.t))
+	}
+}
+
+func TestAutoSignals(t *testing.T) {
+	setupLogger(t)
+	g := buildCrossing(t, "50")
+	plans, err := g.SignalPlans(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the center has three or more incoming edges
+	assert.Equal(t, 1, len(plans))
+	plan := plans[0]
+	assert.Equal(t, crossingCenter, plan.Vertex)
+
+	// north and south share a phase, east and west the other
+	assert.Equal(t, 2, len(plan.Phases))
+	assert.Equal(t, []int{crossingNorth, crossingSouth}, plan.Phases[0].From)
+	assert.Equal(t, []int{crossingEast, crossingWest}, plan.Phases[1].From)
+
+	// plans of the graph file are kept
+	g.Signals = []SignalPlan{{Vertex: crossingCenter}}
+	plans, err = g.SignalPlans(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(plans))
+	assert.Equal(t, 0, len(plans[0].Phases))
+}
+
+func TestEngine_StopsAtRed(t *testing.T) {
+	setupLogger(t)
+	g := buildCrossing(t, "50")
+
+	engine := NewEngine(1)
+	// red for the south approach during the first minute
+	engine.SetSignals([]SignalPlan{{
+		Vertex: crossingCenter,
+		Phases: []SignalPhase{
+			{From: []int{crossingEast}, Green: 57, Yellow: 3},
+			{From: []int{crossingSouth}, Green: 57, Yellow: 3},
+		},
+	}})
+
+	v := NewVehicle("1", 10, []int{crossingSouth, crossingCenter, crossingNorth}, &g.Graph)
+	engine.Add(&v)
+
+	for engine.Time() < 60 {
+		engine.Step()
+		idx, position := v.deductCurrentPathVertexIndex()
+		assert.Equal(t, 0, idx)
+		assert.True(t, position < 100)
+	}
+	// the vehicle waits at the stop line
+	assert.True(t, v.Speed < 0.1)
+	assert.True(t, v.DistanceTravelled > 90)
+
+	// and crosses on green
+	for engine.Len() > 0 && engine.Tick < 200 {
+		engine.Step()
+	}
+	assert.True(t, v.IsParked)
+	assert.True(t, v.ArrivalTick > 60)
+
+	// without signals the vehicle does not stop
+	free := NewVehicle("2", 10, []int{crossingSouth, crossingCenter, crossingNorth}, &g.Graph)
+	assert.True(t, math.IsInf(stopLine(&free, nil, 0), 1))
+}
@@ -3,25 +3,43 @@ package streets
 import (
 	"fmt"
 	"math"
-	"sort"
 
 	"pchpc/utils"
 
-	"github.com/aidarkhanov/nanoid"
 	"github.com/dominikbraun/graph"
 	"github.com/rs/zerolog/log"
 )
 
+// leaderLookahead is the distance in metres a vehicle looks ahead for its leader
+const leaderLookahead = 150.0
+
+// defaultModel is the car-following model of vehicles stepped on their own
+var defaultModel CarFollowingModel = NewIDM()
+
 // Vehicle is a vehicle
 type Vehicle struct {
 	ID                string                     `json:"id,omitempty"`
 	Path              []int                      `json:"path,omitempty"`
 	DistanceTravelled float64                    `json:"distance_travelled,omitempty"`
 	Speed             float64                    `json:"speed,omitempty"`
-	g                 *graph.Graph[int, JVertex] `json:"g,omitempty"`
+	g                 *graph.Graph[int, JVertex] `json:"-"`
 	IsParked          bool                       `json:"is_parked,omitempty"`
 	PathLengths       []float64                  `json:"path_lengths,omitempty"`
 	PathLimit         float64                    `json:"path_limit,omitempty"`
+	// DepartureTick is the tick the vehicle departs in, until then it waits
+	// in the engine
+	DepartureTick int `json:"departure_tick,omitempty"`
+	ArrivalTick   int `json:"arrival_tick,omitempty"`
+	// Lane is the lane of the vehicle on its current edge, 0 is the rightmost
+	Lane int `json:"lane,omitempty"`
+
+	// nextSpeed is the speed decided for the next tick
+	nextSpeed float64
+	// edgeIndex is the index of the edge the vehicle was counted on last
+	edgeIndex int
+	// rng is the random stream of the vehicle, it travels with the vehicle
+	// between ranks
+	rng utils.RNG
 }
 
 // getPathLengths calculates the length of each edge in the path
@@ -38,7 +56,7 @@ func (v *Vehicle) getPathLengths() error {
 			return err
 		}
 
-		length := edge.Properties.Data.(EdgeData).Length
+		length := edge.Properties.Data.(Data).Length
 		lengthsArray = append(lengthsArray, length)
 		sum += length
 	}
@@ -68,6 +86,11 @@ func (v *Vehicle) deductCurrentPathVertexIndex() (index int, delta float64) {
 		tmpDistance -= length
 	}
 
+	// past the end of the path the vehicle stays on its last edge
+	if n := len(v.PathLengths); n > 0 {
+		return n - 1, v.PathLengths[n-1]
+	}
+
 	return 0, 0.0
 }
 
@@ -86,11 +109,31 @@ func (v *Vehicle) getEdgeByIndex(index int) (oEdge *graph.Edge[JVertex], err err
 	return &ed, nil
 }
 
+// hasEdgeByIndex checks if the edge at the given index is owned by the vehicle's
+// graph. Ghost edges are not, the vehicle has to be handed off to drive on them.
+func (v *Vehicle) hasEdgeByIndex(index int) bool {
+	if index < 0 || index >= len(v.Path)-1 {
+		return false
+	}
+	edge, err := (*v.g).Edge(v.Path[index], v.Path[index+1])
+	return err == nil && !isGhostEdge(edge)
+}
+
+// seesEdgeByIndex checks if the edge at the given index is part of the vehicle's
+// graph, ghost edges included
+func (v *Vehicle) seesEdgeByIndex(index int) bool {
+	if index < 0 || index >= len(v.Path)-1 {
+		return false
+	}
+	_, err := (*v.g).Edge(v.Path[index], v.Path[index+1])
+	return err == nil
+}
+
 // getHashMapByEdge returns the hashmap of the given edge
 func (v *Vehicle) getHashMapByEdge(edge *graph.Edge[JVertex]) (*utils.HashMap[string, *Vehicle], error) {
-	data, exists := edge.Properties.Data.(EdgeData)
+	data, exists := edge.Properties.Data.(Data)
 	if !exists {
-		err := fmt.Errorf("edge data is not of type EdgeData")
+		err := fmt.Errorf("edge data is not of type Data")
 		log.Error().Err(err).Msg("Failed to get data from edge.")
 		return nil, err
 	}
@@ -103,27 +146,17 @@ func (v *Vehicle) isInMap(hashMap *utils.HashMap[string, *Vehicle]) bool {
 	return exists
 }
 
-// AddVehicleToEdge adds the vehicle to the given hashmap
+// AddVehicleToEdge adds the vehicle to the hashmap of the given edge
 func (v *Vehicle) AddVehicleToEdge(edge *graph.Edge[JVertex]) error {
-	edgeData := edge.Properties.Data.(EdgeData)
-	msEdgeSpeed := edgeData.MaxSpeed / 3.6
-	hashMap := edgeData.Map
-	if v.isInMap(hashMap) {
-		return nil
-	}
-
-	frontVehicle, err := v.GetFrontVehicleFromEdge(edge)
+	hashMap, err := v.getHashMapByEdge(edge)
 	if err != nil {
 		return err
 	}
-
-	if frontVehicle != nil && frontVehicle.Speed < v.Speed {
-		v.Speed = frontVehicle.Speed
-	} else if frontVehicle != nil && frontVehicle.Speed > v.Speed && msEdgeSpeed > v.Speed {
-		minAcceleration := 0.1
-		maxAcceleration := 0.5
-		v.Speed += utils.RandomFloat64(minAcceleration, maxAcceleration)
+	if v.isInMap(hashMap) {
+		return nil
 	}
+	// on an edge with fewer lanes the vehicle merges into the leftmost one
+	v.Lane = clampLane(v.Lane, edge.Properties.Data.(Data).Lanes)
 
 	hashMap.Set(v.ID, v)
 	v.updateVehiclePosition(hashMap)
@@ -167,10 +200,10 @@ func (v *Vehicle) String() string {
 		v.DistanceTravelled, v.PathLimit)
 }
 
-// NewVehicle creates a new vehicle
-func NewVehicle(speed float64, path []int, graph *graph.Graph[int, JVertex]) Vehicle {
+// NewVehicle creates a new vehicle. The ID has to be unique among all vehicles of a run.
+func NewVehicle(id string, speed float64, path []int, graph *graph.Graph[int, JVertex]) Vehicle {
 	v := Vehicle{
-		ID:                nanoid.New(),
+		ID:                id,
 		Path:              path,
 		Speed:             speed,
 		g:                 graph,
@@ -185,58 +218,131 @@ func NewVehicle(speed float64, path []int, graph *graph.Graph[int, JVertex]) Veh
 	return v
 }
 
-// Step moves the vehicle one step forward
+// SetRNG sets the random stream of the vehicle, used by stochastic car-following models
+func (v *Vehicle) SetRNG(rng utils.RNG) {
+	v.rng = rng
+}
+
+// Step moves the vehicle one second forward, following the default IDM
 func (v *Vehicle) Step() {
+	v.Decide(defaultModel, 1)
+	v.Commit(1)
+}
+
+// Decide computes the speed of the vehicle for the next tick of dt seconds with
+// the car-following model, from the gap to its leader and the speed limit of its
+// edge. It does not move the vehicle, so all vehicles can decide on the same
+// snapshot before any of them commits.
+func (v *Vehicle) Decide(model CarFollowingModel, dt float64) {
+	v.decide(model, dt, math.Inf(1))
+}
+
+// decide is Decide with a stop line stop metres ahead, which the vehicle
+// treats like a standing leader
+func (v *Vehicle) decide(model CarFollowingModel, dt, stop float64) {
+	v.nextSpeed = v.Speed
+	if v.IsParked {
+		return
+	}
+
+	edge, err := v.getCurrentEdge()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get current edge.")
+		return
+	}
+
+	desired := edge.Properties.Data.(Data).MaxSpeed / 3.6
+	frontVehicle, gap := v.leader(leaderLookahead)
+	leaderSpeed := 0.0
+	if frontVehicle != nil {
+		leaderSpeed = frontVehicle.Speed
+	}
+	if stop < gap {
+		gap, leaderSpeed = stop, 0
+	}
+
+	v.nextSpeed = model.NextSpeed(v.Speed, desired, gap, leaderSpeed, dt, &v.rng)
+}
+
+// Commit moves the vehicle dt seconds forward with the speed chosen by Decide,
+// leaves the edges it has passed and enters its current edge
+func (v *Vehicle) Commit(dt float64) {
+	if v.IsParked {
+		return
+	}
+
+	v.Speed = v.nextSpeed
+	moved := v.drive(dt)
+	v.leaveEdges(moved)
+
 	idx, delta := v.deductCurrentPathVertexIndex()
 	log.Debug().Msgf("Current index: %d, delta: %f", idx, delta)
-	log.Debug().Msgf("Current path: %v", v.Path)
 	log.Debug().Msgf("Current vehicle: %v", v)
+
+	// vehicle is at destination
+	if v.PathLimit <= v.DistanceTravelled {
+		v.IsParked = true
+		return
+	}
+
+	// the edge lives on another rank, the vehicle has to be handed off
+	if !v.hasEdgeByIndex(idx) {
+		return
+	}
+
 	edge, err := v.getEdgeByIndex(idx)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get edge.")
 		return
 	}
+	err = v.AddVehicleToEdge(edge)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to add vehicle to map.")
+	}
+}
+
+// leaveEdges removes the vehicle from every edge it has left while moving the
+// given distance, edges shorter than a single tick included
+func (v *Vehicle) leaveEdges(moved float64) {
+	start := v.DistanceTravelled - moved
+	end := 0.0
 
-	if v.Speed >= delta && idx != 0 {
-		isInGraph := VertexInGraph(v.g, edge.Target)
-		if !isInGraph {
-			panic("not in graph")
+	for i, length := range v.PathLengths {
+		end += length
+		if end <= start {
+			continue
 		}
-		oldEdge, err := v.getEdgeByIndex(idx - 1)
+		if end > v.DistanceTravelled {
+			return
+		}
+		// edges of other ranks are passed during a hand-off
+		if !v.hasEdgeByIndex(i) {
+			continue
+		}
+
+		edge, err := v.getEdgeByIndex(i)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to get edge.")
 			return
 		}
-
-		oldHashMap, err := v.getHashMapByEdge(oldEdge)
+		hashMap, err := v.getHashMapByEdge(edge)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to get hashmap.")
 			return
 		}
-		v.RemoveVehicleFromMap(oldHashMap)
+		v.RemoveVehicleFromMap(hashMap)
 	}
-
-	hashMap, err := v.getHashMapByEdge(edge)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to get hashmap.")
-		return
-	}
-	err = v.AddVehicleToEdge(edge)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to add vehicle to map.")
-		return
-	}
-	// vehicle is at destination
-	if v.IsParked {
-		return
-	}
-	v.drive()
-	v.updateVehiclePosition(hashMap)
 }
 
-// drive moves the vehicle forward
-func (v *Vehicle) drive() {
-	v.DistanceTravelled += v.Speed
+// drive moves the vehicle forward for dt seconds and returns the distance
+// driven. In front of a full edge the vehicle stops and waits.
+func (v *Vehicle) drive(dt float64) float64 {
+	moved := v.Speed * dt
+	if limit := v.entryLimit(moved); limit < moved {
+		moved, v.Speed = limit, 0
+	}
+	v.DistanceTravelled += moved
+	return moved
 }
 
 // PrintInfo prints the vehicle info
@@ -249,29 +355,83 @@ func (v *Vehicle) PrintInfo() {
 		Msg("Vehicle info")
 }
 
-// GetFrontVehicleFromEdge returns the vehicle in front of the given vehicle
+// GetFrontVehicleFromEdge returns the closest vehicle ahead of the given vehicle on the edge
 func (v *Vehicle) GetFrontVehicleFromEdge(edge *graph.Edge[JVertex]) (*Vehicle, error) {
-	edgeData := edge.Properties.Data.(EdgeData)
+	eMap, err := v.getHashMapByEdge(edge)
+	if err != nil {
+		return nil, err
+	}
 
-	eMap := edgeData.Map
+	_, position := v.deductCurrentPathVertexIndex()
+	front, _ := vehicleAhead(eMap, v.ID, position, v.Lane)
+	return front, nil
+}
 
-	if eMap.Len() < 1 {
-		return nil, nil
+// vehicleAhead returns the closest vehicle in the hashmap ahead of the given
+// position on the edge, together with its position. On the same position the
+// vehicle with the greater ID is ahead, so two vehicles never follow each other.
+// Only vehicles in the lane count.
+func vehicleAhead(hashMap *utils.HashMap[string, *Vehicle], id string, position float64, lane int) (*Vehicle, float64) {
+	var front *Vehicle
+	frontPosition := math.Inf(1)
+
+	for _, vh := range hashMap.ToList() {
+		if vh.ID == id || vh.Lane != lane {
+			continue
+		}
+		_, p := vh.deductCurrentPathVertexIndex()
+		if p < position || (p == position && vh.ID < id) {
+			continue
+		}
+		if front == nil || p < frontPosition || (p == frontPosition && vh.ID < front.ID) {
+			front, frontPosition = vh, p
+		}
 	}
 
-	lst := eMap.ToList()
+	return front, frontPosition
+}
 
-	sort.Slice(lst, func(i, j int) bool {
-		return lst[i].DistanceTravelled > lst[j].DistanceTravelled
-	})
+// leader returns the closest vehicle ahead in the vehicle's lane within
+// lookahead metres and the free gap to it, or +Inf without a leader. Ghost
+// edges are searched as well, so leaders across the boundary of a rect count.
+func (v *Vehicle) leader(lookahead float64) (*Vehicle, float64) {
+	return v.leaderIn(v.Lane, lookahead)
+}
 
-	var frontIndex int
+// leaderIn is leader for the vehicle driving in lane. On the next edges of
+// its path the vehicle is expected in the same lane, or the leftmost one if
+// they have fewer lanes.
+func (v *Vehicle) leaderIn(lane int, lookahead float64) (*Vehicle, float64) {
+	idx, position := v.deductCurrentPathVertexIndex()
+	// distance from the vehicle to the start of edge i
+	distance := -position
 
-	for i, vh := range lst {
-		if v.ID == vh.ID && i < 0 {
-			frontIndex = i - 1
+	for i := idx; i < len(v.PathLengths) && distance < lookahead && v.seesEdgeByIndex(i); i++ {
+		edge, err := v.getEdgeByIndex(i)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get edge.")
+			break
+		}
+		hashMap, err := v.getHashMapByEdge(edge)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get hashmap.")
+			break
+		}
+
+		id, from := "", math.Inf(-1)
+		if i == idx {
+			id, from = v.ID, position
 		}
+		edgeLane := lane
+		if i > idx {
+			edgeLane = clampLane(lane, edge.Properties.Data.(Data).Lanes)
+		}
+		if front, p := vehicleAhead(hashMap, id, from, edgeLane); front != nil {
+			return front, distance + p - VehicleLength
+		}
+
+		distance += v.PathLengths[i]
 	}
 
-	return lst[frontIndex], nil
+	return nil, math.Inf(1)
 }
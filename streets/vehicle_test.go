@@ -19,6 +19,14 @@ func setupDB(t *testing.T) {
 	utils.SetDBPath("../assets/db.sqlite")
 }
 
+func setupStreetGraph(t *testing.T) graph.Graph[int, JVertex] {
+	t.Helper()
+
+	setupDB(t)
+	root, _ := DefaultGraph(utils.GetDbPath(), 1)
+	return root.Graph
+}
+
 func setupLogger(t *testing.T) {
 	t.Helper()
 
@@ -29,21 +37,21 @@ func setupLogger(t *testing.T) {
 func TestVehicle_Step(t *testing.T) {
 	setupLogger(t)
 	setupDB(t)
-	g := NewGraph(utils.GetDbPath())
+	g := setupStreetGraph(t)
 
 	_, err := g.Edges()
 	if err != nil {
 		panic(err)
 	}
 
-	path, err := graph.ShortestPath(g, 1985463947, 213322463)
+	path, err := graph.ShortestPath(g, 269910246, 213322463)
 	if err != nil {
 		panic(err)
 	}
 
-	vh1 := NewVehicle(4.0, path, &g)
-	vh2 := NewVehicle(3.0, path, &g)
-	vh3 := NewVehicle(2.0, path, &g)
+	vh1 := NewVehicle("1", 4.0, path, &g)
+	vh2 := NewVehicle("2", 3.0, path, &g)
+	vh3 := NewVehicle("3", 2.0, path, &g)
 
 	for {
 		vh1.Step()
@@ -62,7 +70,7 @@ func TestVehicle_Step(t *testing.T) {
 		panic(err)
 	}
 
-	assert.Equal(t, 0, edge.Properties.Data.(EdgeData).Map.Len())
+	assert.Equal(t, 0, edge.Properties.Data.(Data).Map.Len())
 
 	// assert
 	edge, err = vh2.getCurrentEdge()
@@ -70,27 +78,27 @@ func TestVehicle_Step(t *testing.T) {
 		panic(err)
 	}
 
-	assert.Equal(t, 0, edge.Properties.Data.(EdgeData).Map.Len())
+	assert.Equal(t, 0, edge.Properties.Data.(Data).Map.Len())
 	// assert
 	edge, err = vh3.getCurrentEdge()
 	if err != nil {
 		panic(err)
 	}
 
-	assert.Equal(t, 0, edge.Properties.Data.(EdgeData).Map.Len())
+	assert.Equal(t, 0, edge.Properties.Data.(Data).Map.Len())
 }
 
 func TestVehicle_AddVehicleToMap(t *testing.T) {
 	setupDB(t)
 	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 	// update speed test
-	g := NewGraph(utils.GetDbPath())
-	// src := GVertex{ID: 2617388513}
-	// dst := GVertex{ID: 2290171245}
+	g := setupStreetGraph(t)
+	// src := JVertex{ID: 2617388513}
+	// dst := JVertex{ID: 2290171245}
 	path := []int{2617388513, 2290171245}
 
-	vh := NewVehicle(4.0, path, &g)
-	vh2 := NewVehicle(6.0, path, &g)
+	vh := NewVehicle("4", 4.0, path, &g)
+	vh2 := NewVehicle("5", 6.0, path, &g)
 
 	vh.Step()  // ensure vehicle is present
 	vh.Step()  // ensure vehicle is present
@@ -98,7 +106,7 @@ func TestVehicle_AddVehicleToMap(t *testing.T) {
 
 	edge, _ := vh.getCurrentEdge()
 
-	l := edge.Properties.Data.(EdgeData).Map.Len()
+	l := edge.Properties.Data.(Data).Map.Len()
 
 	assert.Equal(t, 2, l)
 
@@ -110,7 +118,9 @@ func TestVehicle_AddVehicleToMap(t *testing.T) {
 	//	}
 	//}
 
-	assert.Equal(t, vh.Speed, vh2.Speed)
+	// the faster vehicle brakes behind the slower one instead of overtaking it
+	assert.True(t, vh2.Speed < 6.0)
+	assert.True(t, vh2.DistanceTravelled < vh.DistanceTravelled)
 
 	// existingVehicle := NewVehicle(2.0, nil, &g)
 }
@@ -146,13 +156,13 @@ func TestGetFrontVehicleFromEdge(t *testing.T) {
 	hm.Set(v2.ID, &v2)
 	lonleyHm.Set(v1.ID, &v1)
 
-	e := graph.Edge[GVertex]{
-		Source: GVertex{ID: 0},
-		Target: GVertex{ID: 1},
+	e := graph.Edge[JVertex]{
+		Source: JVertex{ID: 0},
+		Target: JVertex{ID: 1},
 		Properties: graph.EdgeProperties{
 			Attributes: nil,
 			Weight:     0,
-			Data: EdgeData{
+			Data: Data{
 				MaxSpeed: 10,
 				Length:   10,
 				Map:      &hm,
@@ -166,13 +176,13 @@ func TestGetFrontVehicleFromEdge(t *testing.T) {
 	}
 
 	assert.Equal(t, frontVehicle.ID, v1.ID)
-	e = graph.Edge[GVertex]{
-		Source: GVertex{ID: 0},
-		Target: GVertex{ID: 1},
+	e = graph.Edge[JVertex]{
+		Source: JVertex{ID: 0},
+		Target: JVertex{ID: 1},
 		Properties: graph.EdgeProperties{
 			Attributes: nil,
 			Weight:     0,
-			Data: EdgeData{
+			Data: Data{
 				MaxSpeed: 10,
 				Length:   10,
 				Map:      &emptyHm,
@@ -188,13 +198,13 @@ func TestGetFrontVehicleFromEdge(t *testing.T) {
 		t.Errorf("Expected nil, got %v", frontVehicle)
 	}
 
-	e = graph.Edge[GVertex]{
-		Source: GVertex{ID: 0},
-		Target: GVertex{ID: 1},
+	e = graph.Edge[JVertex]{
+		Source: JVertex{ID: 0},
+		Target: JVertex{ID: 1},
 		Properties: graph.EdgeProperties{
 			Attributes: nil,
 			Weight:     0,
-			Data: EdgeData{
+			Data: Data{
 				MaxSpeed: 10,
 				Length:   10,
 				Map:      &lonleyHm,
@@ -0,0 +1,13 @@
+package utils
+
+var dbPath string
+
+// SetDBPath sets the path to the database
+func SetDBPath(path string) {
+	dbPath = path
+}
+
+// GetDbPath returns the path to the database
+func GetDbPath() string {
+	return dbPath
+}
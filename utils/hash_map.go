@@ -73,6 +73,13 @@ func (m *HashMap[T, U]) Del(key T) {
 	delete(m.m, key)
 }
 
+func (m *HashMap[T, U]) Clear() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.m = make(map[T]U)
+}
+
 func (m *HashMap[T, U]) Len() int {
 	m.Lock()
 	defer m.Unlock()
@@ -1,3 +1,5 @@
+//go:build mpi
+
 package utils
 
 import mpi "github.com/sbromberger/gompi"
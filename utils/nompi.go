@@ -0,0 +1,8 @@
+//go:build !mpi
+
+package utils
+
+// IsMPI returns false, the binary has been built without MPI support
+func IsMPI() bool {
+	return false
+}
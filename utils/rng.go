@@ -0,0 +1,60 @@
+package utils
+
+// RNG is a SplitMix64 random number generator. Its state is a single integer,
+// so it can be copied, sent to other ranks and checkpointed, and independent
+// streams are derived from it without advancing it.
+type RNG struct {
+	state uint64
+}
+
+// NewRNG returns a generator seeded with seed
+func NewRNG(seed int64) RNG {
+	return RNG{state: uint64(seed)}
+}
+
+// RestoreRNG returns a generator continuing from a state returned by State
+func RestoreRNG(state uint64) RNG {
+	return RNG{state: state}
+}
+
+// State returns the state of the generator
+func (r *RNG) State() uint64 {
+	return r.state
+}
+
+// mix is the output function of SplitMix64
+func mix(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// Stream returns the independent stream with the given ID. It only depends on
+// the state of r and the ID, not on the order streams are derived in.
+func (r *RNG) Stream(id uint64) RNG {
+	return RNG{state: mix(r.state ^ mix(id+0x9E3779B97F4A7C15))}
+}
+
+// Uint64 returns a random uint64
+func (r *RNG) Uint64() uint64 {
+	r.state += 0x9E3779B97F4A7C15
+	return mix(r.state)
+}
+
+// Float64 returns a random float64 in [0, 1)
+func (r *RNG) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
+
+// Intn returns a random int in [0, n), n must be positive
+func (r *RNG) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	return int(r.Uint64() % uint64(n))
+}
+
+// Between returns a random float64 between min and max
+func (r *RNG) Between(min, max float64) float64 {
+	return min + r.Float64()*(max-min)
+}